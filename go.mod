@@ -2,6 +2,6 @@ module github.com/caketop/python-starlark-go
 
 go 1.20
 
-require go.starlark.net v0.0.0-20230302034142-4b1e35fe2254
+require go.starlark.net v0.0.0-20231121155337-90ade8b19d09
 
 require golang.org/x/sys v0.6.0 // indirect
@@ -0,0 +1,382 @@
+package main
+
+/*
+#include "starlark.h"
+*/
+import "C"
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unsafe"
+
+	"go.starlark.net/starlark"
+)
+
+// batchJob is one script passed to run_batch, which is executed into its own
+// Starlark object.
+type batchJob struct {
+	// nil if the Starlark object couldn't be created, in which case exc is
+	// what was raised
+	self  *C.Starlark
+	exc   *C.PyObject
+	print *C.PyObject
+
+	filename string
+	source   string
+	options  fileOptions
+	globals  starlark.StringDict
+
+	thread    *starlark.Thread
+	interrupt *interruptWatch
+	timedOut  atomic.Bool
+
+	newGlobals starlark.StringDict
+	uses       []string
+	err        error
+	// Set if executing the job panicked
+	panicked interface{}
+	stack    []byte
+}
+
+//export StarlarkGo_runBatch
+func StarlarkGo_runBatch(module *C.StarlarkModuleState, pyjobs *C.PyObject, pyworkers *C.PyObject, print *C.PyObject, options *C.PyObject, timeout C.double) (retval *C.PyObject) {
+	var pt *pythonThread
+	defer recoverPanic(module.StarlarkType, &pt, func() { retval = nil })
+
+	workers := runtime.GOMAXPROCS(0)
+	if pyworkers != nil && pyworkers != C.Py_None {
+		n := C.PyLong_AsLongLong(pyworkers)
+		if n == -1 && C.PyErr_Occurred() != nil {
+			return nil
+		}
+		if n < 1 {
+			raiseValueError("workers must be at least 1")
+			return nil
+		}
+		workers = int(n)
+	}
+
+	jobs, ok := newBatchJobs(module, pyjobs, print, options)
+	defer func() {
+		for _, job := range jobs {
+			job.release()
+		}
+	}()
+	if !ok {
+		return nil
+	}
+
+	// Jobs are only cancelled by SIGINT while they're watched, so they all
+	// are from the start
+	for _, job := range jobs {
+		if job.self != nil {
+			job.thread = stateOf(job.self).newThread()
+			job.thread.Name = job.filename
+			job.interrupt = watchInterrupts(job.thread)
+		}
+	}
+
+	// Workers don't run Starlark any faster than Go runs goroutines, and
+	// each of them holds an OS thread and a Python thread state
+	if limit := runtime.GOMAXPROCS(0); workers > limit {
+		workers = limit
+	}
+	if workers > len(jobs) {
+		workers = len(jobs)
+	}
+
+	queue := make(chan *batchJob)
+	var wg sync.WaitGroup
+
+	interp := C.PyInterpreterState_Get()
+	pt = &pythonThread{}
+	pt.DetachGIL()
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			runBatchWorker(interp, queue, timeout)
+		}()
+	}
+
+	for _, job := range jobs {
+		if job.self != nil {
+			queue <- job
+		}
+	}
+	close(queue)
+	wg.Wait()
+
+	pt.ReattachGIL()
+
+	var interrupted *batchJob
+	for _, job := range jobs {
+		job.interrupt.Stop(job.err)
+		if interrupted == nil && job.interrupt.Interrupted() && job.err != nil {
+			interrupted = job
+		}
+	}
+
+	if interrupted != nil {
+		stateOf(interrupted.self).raiseInterruptedPythonException(interrupted.err)
+		return nil
+	}
+
+	results := C.PyList_New(C.Py_ssize_t(len(jobs)))
+	if results == nil {
+		return nil
+	}
+
+	for i, job := range jobs {
+		// This steals the reference
+		C.PyList_SetItem(results, C.Py_ssize_t(i), job.result(module))
+	}
+
+	return results
+}
+
+// newBatchJobs creates a Starlark object for each job passed to run_batch. If
+// that fails, for example because the globals of the job can't be converted,
+// the job keeps the exception. If the jobs themselves are malformed, a Python
+// exception is set and ok is false.
+func newBatchJobs(module *C.StarlarkModuleState, pyjobs *C.PyObject, print *C.PyObject, options *C.PyObject) (jobs []*batchJob, ok bool) {
+	iter := C.PyObject_GetIter(pyjobs)
+	if iter == nil {
+		return nil, false
+	}
+	defer C.Py_DecRef(iter)
+
+	for pyjob := C.PyIter_Next(iter); pyjob != nil; pyjob = C.PyIter_Next(iter) {
+		job, ok := newBatchJob(module, pyjob, print, options)
+		C.Py_DecRef(pyjob)
+		if !ok {
+			return jobs, false
+		}
+
+		jobs = append(jobs, job)
+	}
+
+	return jobs, C.PyErr_Occurred() == nil
+}
+
+func newBatchJob(module *C.StarlarkModuleState, pyjob *C.PyObject, print *C.PyObject, options *C.PyObject) (*batchJob, bool) {
+	if C.PySequence_Check(pyjob) != 1 || C.PySequence_Size(pyjob) != 3 {
+		if C.PyErr_Occurred() == nil {
+			raiseTypeError(fmt.Sprintf("jobs must be (source, filename, globals) tuples, not %s", C.GoString(pyjob.ob_type.tp_name)))
+		}
+		return nil, false
+	}
+
+	var items [3]*C.PyObject
+	for i := range items {
+		items[i] = C.PySequence_GetItem(pyjob, C.Py_ssize_t(i))
+		if items[i] == nil {
+			return nil, false
+		}
+		defer C.Py_DecRef(items[i])
+	}
+	pysource, pyfilename, pyglobals := items[0], items[1], items[2]
+
+	job := &batchJob{filename: "<batch>"}
+
+	source, ok := globalName(pysource)
+	if !ok {
+		if C.PyErr_Occurred() == nil {
+			raiseTypeError(fmt.Sprintf("job source must be str, not %s", C.GoString(pysource.ob_type.tp_name)))
+		}
+		return nil, false
+	}
+	job.source = source
+
+	if pyfilename != C.Py_None {
+		filename, ok := globalName(pyfilename)
+		if !ok {
+			if C.PyErr_Occurred() == nil {
+				raiseTypeError(fmt.Sprintf("job filename must be str or None, not %s", C.GoString(pyfilename.ob_type.tp_name)))
+			}
+			return nil, false
+		}
+		job.filename = filename
+	}
+
+	if pyglobals == C.Py_None {
+		pyglobals = nil
+	}
+
+	self := newBatchStarlark(module, map[string]*C.PyObject{
+		"globals": pyglobals,
+		"print":   print,
+		"options": options,
+	})
+	if self == nil {
+		job.exc = fetchPythonException()
+		return job, true
+	}
+
+	job.print = pythonPrint(self, nil)
+	if job.print == nil {
+		job.exc = fetchPythonException()
+		C.Py_DecRef((*C.PyObject)(unsafe.Pointer(self)))
+		return job, true
+	}
+
+	state := stateOf(self)
+	job.self = self
+	job.options = state.options(fileOptions{}).resolve(module)
+	// Nothing else can use the object until run_batch returns it, so the
+	// Mutex isn't needed
+	job.globals = state.Globals
+
+	return job, true
+}
+
+// newBatchStarlark calls Starlark with the keyword arguments that aren't nil.
+func newBatchStarlark(module *C.StarlarkModuleState, kwargs map[string]*C.PyObject) *C.Starlark {
+	for name, value := range kwargs {
+		if value == nil {
+			delete(kwargs, name)
+		}
+	}
+
+	pykwargs := buildPythonDict(kwargs)
+	if pykwargs == nil {
+		return nil
+	}
+	defer C.Py_DecRef(pykwargs)
+
+	args := C.PyTuple_New(0)
+	if args == nil {
+		return nil
+	}
+	defer C.Py_DecRef(args)
+
+	return (*C.Starlark)(unsafe.Pointer(C.PyObject_Call((*C.PyObject)(unsafe.Pointer(module.StarlarkType)), args, pykwargs)))
+}
+
+// runBatchWorker executes jobs until the queue is closed. It runs on its own
+// goroutine, so it has its own Python thread state, which is how Python
+// functions called by the jobs get the GIL. The thread state is created in
+// interp, the interpreter that called run_batch, since PyGILState_Ensure
+// would always attach the worker to the main interpreter.
+func runBatchWorker(interp *C.PyInterpreterState, queue chan *batchJob, timeout C.double) {
+	// Python thread states belong to OS threads
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	threadState := C.PyThreadState_New(interp)
+	if threadState == nil {
+		for job := range queue {
+			job.panicked = "can't create a Python thread state"
+		}
+		return
+	}
+
+	// The worker starts without the GIL, which it takes with its thread state
+	pt := &pythonThread{threadState: threadState}
+	defer func() {
+		pt.ReattachGIL()
+		C.PyThreadState_Clear(threadState)
+		C.PyThreadState_DeleteCurrent()
+	}()
+
+	for job := range queue {
+		job.run(pt, timeout)
+	}
+}
+
+// run executes the job without the GIL. pt is the Python thread of the worker.
+func (job *batchJob) run(pt *pythonThread, timeout C.double) {
+	defer func() {
+		if r := recover(); r != nil {
+			job.panicked = r
+			job.stack = debug.Stack()
+		}
+	}()
+
+	job.thread.SetLocal(pythonThreadKey, pt)
+	job.thread.Print = func(_ *starlark.Thread, msg string) {
+		pt.ReattachGIL()
+		defer pt.DetachGIL()
+
+		callPythonPrint(job.print, msg)
+	}
+
+	if timeout > 0 {
+		timer := time.AfterFunc(time.Duration(float64(timeout)*float64(time.Second)), func() {
+			job.timedOut.Store(true)
+			job.thread.Cancel("timed out")
+		})
+		defer timer.Stop()
+	}
+
+	file, program, err := starlark.SourceProgramOptions(job.options.syntaxOptions(stateOf(job.self).module), job.filename, job.source, job.globals.Has)
+	if err != nil {
+		job.err = err
+		return
+	}
+
+	job.newGlobals, job.err = program.Init(job.thread, job.globals)
+	if job.err == nil {
+		job.newGlobals.Freeze()
+		job.uses = predeclaredNames(file)
+	}
+}
+
+// result returns a new reference to what became of the job: its Starlark
+// object, with the globals it defined, or the exception it raised.
+func (job *batchJob) result(module *C.StarlarkModuleState) *C.PyObject {
+	if job.exc != nil {
+		exc := job.exc
+		job.exc = nil
+		return exc
+	}
+
+	state := stateOf(job.self)
+
+	switch {
+	case job.panicked != nil:
+		raiseInternalError(module.StarlarkType, job.panicked, job.stack)
+		return fetchPythonException()
+	case job.err != nil && job.timedOut.Load():
+		state.raiseTimeoutPythonException(job.err)
+		return fetchPythonException()
+	case job.err != nil:
+		state.raisePythonException(job.err)
+		return fetchPythonException()
+	}
+
+	source := &execSource{
+		filename: job.filename,
+		source:   job.source,
+		options:  job.options,
+		names:    job.newGlobals.Keys(),
+		uses:     job.uses,
+	}
+	ok := state.updateGlobals(source.names, func(globals starlark.StringDict) {
+		for k, v := range job.newGlobals {
+			globals[k] = v
+		}
+		state.Sources = addExecSource(state.Sources, source, globals)
+	})
+	if !ok {
+		return fetchPythonException()
+	}
+
+	return C.cgoPy_NewRef((*C.PyObject)(unsafe.Pointer(job.self)))
+}
+
+// release drops the references held by the job, once run_batch is done.
+func (job *batchJob) release() {
+	for _, obj := range []*C.PyObject{(*C.PyObject)(unsafe.Pointer(job.self)), job.exc, job.print} {
+		if obj != nil {
+			C.Py_DecRef(obj)
+		}
+	}
+	job.self, job.exc, job.print = nil, nil, nil
+}
@@ -6,6 +6,7 @@ package main
 import "C"
 
 import (
+	"fmt"
 	"sync/atomic"
 	"time"
 	"unsafe"
@@ -14,17 +15,32 @@ import (
 )
 
 //export Starlark_eval
-func Starlark_eval(self *C.Starlark, args *C.PyObject, kwargs *C.PyObject) *C.PyObject {
+func Starlark_eval(self *C.Starlark, args *C.PyObject, kwargs *C.PyObject) (retval *C.PyObject) {
+	var pt *pythonThread
+	defer recoverPanic(starlarkTypeOf(self), &pt, func() { retval = nil })
+
+	stateOf(self).releasePythonRefs()
+
 	var (
 		expr       *C.char
-		filename   *C.char     = nil
-		convert    C.uint      = 1
-		print      *C.PyObject = nil
-		timeout    C.double    = 0
-		goFilename string      = "<expr>"
+		filename   *C.char      = nil
+		convert    C.uint       = 1
+		print      *C.PyObject  = nil
+		timeout    C.double     = 0
+		maxMemory  C.Py_ssize_t = 0
+		resultType *C.PyObject  = nil
+		options    *C.PyObject  = nil
+		pylocals   *C.PyObject  = nil
+		goFilename string       = "<expr>"
 	)
 
-	if C.parseEvalArgs(args, kwargs, &expr, &filename, &convert, &print, &timeout) == 0 {
+	if C.parseEvalArgs(args, kwargs, &expr, &filename, &convert, &print, &timeout, &maxMemory, &resultType, &options, &pylocals) == 0 {
+		return nil
+	}
+
+	state := stateOf(self)
+	callOptions, ok := state.pythonToFileOptions(options)
+	if !ok {
 		return nil
 	}
 
@@ -32,27 +48,56 @@ func Starlark_eval(self *C.Starlark, args *C.PyObject, kwargs *C.PyObject) *C.Py
 	if print == nil {
 		return nil
 	}
+	defer C.Py_DecRef(print)
 
 	goExpr := C.GoString(expr)
 	if filename != nil {
 		goFilename = C.GoString(filename)
 	}
 
-	state := rlockSelf(self)
-	if state == nil {
-		return nil
+	opts := state.options(callOptions).syntaxOptions(state.module)
+
+	var locals starlark.StringDict
+	if pylocals != nil && pylocals != C.Py_None {
+		if C.PyMapping_Check(pylocals) != 1 {
+			raiseTypeError(fmt.Sprintf("locals must be a mapping, not %s", C.GoString(pylocals.ob_type.tp_name)))
+			return nil
+		}
+
+		locals, ok = state.pythonToStringDict(pylocals)
+		if !ok {
+			return nil
+		}
 	}
-	defer state.Mutex.RUnlock()
 
-	state.DetachGIL()
-	starlarkPrint := func(_ *starlark.Thread, msg string) {
-		state.ReattachGIL()
-		defer state.DetachGIL()
+	// The globals can't change until the expression has been evaluated
+	defer state.Mutex.rlock()()
+	env := state.Globals
 
-		callPythonPrint(print, msg)
+	// The locals are layered over the globals in a copy, so that the globals
+	// don't change
+	if len(locals) > 0 {
+		env = make(starlark.StringDict, len(state.Globals)+len(locals))
+		for k, v := range state.Globals {
+			env[k] = v
+		}
+		for k, v := range locals {
+			env[k] = v
+		}
 	}
 
-	thread := &starlark.Thread{Print: starlarkPrint}
+	thread := state.newThread()
+	pt = newPythonThread(thread)
+	thread.Print = func(_ *starlark.Thread, msg string) {
+		pt.ReattachGIL()
+		defer pt.DetachGIL()
+
+		callPythonPrint(print, msg)
+	}
+	interrupt := watchInterrupts(thread)
+	// Hand SIGINT back to Python even if Starlark panics
+	defer interrupt.Stop(nil)
+	pt.DetachGIL()
 
 	var timedOut atomic.Bool
 	if timeout > 0 {
@@ -63,24 +108,46 @@ func Starlark_eval(self *C.Starlark, args *C.PyObject, kwargs *C.PyObject) *C.Py
 		defer timer.Stop()
 	}
 
-	result, err := starlark.Eval(thread, goFilename, goExpr, state.Globals)
-	state.ReattachGIL()
+	memory := watchMemory(thread, int(maxMemory))
+	defer memory.Stop()
+
+	result, err := starlark.EvalOptions(opts, thread, goFilename, goExpr, env)
+	memory.Stop()
+	pt.ReattachGIL()
+	interrupt.Stop(err)
 
 	if err != nil {
-		if timedOut.Load() {
-			raiseTimeoutPythonException(err)
+		if interrupt.Interrupted() {
+			state.raiseInterruptedPythonException(err)
+		} else if memory.Exceeded() {
+			state.raiseMemoryLimitPythonException(err)
+		} else if timedOut.Load() {
+			state.raiseTimeoutPythonException(err)
 		} else {
-			raisePythonException(err)
+			state.raisePythonException(err)
 		}
 		return nil
 	}
 
-	if convert == 0 {
+	return state.evalResultToPython(result, convert != 0, resultType)
+}
+
+// evalResultToPython converts the result of an expression, either to Python
+// or to its Starlark representation if convert is false.
+func (state *StarlarkState) evalResultToPython(result starlark.Value, convert bool, resultType *C.PyObject) *C.PyObject {
+	if !convert {
 		cstr := C.CString(result.String())
 		defer C.free(unsafe.Pointer(cstr))
 		return C.cgoPy_BuildString(cstr)
+	} else if resultType != nil && resultType != C.Py_None {
+		retval, err := state.starlarkValueToPythonType(result, resultType)
+		if err != nil {
+			return nil
+		}
+
+		return retval
 	} else {
-		retval, err := starlarkValueToPython(result)
+		retval, err := state.starlarkValueToPython(result)
 		if err != nil {
 			return nil
 		}
@@ -90,16 +157,31 @@ func Starlark_eval(self *C.Starlark, args *C.PyObject, kwargs *C.PyObject) *C.Py
 }
 
 //export Starlark_exec
-func Starlark_exec(self *C.Starlark, args *C.PyObject, kwargs *C.PyObject) *C.PyObject {
+func Starlark_exec(self *C.Starlark, args *C.PyObject, kwargs *C.PyObject) (retval *C.PyObject) {
+	var pt *pythonThread
+	defer recoverPanic(starlarkTypeOf(self), &pt, func() { retval = nil })
+
+	stateOf(self).releasePythonRefs()
+
 	var (
-		defs       *C.char
-		filename   *C.char     = nil
-		print      *C.PyObject = nil
-		timeout    C.double    = 0
-		goFilename string      = "<expr>"
+		defs          *C.char
+		filename      *C.char      = nil
+		print         *C.PyObject  = nil
+		timeout       C.double     = 0
+		maxMemory     C.Py_ssize_t = 0
+		options       *C.PyObject  = nil
+		returnResult  C.int        = 0
+		convertValues C.int        = 0
+		goFilename    string       = "<expr>"
 	)
 
-	if C.parseExecArgs(args, kwargs, &defs, &filename, &print, &timeout) == 0 {
+	if C.parseExecArgs(args, kwargs, &defs, &filename, &print, &timeout, &maxMemory, &options, &returnResult, &convertValues) == 0 {
+		return nil
+	}
+
+	state := stateOf(self)
+	callOptions, ok := state.pythonToFileOptions(options)
+	if !ok {
 		return nil
 	}
 
@@ -107,6 +189,7 @@ func Starlark_exec(self *C.Starlark, args *C.PyObject, kwargs *C.PyObject) *C.Py
 	if print == nil {
 		return nil
 	}
+	defer C.Py_DecRef(print)
 
 	goDefs := C.GoString(defs)
 
@@ -114,28 +197,82 @@ func Starlark_exec(self *C.Starlark, args *C.PyObject, kwargs *C.PyObject) *C.Py
 		goFilename = C.GoString(filename)
 	}
 
-	state := lockSelf(self)
-	if state == nil {
+	resolvedOptions := state.options(callOptions).resolve(state.module)
+	newGlobals, uses, elapsed, ok := state.runProgram(&pt, print, goFilename, goDefs, resolvedOptions, timeout, maxMemory)
+	if !ok {
 		return nil
 	}
-	defer state.Mutex.Unlock()
 
-	state.DetachGIL()
-	starlarkPrint := func(_ *starlark.Thread, msg string) {
-		state.ReattachGIL()
-		defer state.DetachGIL()
+	source := &execSource{
+		filename: goFilename,
+		source:   goDefs,
+		options:  resolvedOptions,
+		names:    newGlobals.Keys(),
+		uses:     uses,
+	}
 
-		callPythonPrint(print, msg)
+	// Converted before the globals change, so that nothing has if it fails
+	var pyvalues *C.PyObject
+	if returnResult != 0 && convertValues != 0 {
+		pyvalues = state.execValuesToPython(source.names, newGlobals)
+		if pyvalues == nil {
+			return nil
+		}
+		defer C.Py_DecRef(pyvalues)
 	}
 
-	_, program, err := starlark.SourceProgram(goFilename, goDefs, state.Globals.Has)
-	if err != nil {
-		state.ReattachGIL()
-		raisePythonException(err)
+	var replaced []string
+	ok = state.updateGlobals(source.names, func(globals starlark.StringDict) {
+		for k, v := range newGlobals {
+			if _, ok := globals[k]; ok {
+				replaced = append(replaced, k)
+			}
+			globals[k] = v
+		}
+		// The Mutex is held while updating, so the sources can be replaced too
+		state.Sources = addExecSource(state.Sources, source, globals)
+	})
+	if !ok {
 		return nil
 	}
 
-	thread := &starlark.Thread{Print: starlarkPrint}
+	if returnResult == 0 {
+		return C.cgoPy_NewRef(C.Py_None)
+	}
+
+	return state.execResultToPython(source.names, replaced, elapsed, pyvalues)
+}
+
+// runProgram compiles and runs a Starlark program with the globals as its
+// predeclared names, and returns the frozen globals it defined, the globals
+// it uses, and how long that took. It sets pt, so that recoverPanic can
+// reattach the GIL. If it fails, a Python exception is set and ok is false.
+func (state *StarlarkState) runProgram(pt **pythonThread, print *C.PyObject, filename string, source string, options fileOptions, timeout C.double, maxMemory C.Py_ssize_t) (newGlobals starlark.StringDict, uses []string, elapsed time.Duration, ok bool) {
+	// The globals can't change until the program has run
+	defer state.Mutex.rlock()()
+	globals := state.Globals
+
+	thread := state.newThread()
+	*pt = newPythonThread(thread)
+	thread.Print = func(_ *starlark.Thread, msg string) {
+		(*pt).ReattachGIL()
+		defer (*pt).DetachGIL()
+
+		callPythonPrint(print, msg)
+	}
+	interrupt := watchInterrupts(thread)
+	// Hand SIGINT back to Python even if Starlark panics
+	defer interrupt.Stop(nil)
+	(*pt).DetachGIL()
+
+	start := time.Now()
+	file, program, err := starlark.SourceProgramOptions(options.syntaxOptions(state.module), filename, source, globals.Has)
+	if err != nil {
+		(*pt).ReattachGIL()
+		interrupt.Stop(err)
+		state.raisePythonException(err)
+		return nil, nil, 0, false
+	}
 
 	var timedOut atomic.Bool
 	if timeout > 0 {
@@ -146,22 +283,28 @@ func Starlark_exec(self *C.Starlark, args *C.PyObject, kwargs *C.PyObject) *C.Py
 		defer timer.Stop()
 	}
 
-	newGlobals, err := program.Init(thread, state.Globals)
-	state.ReattachGIL()
+	memory := watchMemory(thread, int(maxMemory))
+	defer memory.Stop()
+
+	newGlobals, err = program.Init(thread, globals)
+	elapsed = time.Since(start)
+	memory.Stop()
+	(*pt).ReattachGIL()
+	interrupt.Stop(err)
 
 	if err != nil {
-		if timedOut.Load() {
-			raiseTimeoutPythonException(err)
+		if interrupt.Interrupted() {
+			state.raiseInterruptedPythonException(err)
+		} else if memory.Exceeded() {
+			state.raiseMemoryLimitPythonException(err)
+		} else if timedOut.Load() {
+			state.raiseTimeoutPythonException(err)
 		} else {
-			raisePythonException(err)
+			state.raisePythonException(err)
 		}
-		return nil
-	}
-
-	for k, v := range newGlobals {
-		v.Freeze()
-		state.Globals[k] = v
+		return nil, nil, 0, false
 	}
 
-	return C.cgoPy_NewRef(C.Py_None)
+	newGlobals.Freeze()
+	return newGlobals, predeclaredNames(file), elapsed, true
 }
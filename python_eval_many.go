@@ -0,0 +1,315 @@
+package main
+
+/*
+#include "starlark.h"
+*/
+import "C"
+
+import (
+	"fmt"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"go.starlark.net/resolve"
+	"go.starlark.net/starlark"
+	"go.starlark.net/syntax"
+)
+
+// The global that eval_many assigns the result of the expression to
+const evalManyResult = "__eval_many_result__"
+
+// evalManyRecord is one record passed to eval_many, and what became of it.
+type evalManyRecord struct {
+	bindings starlark.StringDict
+	result   starlark.Value
+	err      error
+	// The exception raised while converting the record to Starlark, if
+	// exceptions are being returned
+	exc *C.PyObject
+}
+
+//export Starlark_eval_many
+func Starlark_eval_many(self *C.Starlark, args *C.PyObject, kwargs *C.PyObject) (retval *C.PyObject) {
+	var pt *pythonThread
+	defer recoverPanic(starlarkTypeOf(self), &pt, func() { retval = nil })
+
+	stateOf(self).releasePythonRefs()
+
+	var (
+		expr             *C.char
+		pyrecords        *C.PyObject  = nil
+		filename         *C.char      = nil
+		convert          C.uint       = 1
+		print            *C.PyObject  = nil
+		timeout          C.double     = 0
+		maxMemory        C.Py_ssize_t = 0
+		resultType       *C.PyObject  = nil
+		options          *C.PyObject  = nil
+		returnExceptions C.int        = 0
+		goFilename       string       = "<expr>"
+	)
+
+	if C.parseEvalManyArgs(args, kwargs, &expr, &pyrecords, &filename, &convert, &print, &timeout, &maxMemory, &resultType, &options, &returnExceptions) == 0 {
+		return nil
+	}
+
+	state := stateOf(self)
+	callOptions, ok := state.pythonToFileOptions(options)
+	if !ok {
+		return nil
+	}
+
+	print = pythonPrint(self, print)
+	if print == nil {
+		return nil
+	}
+	defer C.Py_DecRef(print)
+
+	if filename != nil {
+		goFilename = C.GoString(filename)
+	}
+
+	records, ok := state.evalManyRecords(pyrecords, returnExceptions != 0)
+	defer func() {
+		for _, record := range records {
+			if record.exc != nil {
+				C.Py_DecRef(record.exc)
+			}
+		}
+	}()
+	if !ok {
+		return nil
+	}
+
+	// Without records, there's no telling which names the expression may use
+	if len(records) == 0 {
+		return C.PyList_New(0)
+	}
+
+	// The globals can't change until every record has been evaluated
+	defer state.Mutex.rlock()()
+	globals := state.Globals
+
+	names := map[string]bool{}
+	for _, record := range records {
+		for name := range record.bindings {
+			names[name] = true
+		}
+	}
+
+	program, predeclared, err := compileEvalMany(state.options(callOptions).syntaxOptions(state.module), goFilename, C.GoString(expr), func(name string) bool {
+		return names[name] || globals.Has(name)
+	})
+	if err != nil {
+		state.raisePythonException(err)
+		return nil
+	}
+
+	thread := state.newThread()
+	pt = newPythonThread(thread)
+	thread.Print = func(_ *starlark.Thread, msg string) {
+		pt.ReattachGIL()
+		defer pt.DetachGIL()
+
+		callPythonPrint(print, msg)
+	}
+	interrupt := watchInterrupts(thread)
+	// Hand SIGINT back to Python even if Starlark panics
+	defer interrupt.Stop(nil)
+	pt.DetachGIL()
+
+	var timedOut atomic.Bool
+	if timeout > 0 {
+		timer := time.AfterFunc(time.Duration(float64(timeout)*float64(time.Second)), func() {
+			timedOut.Store(true)
+			thread.Cancel("timed out")
+		})
+		defer timer.Stop()
+	}
+
+	memory := watchMemory(thread, int(maxMemory))
+	defer memory.Stop()
+
+	// An error that stops every record, rather than just one
+	var cancelErr error
+	for _, record := range records {
+		if record.exc != nil {
+			continue
+		}
+
+		record.result, record.err = evalManyRecordResult(thread, program, predeclared, record.bindings, globals)
+		if record.err == nil {
+			continue
+		}
+
+		if interrupt.Interrupted() || memory.Exceeded() || timedOut.Load() {
+			cancelErr = record.err
+			break
+		}
+
+		if returnExceptions == 0 {
+			break
+		}
+	}
+
+	memory.Stop()
+	pt.ReattachGIL()
+	interrupt.Stop(cancelErr)
+
+	if cancelErr != nil {
+		if interrupt.Interrupted() {
+			state.raiseInterruptedPythonException(cancelErr)
+		} else if memory.Exceeded() {
+			state.raiseMemoryLimitPythonException(cancelErr)
+		} else {
+			state.raiseTimeoutPythonException(cancelErr)
+		}
+		return nil
+	}
+
+	results := C.PyList_New(C.Py_ssize_t(len(records)))
+	if results == nil {
+		return nil
+	}
+
+	for i, record := range records {
+		var item *C.PyObject
+
+		switch {
+		case record.exc != nil:
+			item, record.exc = record.exc, nil
+		case record.err != nil:
+			state.raisePythonException(record.err)
+		default:
+			item = state.evalResultToPython(record.result, convert != 0, resultType)
+		}
+
+		if item == nil {
+			if returnExceptions == 0 {
+				C.Py_DecRef(results)
+				return nil
+			}
+			item = fetchPythonException()
+		}
+
+		// This steals the reference
+		C.PyList_SetItem(results, C.Py_ssize_t(i), item)
+	}
+
+	return results
+}
+
+// evalManyRecords converts the records passed to eval_many. If exceptions are
+// being returned, records that can't be converted keep their exception.
+// Otherwise, or if records isn't an iterable of mappings, a Python exception
+// is set and ok is false.
+func (state *StarlarkState) evalManyRecords(pyrecords *C.PyObject, returnExceptions bool) (records []*evalManyRecord, ok bool) {
+	iter := C.PyObject_GetIter(pyrecords)
+	if iter == nil {
+		return nil, false
+	}
+	defer C.Py_DecRef(iter)
+
+	for pyrecord := C.PyIter_Next(iter); pyrecord != nil; pyrecord = C.PyIter_Next(iter) {
+		if C.PyMapping_Check(pyrecord) != 1 {
+			raiseTypeError(fmt.Sprintf("records must be mappings, not %s", C.GoString(pyrecord.ob_type.tp_name)))
+			C.Py_DecRef(pyrecord)
+			return records, false
+		}
+
+		record := &evalManyRecord{}
+		record.bindings, ok = state.pythonToStringDict(pyrecord)
+		C.Py_DecRef(pyrecord)
+		if !ok {
+			if !returnExceptions {
+				return records, false
+			}
+			record.exc = fetchPythonException()
+		}
+
+		records = append(records, record)
+	}
+
+	return records, C.PyErr_Occurred() == nil
+}
+
+// compileEvalMany compiles expr as a program that assigns its value to
+// evalManyResult. It also returns where each predeclared name is first used,
+// which are the only names a record needs to provide.
+func compileEvalMany(opts *syntax.FileOptions, filename string, src string, isPredeclared func(string) bool) (*starlark.Program, map[string]syntax.Position, error) {
+	expr, err := opts.ParseExpr(filename, src, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	start, _ := expr.Span()
+	file := &syntax.File{
+		Path: filename,
+		Stmts: []syntax.Stmt{&syntax.AssignStmt{
+			OpPos: start,
+			Op:    syntax.EQ,
+			LHS:   &syntax.Ident{NamePos: start, Name: evalManyResult},
+			RHS:   expr,
+		}},
+		Options: opts,
+	}
+
+	program, err := starlark.FileProgram(file, isPredeclared)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	predeclared := map[string]syntax.Position{}
+	syntax.Walk(expr, func(node syntax.Node) bool {
+		if ident, ok := node.(*syntax.Ident); ok {
+			binding, ok := ident.Binding.(*resolve.Binding)
+			if _, seen := predeclared[ident.Name]; ok && !seen && binding.Scope == resolve.Predeclared {
+				predeclared[ident.Name] = ident.NamePos
+			}
+		}
+		return true
+	})
+
+	return program, predeclared, nil
+}
+
+// evalManyRecordResult evaluates the program of eval_many against one record,
+// which hides the globals. Names the program uses that are in neither are
+// reported like any other undefined name.
+func evalManyRecordResult(thread *starlark.Thread, program *starlark.Program, predeclared map[string]syntax.Position, bindings starlark.StringDict, globals starlark.StringDict) (starlark.Value, error) {
+	env := make(starlark.StringDict, len(predeclared))
+	var undefined resolve.ErrorList
+
+	for name, pos := range predeclared {
+		if value, ok := bindings[name]; ok {
+			env[name] = value
+		} else if value, ok := globals[name]; ok {
+			env[name] = value
+		} else {
+			undefined = append(undefined, resolve.Error{Pos: pos, Msg: "undefined: " + name})
+		}
+	}
+
+	if len(undefined) > 0 {
+		sort.Slice(undefined, func(i, j int) bool {
+			pi, pj := undefined[i].Pos, undefined[j].Pos
+			return pi.Line < pj.Line || (pi.Line == pj.Line && pi.Col < pj.Col)
+		})
+		return nil, undefined
+	}
+
+	result, err := program.Init(thread, env)
+	if err != nil {
+		return nil, err
+	}
+
+	return result[evalManyResult], nil
+}
+
+// fetchPythonException clears the current Python exception and returns it.
+func fetchPythonException() *C.PyObject {
+	ptype, pvalue, _ := getCurrentPythonException()
+	C.Py_DecRef(ptype)
+	return pvalue
+}
@@ -2,12 +2,6 @@ package main
 
 /*
 #include "starlark.h"
-
-extern PyObject *StarlarkError;
-extern PyObject *SyntaxError;
-extern PyObject *EvalError;
-extern PyObject *EvalTimeoutError;
-extern PyObject *ResolveError;
 */
 import "C"
 
@@ -54,15 +48,21 @@ func handleConversionError(err error, pytype *C.PyObject) {
 	C.PyErr_SetString(pytype, errmsg)
 }
 
-func raisePythonException(err error) {
-	doRaisePythonException(err, false)
+func (state *StarlarkState) raisePythonException(err error) {
+	state.doRaisePythonException(err, state.module.EvalError)
+}
+
+func (state *StarlarkState) raiseTimeoutPythonException(err error) {
+	state.doRaisePythonException(err, state.module.EvalTimeoutError)
 }
 
-func raiseTimeoutPythonException(err error) {
-	doRaisePythonException(err, true)
+func (state *StarlarkState) raiseMemoryLimitPythonException(err error) {
+	state.doRaisePythonException(err, state.module.EvalMemoryLimitError)
 }
 
-func doRaisePythonException(err error, isTimeout bool) {
+// doRaisePythonException raises err as the matching Python exception, using
+// evalErrorType for evaluation errors.
+func (state *StarlarkState) doRaisePythonException(err error, evalErrorType *C.PyObject) {
 	var (
 		exc_args   *C.PyObject
 		exc_type   *C.PyObject
@@ -89,7 +89,7 @@ func doRaisePythonException(err error, isTimeout bool) {
 		column := C.uint(syntaxErr.Pos.Col)
 
 		exc_args = C.makeSyntaxErrorArgs(error_msg, error_type, msg, filename, line, column)
-		exc_type = C.SyntaxError
+		exc_type = state.module.SyntaxError
 	case errors.As(err, &evalErr):
 		backtrace := C.CString(evalErr.Backtrace())
 		defer C.free(unsafe.Pointer(backtrace))
@@ -104,6 +104,12 @@ func doRaisePythonException(err error, isTimeout bool) {
 		if len(evalErr.CallStack) > 0 {
 			frame := evalErr.CallStack[len(evalErr.CallStack)-1]
 
+			// Report bad arguments to a Python function where it was called
+			var argErr *argumentError
+			if errors.As(err, &argErr) && len(evalErr.CallStack) > 1 {
+				frame = evalErr.CallStack[len(evalErr.CallStack)-2]
+			}
+
 			filename = C.CString(frame.Pos.Filename())
 			defer C.free(unsafe.Pointer((filename)))
 
@@ -122,11 +128,7 @@ func doRaisePythonException(err error, isTimeout bool) {
 		}
 
 		exc_args = C.makeEvalErrorArgs(error_msg, error_type, filename, line, column, function_name, backtrace)
-		if isTimeout {
-			exc_type = C.EvalTimeoutError
-		} else {
-			exc_type = C.EvalError
-		}
+		exc_type = evalErrorType
 	case errors.As(err, &resolveErr):
 		items := C.PyTuple_New(C.Py_ssize_t(len(resolveErr)))
 		defer C.Py_DecRef(items)
@@ -135,14 +137,14 @@ func doRaisePythonException(err error, isTimeout bool) {
 			msg := C.CString(err.Msg)
 			defer C.free(unsafe.Pointer(msg))
 
-			C.PyTuple_SetItem(items, C.Py_ssize_t(i), C.makeResolveErrorItem(msg, C.uint(err.Pos.Line), C.uint(err.Pos.Col)))
+			C.PyTuple_SetItem(items, C.Py_ssize_t(i), C.makeResolveErrorItem(state.module, msg, C.uint(err.Pos.Line), C.uint(err.Pos.Col)))
 		}
 
 		exc_args = C.makeResolveErrorArgs(error_msg, error_type, items)
-		exc_type = C.ResolveError
+		exc_type = state.module.ResolveError
 	default:
 		exc_args = C.makeStarlarkErrorArgs(error_msg, error_type)
-		exc_type = C.StarlarkError
+		exc_type = state.module.StarlarkError
 	}
 
 	C.PyErr_SetObject(exc_type, exc_args)
@@ -0,0 +1,66 @@
+package main
+
+/*
+#include "starlark.h"
+*/
+import "C"
+
+import (
+	"runtime/cgo"
+	"unsafe"
+
+	"go.starlark.net/starlark"
+)
+
+//export Starlark_fork
+func Starlark_fork(self *C.Starlark, _ *C.PyObject) (retval *C.PyObject) {
+	defer recoverPanic(starlarkTypeOf(self), nil, func() { retval = nil })
+
+	state := stateOf(self)
+	// Anything that's already dead doesn't need to be shared
+	state.releasePythonRefs()
+
+	runlock := state.Mutex.rlock()
+	globals, sources := state.someGlobals(nil), state.Sources
+	runlock()
+
+	return state.newChild(self, globals, sources)
+}
+
+// newChild returns a new Starlark object of the same type as self with the
+// given globals and the settings of self. The child keeps alive the Python
+// objects that self has passed to Starlark, which its globals may refer to.
+// It also shares the Mutex of self, since functions in its globals may look
+// up names in the globals of self, which self changes in place.
+func (state *StarlarkState) newChild(self *C.Starlark, globals starlark.StringDict, sources []*execSource) *C.PyObject {
+	child := C.starlarkAlloc(starlarkTypeOf(self))
+	if child == nil {
+		return nil
+	}
+
+	runlock := state.Mutex.rlock()
+	childState := &StarlarkState{
+		Globals:       globals,
+		Sources:       sources,
+		module:        state.module,
+		Mutex:         state.Mutex,
+		Print:         state.Print,
+		StructFactory: state.StructFactory,
+		CoerceArgs:    state.CoerceArgs,
+		Options:       state.Options,
+		childRefs:     state.childRefs.clone(),
+	}
+
+	if childState.Print != nil {
+		C.Py_IncRef(childState.Print)
+	}
+
+	if childState.StructFactory != nil {
+		C.Py_IncRef(childState.StructFactory)
+	}
+	runlock()
+
+	child.handle = C.uintptr_t(cgo.NewHandle(childState))
+
+	return (*C.PyObject)(unsafe.Pointer(child))
+}
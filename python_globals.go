@@ -6,19 +6,23 @@ package main
 import "C"
 
 import (
+	"fmt"
 	"unsafe"
+
+	"go.starlark.net/starlark"
 )
 
 //export Starlark_global_names
-func Starlark_global_names(self *C.Starlark, _ *C.PyObject) *C.PyObject {
-	state := rlockSelf(self)
-	if state == nil {
-		return nil
-	}
-	defer state.Mutex.RUnlock()
+func Starlark_global_names(self *C.Starlark, _ *C.PyObject) (retval *C.PyObject) {
+	defer recoverPanic(starlarkTypeOf(self), nil, func() { retval = nil })
+
+	state := stateOf(self)
+	runlock := state.Mutex.rlock()
+	keys := state.Globals.Keys()
+	runlock()
 
 	list := C.PyList_New(0)
-	for _, key := range state.Globals.Keys() {
+	for _, key := range keys {
 		ckey := C.CString(key)
 		defer C.free(unsafe.Pointer(ckey))
 
@@ -39,32 +43,40 @@ func Starlark_global_names(self *C.Starlark, _ *C.PyObject) *C.PyObject {
 }
 
 //export Starlark_get_global
-func Starlark_get_global(self *C.Starlark, args *C.PyObject, kwargs *C.PyObject) *C.PyObject {
+func Starlark_get_global(self *C.Starlark, args *C.PyObject, kwargs *C.PyObject) (retval *C.PyObject) {
+	defer recoverPanic(starlarkTypeOf(self), nil, func() { retval = nil })
+
 	var name *C.char = nil
 	var default_value *C.PyObject = nil
+	var resultType *C.PyObject = nil
 
-	if C.parseGetGlobalArgs(args, kwargs, &name, &default_value) == 0 {
+	if C.parseGetGlobalArgs(args, kwargs, &name, &default_value, &resultType) == 0 {
 		return nil
 	}
 
 	goName := C.GoString(name)
-	state := rlockSelf(self)
-	if state == nil {
-		return nil
-	}
-	defer state.Mutex.RUnlock()
+	state := stateOf(self)
 
-	value, ok := state.Globals[goName]
+	value, ok := state.global(goName)
 	if !ok {
 		if default_value != nil {
-			return default_value
+			return C.cgoPy_NewRef(default_value)
 		}
 
 		C.PyErr_SetString(C.PyExc_KeyError, name)
 		return nil
 	}
 
-	retval, err := starlarkValueToPython(value)
+	if resultType != nil && resultType != C.Py_None {
+		retval, err := state.starlarkValueToPythonType(value, resultType)
+		if err != nil {
+			return nil
+		}
+
+		return retval
+	}
+
+	retval, err := state.starlarkValueToPython(value)
 	if err != nil {
 		return nil
 	}
@@ -73,7 +85,11 @@ func Starlark_get_global(self *C.Starlark, args *C.PyObject, kwargs *C.PyObject)
 }
 
 //export Starlark_set_globals
-func Starlark_set_globals(self *C.Starlark, args *C.PyObject, kwargs *C.PyObject) *C.PyObject {
+func Starlark_set_globals(self *C.Starlark, args *C.PyObject, kwargs *C.PyObject) (retval *C.PyObject) {
+	defer recoverPanic(starlarkTypeOf(self), nil, func() { retval = nil })
+
+	stateOf(self).releasePythonRefs()
+
 	posargs := C.PyObject_Length(args)
 
 	if posargs > 0 {
@@ -87,48 +103,74 @@ func Starlark_set_globals(self *C.Starlark, args *C.PyObject, kwargs *C.PyObject
 		return C.cgoPy_NewRef(C.Py_None)
 	}
 
-	pyiter := C.PyObject_GetIter(kwargs)
-	if pyiter == nil {
+	state := stateOf(self)
+	values, ok := state.pythonToStringDict(kwargs)
+	if !ok {
 		return nil
 	}
-	defer C.Py_DecRef(pyiter)
 
-	state := rlockSelf(self)
-	if state == nil {
+	ok = state.updateGlobals(values.Keys(), func(globals starlark.StringDict) {
+		for k, v := range values {
+			globals[k] = v
+		}
+	})
+	if !ok {
 		return nil
 	}
-	defer state.Mutex.RUnlock()
+
+	return C.cgoPy_NewRef(C.Py_None)
+}
+
+// pythonToStringDict converts a mapping with str keys to frozen Starlark
+// values. If it fails, a Python exception is set and ok is false.
+func (state *StarlarkState) pythonToStringDict(mapping *C.PyObject) (values starlark.StringDict, ok bool) {
+	pyiter := C.PyObject_GetIter(mapping)
+	if pyiter == nil {
+		return nil, false
+	}
+	defer C.Py_DecRef(pyiter)
+
+	values = starlark.StringDict{}
 
 	for pykey := C.PyIter_Next(pyiter); pykey != nil; pykey = C.PyIter_Next(pyiter) {
 		defer C.Py_DecRef(pykey)
 
-		var size C.Py_ssize_t
-		ckey := C.PyUnicode_AsUTF8AndSize(pykey, &size)
-		if ckey == nil {
-			return nil
+		key, ok := globalName(pykey)
+		if !ok {
+			if C.PyErr_Occurred() == nil {
+				raiseTypeError(fmt.Sprintf("variable names must be str, not %s", C.GoString(pykey.ob_type.tp_name)))
+			}
+			return nil, false
 		}
-		key := C.GoString(ckey)
 
-		pyvalue := C.PyObject_GetItem(kwargs, pykey)
+		pyvalue := C.PyObject_GetItem(mapping, pykey)
 		if pyvalue == nil {
-			return nil
+			return nil, false
 		}
 		defer C.Py_DecRef(pyvalue)
 
 		value, err := state.pythonToStarlarkValue(pyvalue)
 		if err != nil {
-			return nil
+			return nil, false
 		}
 
 		value.Freeze()
-		state.Globals[key] = value
+		values[key] = value
 	}
 
-	return C.cgoPy_NewRef(C.Py_None)
+	if C.PyErr_Occurred() != nil {
+		return nil, false
+	}
+
+	return values, true
 }
 
 //export Starlark_pop_global
-func Starlark_pop_global(self *C.Starlark, args *C.PyObject, kwargs *C.PyObject) *C.PyObject {
+func Starlark_pop_global(self *C.Starlark, args *C.PyObject, kwargs *C.PyObject) (retval *C.PyObject) {
+	defer recoverPanic(starlarkTypeOf(self), nil, func() { retval = nil })
+
+	stateOf(self).releasePythonRefs()
+
 	var name *C.char = nil
 	var default_value *C.PyObject = nil
 
@@ -137,24 +179,30 @@ func Starlark_pop_global(self *C.Starlark, args *C.PyObject, kwargs *C.PyObject)
 	}
 
 	goName := C.GoString(name)
-	state := rlockSelf(self)
-	if state == nil {
+	state := stateOf(self)
+
+	var (
+		value starlark.Value
+		found bool
+	)
+	ok := state.updateGlobals([]string{goName}, func(globals starlark.StringDict) {
+		value, found = globals[goName]
+		delete(globals, goName)
+	})
+	if !ok {
 		return nil
 	}
-	defer state.Mutex.RUnlock()
 
-	value, ok := state.Globals[goName]
-	if !ok {
+	if !found {
 		if default_value != nil {
-			return default_value
+			return C.cgoPy_NewRef(default_value)
 		}
 
 		C.PyErr_SetString(C.PyExc_KeyError, name)
 		return nil
 	}
 
-	delete(state.Globals, goName)
-	retval, err := starlarkValueToPython(value)
+	retval, err := state.starlarkValueToPython(value)
 	if err != nil {
 		return nil
 	}
@@ -163,10 +211,159 @@ func Starlark_pop_global(self *C.Starlark, args *C.PyObject, kwargs *C.PyObject)
 }
 
 //export Starlark_tp_iter
-func Starlark_tp_iter(self *C.Starlark) *C.PyObject {
+func Starlark_tp_iter(self *C.Starlark) (retval *C.PyObject) {
+	defer recoverPanic(starlarkTypeOf(self), nil, func() { retval = nil })
+
 	keys := Starlark_global_names(self, nil)
 	if keys == nil {
 		return nil
 	}
 	return C.PyObject_GetIter(keys)
 }
+
+// globalName returns the name of a global variable used as a mapping key. ok
+// is false if key isn't a string, or if there's an exception.
+func globalName(key *C.PyObject) (string, bool) {
+	if C.cgoPyUnicode_Check(key) != 1 {
+		return "", false
+	}
+
+	var size C.Py_ssize_t
+	ckey := C.PyUnicode_AsUTF8AndSize(key, &size)
+	if ckey == nil {
+		return "", false
+	}
+
+	return C.GoStringN(ckey, C.int(size)), true
+}
+
+//export Starlark_mp_length
+func Starlark_mp_length(self *C.Starlark) (retval C.Py_ssize_t) {
+	defer recoverPanic(starlarkTypeOf(self), nil, func() { retval = -1 })
+
+	state := stateOf(self)
+	defer state.Mutex.rlock()()
+
+	return C.Py_ssize_t(len(state.Globals))
+}
+
+//export Starlark_mp_subscript
+func Starlark_mp_subscript(self *C.Starlark, key *C.PyObject) (retval *C.PyObject) {
+	defer recoverPanic(starlarkTypeOf(self), nil, func() { retval = nil })
+
+	state := stateOf(self)
+	state.releasePythonRefs()
+
+	name, ok := globalName(key)
+	if !ok {
+		if C.PyErr_Occurred() == nil {
+			C.PyErr_SetObject(C.PyExc_KeyError, key)
+		}
+		return nil
+	}
+
+	value, ok := state.global(name)
+	if !ok {
+		C.PyErr_SetObject(C.PyExc_KeyError, key)
+		return nil
+	}
+
+	retval, err := state.starlarkValueToPython(value)
+	if err != nil {
+		return nil
+	}
+
+	return retval
+}
+
+//export Starlark_mp_ass_subscript
+func Starlark_mp_ass_subscript(self *C.Starlark, key *C.PyObject, pyvalue *C.PyObject) (retval C.int) {
+	defer recoverPanic(starlarkTypeOf(self), nil, func() { retval = -1 })
+
+	state := stateOf(self)
+	state.releasePythonRefs()
+
+	name, ok := globalName(key)
+	if !ok {
+		if C.PyErr_Occurred() == nil {
+			errmsg := C.CString(fmt.Sprintf("global variable names must be str, not %s", C.GoString(key.ob_type.tp_name)))
+			defer C.free(unsafe.Pointer(errmsg))
+			C.PyErr_SetString(C.PyExc_TypeError, errmsg)
+		}
+		return -1
+	}
+
+	// Deleting doesn't convert the value, so it works for any variable
+	if pyvalue == nil {
+		found := false
+		ok := state.updateGlobals([]string{name}, func(globals starlark.StringDict) {
+			_, found = globals[name]
+			delete(globals, name)
+		})
+		if !ok {
+			return -1
+		}
+
+		if !found {
+			C.PyErr_SetObject(C.PyExc_KeyError, key)
+			return -1
+		}
+
+		return 0
+	}
+
+	value, err := state.pythonToStarlarkValue(pyvalue)
+	if err != nil {
+		return -1
+	}
+
+	value.Freeze()
+	ok = state.updateGlobals([]string{name}, func(globals starlark.StringDict) {
+		globals[name] = value
+	})
+	if !ok {
+		return -1
+	}
+
+	return 0
+}
+
+//export Starlark_sq_contains
+func Starlark_sq_contains(self *C.Starlark, key *C.PyObject) (retval C.int) {
+	defer recoverPanic(starlarkTypeOf(self), nil, func() { retval = -1 })
+
+	name, ok := globalName(key)
+	if !ok {
+		if C.PyErr_Occurred() != nil {
+			return -1
+		}
+		return 0
+	}
+
+	if _, ok := stateOf(self).global(name); ok {
+		return 1
+	}
+
+	return 0
+}
+
+//export Starlark_clear_globals
+func Starlark_clear_globals(self *C.Starlark, _ *C.PyObject) (retval *C.PyObject) {
+	defer recoverPanic(starlarkTypeOf(self), nil, func() { retval = nil })
+
+	state := stateOf(self)
+	state.releasePythonRefs()
+
+	ok := state.updateGlobals(nil, func(globals starlark.StringDict) {
+		for name := range globals {
+			delete(globals, name)
+		}
+		// The Mutex is held while updating, so the sources can be replaced too
+		state.Sources = nil
+	})
+	if !ok {
+		return nil
+	}
+
+	return C.cgoPy_NewRef(C.Py_None)
+}
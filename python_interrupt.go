@@ -0,0 +1,116 @@
+package main
+
+/*
+#include "starlark.h"
+*/
+import "C"
+
+import (
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+
+	"go.starlark.net/starlark"
+)
+
+// Python's SIGINT handler only sets a flag that the main thread checks while
+// it runs Python code, so it isn't noticed while Starlark runs without the
+// GIL. While code is being evaluated on the main thread we take SIGINT away
+// from Python, cancel the evaluation, and hand the signal back afterwards.
+var interrupts struct {
+	sync.Mutex
+	watches map[*interruptWatch]bool
+	signals chan os.Signal
+}
+
+// interruptWatch cancels one Starlark thread if SIGINT arrives while it runs.
+type interruptWatch struct {
+	thread      *starlark.Thread
+	interrupted atomic.Bool
+	stopped     bool
+}
+
+// watchInterrupts starts cancelling thread on SIGINT. It must be called with
+// the GIL held, and returns nil if Python wouldn't raise KeyboardInterrupt in
+// this thread anyway.
+func watchInterrupts(thread *starlark.Thread) *interruptWatch {
+	if C.isInterruptible() == 0 {
+		return nil
+	}
+
+	w := &interruptWatch{thread: thread}
+
+	interrupts.Lock()
+	defer interrupts.Unlock()
+
+	if interrupts.watches == nil {
+		interrupts.watches = map[*interruptWatch]bool{}
+		interrupts.signals = make(chan os.Signal, 1)
+		signal.Notify(interrupts.signals, os.Interrupt)
+		go forwardInterrupts(interrupts.signals)
+	}
+	interrupts.watches[w] = true
+
+	return w
+}
+
+func forwardInterrupts(signals chan os.Signal) {
+	for range signals {
+		interrupts.Lock()
+		for w := range interrupts.watches {
+			w.interrupted.Store(true)
+			w.thread.Cancel("interrupted")
+		}
+		interrupts.Unlock()
+	}
+}
+
+// Stop stops watching for SIGINT, and gives Python back its handler once no
+// evaluation is being watched. If SIGINT arrived but the evaluation finished
+// without error anyway, Python is told about it, so that it runs its handler
+// the next time it checks for signals. Only the first call has any effect.
+func (w *interruptWatch) Stop(err error) {
+	if w == nil || w.stopped {
+		return
+	}
+	w.stopped = true
+
+	interrupts.Lock()
+	delete(interrupts.watches, w)
+	if len(interrupts.watches) == 0 {
+		signal.Stop(interrupts.signals)
+		close(interrupts.signals)
+		interrupts.watches = nil
+		interrupts.signals = nil
+	}
+	interrupts.Unlock()
+
+	if err == nil && w.interrupted.Load() {
+		C.PyErr_SetInterrupt()
+	}
+}
+
+// Interrupted reports whether SIGINT arrived while the thread was watched.
+func (w *interruptWatch) Interrupted() bool {
+	return w != nil && w.interrupted.Load()
+}
+
+// raiseInterruptedPythonException raises the error of an evaluation that was
+// cancelled by SIGINT. Python's handler is run straight away, and whatever it
+// raises (normally KeyboardInterrupt) is raised from the Starlark error. If
+// the handler doesn't raise anything, the Starlark error is raised alone.
+func (state *StarlarkState) raiseInterruptedPythonException(err error) {
+	state.raisePythonException(err)
+
+	ptype, pvalue, _ := getCurrentPythonException()
+	C.PyErr_SetInterrupt()
+	if C.PyErr_CheckSignals() == 0 {
+		C.PyErr_Restore(ptype, pvalue, nil)
+		return
+	}
+
+	C.Py_DecRef(ptype)
+	// This "steals" the ref to pvalue so we don't need to DecRef after
+	setPythonExceptionCause(pvalue)
+}
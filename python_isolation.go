@@ -0,0 +1,79 @@
+package main
+
+/*
+#include "starlark.h"
+*/
+import "C"
+
+import (
+	"unsafe"
+)
+
+func raiseValueError(msg string) {
+	cmsg := C.CString(msg)
+	defer C.free(unsafe.Pointer(cmsg))
+	C.PyErr_SetString(C.PyExc_ValueError, cmsg)
+}
+
+func getDictItem(dict *C.PyObject, key string) *C.PyObject {
+	if dict == nil {
+		return nil
+	}
+
+	ckey := C.CString(key)
+	defer C.free(unsafe.Pointer(ckey))
+	return C.PyDict_GetItemString(dict, ckey)
+}
+
+// newIsolatedStarlark creates a starlark_go.SubprocessStarlark if the
+// arguments to Starlark() ask for one. It returns nil and true if they don't,
+// and nil and false if there's an exception.
+func newIsolatedStarlark(args *C.PyObject, kwargs *C.PyObject) (*C.PyObject, bool) {
+	isolation := getDictItem(kwargs, "isolation")
+	if isolation == nil || isolation == C.Py_None {
+		for _, name := range []string{"memory_limit", "cpu_limit"} {
+			if value := getDictItem(kwargs, name); value != nil && value != C.Py_None {
+				raiseValueError(name + ` requires isolation="subprocess"`)
+				return nil, false
+			}
+		}
+
+		return nil, true
+	}
+
+	csubprocess := C.CString("subprocess")
+	defer C.free(unsafe.Pointer(csubprocess))
+
+	if C.cgoPyUnicode_Check(isolation) != 1 || C.PyUnicode_CompareWithASCIIString(isolation, csubprocess) != 0 {
+		raiseValueError(`isolation must be None or "subprocess"`)
+		return nil, false
+	}
+
+	kwargs = C.PyDict_Copy(kwargs)
+	if kwargs == nil {
+		return nil, false
+	}
+	defer C.Py_DecRef(kwargs)
+
+	cisolation := C.CString("isolation")
+	defer C.free(unsafe.Pointer(cisolation))
+	C.PyDict_DelItemString(kwargs, cisolation)
+
+	cmodule := C.CString("starlark_go.isolation")
+	defer C.free(unsafe.Pointer(cmodule))
+
+	module := C.PyImport_ImportModule(cmodule)
+	if module == nil {
+		return nil, false
+	}
+	defer C.Py_DecRef(module)
+
+	cls := getPythonAttr(module, "SubprocessStarlark")
+	if cls == nil {
+		return nil, false
+	}
+	defer C.Py_DecRef(cls)
+
+	obj := C.PyObject_Call(cls, args, kwargs)
+	return obj, obj != nil
+}
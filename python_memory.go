@@ -0,0 +1,102 @@
+package main
+
+import (
+	"runtime"
+	"runtime/debug"
+	"runtime/metrics"
+	"sync/atomic"
+	"time"
+
+	"go.starlark.net/starlark"
+)
+
+// How often the Go heap is sampled while code with a memory limit runs
+const memoryWatchInterval = 10 * time.Millisecond
+
+const heapObjectsMetric = "/memory/classes/heap/objects:bytes"
+
+// memoryWatch cancels one Starlark thread if the Go heap grows beyond a limit
+// while it runs. The Go heap is shared by every Starlark object and thread in
+// the process, so this bounds the process rather than one evaluation.
+type memoryWatch struct {
+	thread   *starlark.Thread
+	max      uint64
+	exceeded atomic.Bool
+	done     chan struct{}
+}
+
+// watchMemory starts cancelling thread once the Go heap is larger than max
+// bytes. It returns nil if max isn't positive.
+func watchMemory(thread *starlark.Thread, max int) *memoryWatch {
+	if max <= 0 {
+		return nil
+	}
+
+	w := &memoryWatch{
+		thread: thread,
+		max:    uint64(max),
+		done:   make(chan struct{}),
+	}
+	go w.run(w.done)
+
+	return w
+}
+
+func (w *memoryWatch) run(done chan struct{}) {
+	ticker := time.NewTicker(memoryWatchInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+
+		if heapObjectsSize() <= w.max {
+			continue
+		}
+
+		// The sample includes garbage that hasn't been collected yet, so only
+		// give up on the thread if the heap is still too large after a GC
+		runtime.GC()
+		if heapObjectsSize() > w.max {
+			w.exceeded.Store(true)
+			w.thread.Cancel("memory limit exceeded")
+			return
+		}
+	}
+}
+
+// Stop stops sampling the heap. If the limit was exceeded, the memory that
+// the cancelled code allocated is handed back to the OS straight away, rather
+// than whenever the GC gets round to it. That has to wait until the code has
+// returned, so that its values are garbage. Only the first call has any effect.
+func (w *memoryWatch) Stop() {
+	if w == nil || w.done == nil {
+		return
+	}
+
+	close(w.done)
+	w.done = nil
+
+	if w.exceeded.Load() {
+		debug.FreeOSMemory()
+	}
+}
+
+// Exceeded reports whether the thread was cancelled because of the limit.
+func (w *memoryWatch) Exceeded() bool {
+	return w != nil && w.exceeded.Load()
+}
+
+func heapObjectsSize() uint64 {
+	sample := []metrics.Sample{{Name: heapObjectsMetric}}
+	metrics.Read(sample)
+
+	if sample[0].Value.Kind() != metrics.KindUint64 {
+		return 0
+	}
+
+	return sample[0].Value.Uint64()
+}
@@ -0,0 +1,54 @@
+package main
+
+/*
+#include "starlark.h"
+*/
+import "C"
+
+//export Starlark_exec_module
+func Starlark_exec_module(self *C.Starlark, args *C.PyObject, kwargs *C.PyObject) (retval *C.PyObject) {
+	var pt *pythonThread
+	defer recoverPanic(starlarkTypeOf(self), &pt, func() { retval = nil })
+
+	stateOf(self).releasePythonRefs()
+
+	var (
+		source     *C.char
+		filename   *C.char      = nil
+		print      *C.PyObject  = nil
+		timeout    C.double     = 0
+		maxMemory  C.Py_ssize_t = 0
+		options    *C.PyObject  = nil
+		goFilename string       = "<module>"
+	)
+
+	if C.parseExecModuleArgs(args, kwargs, &source, &filename, &print, &timeout, &maxMemory, &options) == 0 {
+		return nil
+	}
+
+	state := stateOf(self)
+	callOptions, ok := state.pythonToFileOptions(options)
+	if !ok {
+		return nil
+	}
+
+	print = pythonPrint(self, print)
+	if print == nil {
+		return nil
+	}
+	defer C.Py_DecRef(print)
+
+	if filename != nil {
+		goFilename = C.GoString(filename)
+	}
+
+	// The globals are predeclared, so the module can read them but whatever it
+	// defines stays in its own namespace
+	resolvedOptions := state.options(callOptions).resolve(state.module)
+	newGlobals, _, _, ok := state.runProgram(&pt, print, goFilename, C.GoString(source), resolvedOptions, timeout, maxMemory)
+	if !ok {
+		return nil
+	}
+
+	return state.newChild(self, newGlobals, nil)
+}
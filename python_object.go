@@ -2,11 +2,6 @@ package main
 
 /*
 #include "starlark.h"
-
-extern PyObject *StarlarkError;
-extern PyObject *SyntaxError;
-extern PyObject *EvalError;
-extern PyObject *ResolveError;
 */
 import "C"
 
@@ -16,89 +11,313 @@ import (
 	"sync"
 	"unsafe"
 
-	"go.starlark.net/resolve"
 	"go.starlark.net/starlark"
 )
 
 type StarlarkState struct {
-	Globals     starlark.StringDict
-	Mutex       sync.RWMutex
-	Print       *C.PyObject
-	threadState *C.PyThreadState
+	// Changed in place, holding the Mutex: functions look up the names
+	// they don't define in this map when they're called
+	Globals starlark.StringDict
+	// The calls to exec that defined the globals, oldest first, so that they
+	// can be saved. This is never modified once stored.
+	Sources []*execSource
+	// Callbacks registered with watch. This is never modified once stored.
+	Watchers []*globalsWatcher
+	// The state of the module that created this object, which has the Python
+	// objects we need. Each interpreter that imports us has its own.
+	module *C.StarlarkModuleState
+	// Shared with the objects forked from this one and the modules it
+	// executed, since their functions use its globals
+	Mutex *stateMutex
+	Print *C.PyObject
+	// Called with the fields of a Starlark struct or module as keyword
+	// arguments when converting one to Python; nil means SimpleNamespace.
+	StructFactory *C.PyObject
+	// If true, arguments passed from Starlark to Python callables are
+	// converted to the types in the callable's annotations
+	CoerceArgs bool
+	// The dialect of Starlark to accept, which calls can override
+	Options fileOptions
 	// Most Python values are copied into a new starlark.Value, including
 	// lists, dicts, sets, etc. But some values, namely functions, keep a
-	// reference to the original function, so we need to INCREF the function
-	// and DECREF when Starlark is deallocated.
-	//
-	// Currently, we only DECREF everything on deallocation, so there's a
-	// memory leak if someone keeps replacing the same global with a different
-	// function, but that should be rare and would make the implementation more
-	// difficult.
-	childRefs   []*C.PyObject
+	// reference to the original Python object. childRefs counts the
+	// references held by Starlark values; when one of those values is
+	// garbage collected, its reference is moved to deadRefs, to be released
+	// the next time we hold the GIL. See python_refs.go.
+	childRefs *pythonRefs
 }
 
 //export ConfigureStarlark
-func ConfigureStarlark(allowSet C.int, allowGlobalReassign C.int, allowRecursion C.int) {
+func ConfigureStarlark(module *C.StarlarkModuleState, allowSet C.int, allowGlobalReassign C.int, allowRecursion C.int) {
+	defer recoverPanic(module.StarlarkType, nil, func() {})
+
+	defaultOptionsMutex.Lock()
+	defer defaultOptionsMutex.Unlock()
+
 	// Ignore input values other than 0 or 1 and leave current value in place
-	switch allowSet {
-	case 0:
-		resolve.AllowSet = false
-	case 1:
-		resolve.AllowSet = true
+	for _, option := range []struct {
+		value  C.int
+		target *C.int
+	}{
+		{allowSet, &module.AllowSet},
+		{allowGlobalReassign, &module.AllowGlobalReassign},
+		{allowRecursion, &module.AllowRecursion},
+	} {
+		if option.value == 0 || option.value == 1 {
+			*option.target = option.value
+		}
+	}
+}
+
+// stateOf returns the state without locking it.
+func stateOf(self *C.Starlark) *StarlarkState {
+	return cgo.Handle(self.handle).Value().(*StarlarkState)
+}
+
+// lockSelf returns the state with its Mutex held for writing, or nil if
+// there's an exception.
+func lockSelf(self *C.Starlark) *StarlarkState {
+	state := stateOf(self)
+	if !state.Mutex.lock() {
+		return nil
+	}
+	return state
+}
+
+// stateMutex guards a StarlarkState. Code being evaluated holds it for
+// reading until it's done, since the globals are changed in place.
+type stateMutex struct {
+	rw sync.RWMutex
+	// How many times each Python thread holds rw for reading
+	readers      map[*C.PyThreadState]int
+	readersMutex sync.Mutex
+}
+
+func newStateMutex() *stateMutex {
+	return &stateMutex{readers: map[*C.PyThreadState]int{}}
+}
+
+// held returns true if the Python thread holds the mutex for reading.
+func (m *stateMutex) held(thread *C.PyThreadState) bool {
+	m.readersMutex.Lock()
+	defer m.readersMutex.Unlock()
+
+	return m.readers[thread] > 0
+}
+
+// rlock takes the mutex for reading, and returns the function that releases
+// it. Python functions called by code being evaluated can use the object
+// again, so a thread that holds the mutex already takes it again straight
+// away, rather than waiting for a writer that waits for it. Other threads
+// wait without the GIL, which the code they wait for may need.
+func (m *stateMutex) rlock() (runlock func()) {
+	thread := C.currentThreadState()
+	if thread == nil {
+		m.rw.RLock()
+		return m.rw.RUnlock
 	}
 
-	switch allowGlobalReassign {
-	case 0:
-		resolve.AllowGlobalReassign = false
-	case 1:
-		resolve.AllowGlobalReassign = true
+	if !m.held(thread) && !m.rw.TryRLock() {
+		saved := C.PyEval_SaveThread()
+		m.rw.RLock()
+		C.PyEval_RestoreThread(saved)
 	}
 
-	switch allowRecursion {
-	case 0:
-		resolve.AllowRecursion = false
-	case 1:
-		resolve.AllowRecursion = true
+	m.readersMutex.Lock()
+	m.readers[thread]++
+	m.readersMutex.Unlock()
+
+	return func() {
+		m.readersMutex.Lock()
+		m.readers[thread]--
+		last := m.readers[thread] == 0
+		if last {
+			delete(m.readers, thread)
+		}
+		m.readersMutex.Unlock()
+
+		if last {
+			m.rw.RUnlock()
+		}
 	}
 }
 
-func rlockSelf(self *C.Starlark) *StarlarkState {
-	state := cgo.Handle(self.handle).Value().(*StarlarkState)
-	state.Mutex.RLock()
-	return state
+// lock takes the mutex for writing, waiting without the GIL. A Python
+// function called by code being evaluated would wait for itself, so it gets
+// a RuntimeError instead, and false is returned.
+func (m *stateMutex) lock() bool {
+	thread := C.currentThreadState()
+	if thread == nil {
+		m.rw.Lock()
+		return true
+	}
+
+	if m.held(thread) {
+		raiseRuntimeError("can't change a Starlark object while it's evaluating code in the same thread")
+		return false
+	}
+
+	if !m.rw.TryLock() {
+		saved := C.PyEval_SaveThread()
+		m.rw.Lock()
+		C.PyEval_RestoreThread(saved)
+	}
+
+	return true
 }
 
-func lockSelf(self *C.Starlark) *StarlarkState {
-	state := cgo.Handle(self.handle).Value().(*StarlarkState)
-	state.Mutex.Lock()
+func (m *stateMutex) unlock() {
+	m.rw.Unlock()
+}
+
+// global returns the value of a global variable.
+func (state *StarlarkState) global(name string) (starlark.Value, bool) {
+	defer state.Mutex.rlock()()
+
+	value, ok := state.Globals[name]
+	return value, ok
+}
+
+// updateGlobals calls update to change the globals in place, and then tells
+// the watchers what changed. names are the globals that update may change,
+// or nil if it may change any of them. update must not call into Python,
+// since the Mutex is held. If the Mutex can't be taken, a Python exception
+// is set and false is returned.
+func (state *StarlarkState) updateGlobals(names []string, update func(globals starlark.StringDict)) bool {
+	old, new, ok := state.changeGlobals(names, update)
+	if ok {
+		state.notifyWatchers(old, new)
+	}
+	return ok
+}
+
+// changeGlobals does the work of updateGlobals, and returns the values of
+// the globals it may have changed before and after, if anybody is watching.
+func (state *StarlarkState) changeGlobals(names []string, update func(globals starlark.StringDict)) (old starlark.StringDict, new starlark.StringDict, ok bool) {
+	if !state.Mutex.lock() {
+		return nil, nil, false
+	}
+	defer state.Mutex.unlock()
+
+	watched := len(state.Watchers) > 0
+	if watched {
+		old = state.someGlobals(names)
+	}
+
+	update(state.Globals)
+
+	if watched {
+		new = state.someGlobals(names)
+	}
+
+	return old, new, true
+}
+
+// someGlobals returns a copy of the named globals that exist, or of all of
+// them if names is nil. The Mutex must be held.
+func (state *StarlarkState) someGlobals(names []string) starlark.StringDict {
+	if names == nil {
+		names = state.Globals.Keys()
+	}
+
+	globals := make(starlark.StringDict, len(names))
+	for _, name := range names {
+		if value, ok := state.Globals[name]; ok {
+			globals[name] = value
+		}
+	}
+
+	return globals
+}
+
+// pythonThread holds the Python thread state of one call into Starlark while
+// it runs without the GIL. Each call has its own so that several threads can
+// evaluate code at once. Free-threaded builds of Python have no GIL, but the
+// thread state still has to be detached while Starlark runs, or Python's
+// garbage collector would wait for it to finish.
+type pythonThread struct {
+	threadState *C.PyThreadState
+}
+
+const (
+	pythonThreadKey  = "python_starlark_go.thread"
+	starlarkStateKey = "python_starlark_go.state"
+)
+
+// newThread returns a Starlark thread to run code for state on.
+func (state *StarlarkState) newThread() *starlark.Thread {
+	thread := &starlark.Thread{}
+	thread.SetLocal(starlarkStateKey, state)
+	return thread
+}
+
+// stateOfThread returns the state of the Starlark object whose code a thread
+// runs. Builtins use it rather than the state that created them, since
+// forks and modules share them with the object they came from. It's nil for
+// threads we didn't start.
+func stateOfThread(thread *starlark.Thread) *StarlarkState {
+	state, _ := thread.Local(starlarkStateKey).(*StarlarkState)
 	return state
 }
 
-func (state *StarlarkState) DetachGIL() {
-	state.threadState = C.PyEval_SaveThread()
+func newPythonThread(thread *starlark.Thread) *pythonThread {
+	pt := &pythonThread{}
+	thread.SetLocal(pythonThreadKey, pt)
+	return pt
+}
+
+// pythonThreadOf returns the pythonThread of a Starlark thread, which is how
+// builtins get the GIL back to call Python. It's nil for threads we didn't
+// start, which already hold the GIL.
+func pythonThreadOf(thread *starlark.Thread) *pythonThread {
+	pt, _ := thread.Local(pythonThreadKey).(*pythonThread)
+	return pt
+}
+
+func (pt *pythonThread) DetachGIL() {
+	if pt == nil {
+		return
+	}
+
+	pt.threadState = C.PyEval_SaveThread()
 }
 
-func (state *StarlarkState) ReattachGIL() {
-	if state.threadState == nil {
+func (pt *pythonThread) ReattachGIL() {
+	if pt == nil || pt.threadState == nil {
 		return
 	}
 
-	C.PyEval_RestoreThread(state.threadState)
-	state.threadState = nil
+	C.PyEval_RestoreThread(pt.threadState)
+	pt.threadState = nil
 }
 
 //export Starlark_new
-func Starlark_new(pytype *C.PyTypeObject, args *C.PyObject, kwargs *C.PyObject) *C.Starlark {
+func Starlark_new(pytype *C.PyTypeObject, args *C.PyObject, kwargs *C.PyObject) (retval *C.Starlark) {
+	defer recoverPanic(pytype, nil, func() { retval = nil })
+
+	// Python doesn't call __init__ on what we return if it isn't a Starlark
+	if isolated, ok := newIsolatedStarlark(args, kwargs); !ok {
+		return nil
+	} else if isolated != nil {
+		return (*C.Starlark)(unsafe.Pointer(isolated))
+	}
+
+	module := C.starlarkModuleState(pytype)
+	if module == nil {
+		return nil
+	}
+
 	self := C.starlarkAlloc(pytype)
 	if self == nil {
 		return nil
 	}
 
 	state := &StarlarkState{
-		Globals: starlark.StringDict{},
-		Mutex: sync.RWMutex{},
-		Print: nil,
-		threadState: nil,
+		Globals:   starlark.StringDict{},
+		module:    module,
+		Mutex:     newStateMutex(),
+		Print:     nil,
+		childRefs: newPythonRefs(),
 	}
 	self.handle = C.uintptr_t(cgo.NewHandle(state))
 
@@ -106,13 +325,25 @@ func Starlark_new(pytype *C.PyTypeObject, args *C.PyObject, kwargs *C.PyObject)
 }
 
 //export Starlark_init
-func Starlark_init(self *C.Starlark, args *C.PyObject, kwargs *C.PyObject) C.int {
+func Starlark_init(self *C.Starlark, args *C.PyObject, kwargs *C.PyObject) (retval C.int) {
+	defer recoverPanic(starlarkTypeOf(self), nil, func() { retval = -1 })
+
 	var globals *C.PyObject = nil
 	var print *C.PyObject = nil
+	var structFactory *C.PyObject = nil
+	var coerceArgs C.int = 0
+	var options *C.PyObject = nil
+
+	if C.parseInitArgs(args, kwargs, &globals, &print, &structFactory, &coerceArgs, &options) == 0 {
+		return -1
+	}
 
-	if C.parseInitArgs(args, kwargs, &globals, &print) == 0 {
+	state := lockSelf(self)
+	if state == nil {
 		return -1
 	}
+	state.CoerceArgs = coerceArgs != 0
+	state.Mutex.unlock()
 
 	if print != nil {
 		if Starlark_set_print(self, print, nil) != 0 {
@@ -120,6 +351,18 @@ func Starlark_init(self *C.Starlark, args *C.PyObject, kwargs *C.PyObject) C.int
 		}
 	}
 
+	if structFactory != nil {
+		if Starlark_set_struct_factory(self, structFactory, nil) != 0 {
+			return -1
+		}
+	}
+
+	if options != nil {
+		if Starlark_set_options(self, options, nil) != 0 {
+			return -1
+		}
+	}
+
 	if globals != nil {
 		if C.PyMapping_Check(globals) != 1 {
 			errmsg := C.CString(fmt.Sprintf("Can't initialize globals from %s", C.GoString(globals.ob_type.tp_name)))
@@ -137,24 +380,118 @@ func Starlark_init(self *C.Starlark, args *C.PyObject, kwargs *C.PyObject) C.int
 	return 0
 }
 
+//export Starlark_traverse
+func Starlark_traverse(self *C.Starlark, visit C.visitproc, arg unsafe.Pointer) (retval C.int) {
+	// The garbage collector can't handle exceptions
+	defer recoverPanic(starlarkTypeOf(self), nil, func() {
+		C.PyErr_WriteUnraisable(nil)
+		retval = 0
+	})
+
+	// Instances of heap types have to visit their type
+	if ret := C.cgoVisit(visit, C.cgoPy_TYPE(self), arg); ret != 0 {
+		return ret
+	}
+
+	// Not initialized yet, so there's nothing else to visit
+	if self.handle == 0 {
+		return 0
+	}
+
+	// Python stops every other thread during garbage collection, even when
+	// there's no GIL, so it's safe to read these without the Mutex
+	state := stateOf(self)
+
+	for _, obj := range []*C.PyObject{state.Print, state.StructFactory} {
+		if obj != nil {
+			if ret := C.cgoVisit(visit, obj, arg); ret != 0 {
+				return ret
+			}
+		}
+	}
+
+	for _, watcher := range state.Watchers {
+		if ret := C.cgoVisit(visit, watcher.callback, arg); ret != 0 {
+			return ret
+		}
+	}
+
+	return state.visitPythonRefs(func(obj *C.PyObject) C.int {
+		return C.cgoVisit(visit, obj, arg)
+	})
+}
+
+//export Starlark_clear
+func Starlark_clear(self *C.Starlark) (retval C.int) {
+	defer recoverPanic(starlarkTypeOf(self), nil, func() {
+		C.PyErr_WriteUnraisable(nil)
+		retval = 0
+	})
+
+	if self.handle == 0 {
+		return 0
+	}
+
+	// Nothing can reach the object any more, so the Mutex isn't needed, and
+	// it may be held by forks that are evaluating code. The globals are
+	// replaced rather than cleared, since their functions may still use them.
+	state := stateOf(self)
+	print := state.Print
+	structFactory := state.StructFactory
+	state.Print = nil
+	state.StructFactory = nil
+	// The globals are the only way to reach the values that hold childRefs,
+	// so they have to go before the references are released
+	state.Globals = starlark.StringDict{}
+	state.Sources = nil
+
+	state.releaseAllPythonRefs()
+	state.releaseWatchers()
+
+	if print != nil {
+		C.Py_DecRef(print)
+	}
+
+	if structFactory != nil {
+		C.Py_DecRef(structFactory)
+	}
+
+	return 0
+}
+
 //export Starlark_dealloc
 func Starlark_dealloc(self *C.Starlark) {
+	defer recoverPanic(starlarkTypeOf(self), nil, func() {
+		C.PyErr_WriteUnraisable(nil)
+	})
+
+	C.PyObject_GC_UnTrack(unsafe.Pointer(self))
+
+	if self.weakreflist != nil {
+		C.PyObject_ClearWeakRefs((*C.PyObject)(unsafe.Pointer(self)))
+	}
+
 	handle := cgo.Handle(self.handle)
 	state := handle.Value().(*StarlarkState)
 
 	handle.Delete()
 
-	state.Mutex.Lock()
-	defer state.Mutex.Unlock()
+	// Nothing can reach the object any more, so the Mutex isn't needed, and
+	// it may be held by forks that are evaluating code
+	state.releaseAllPythonRefs()
 
-	for _, obj := range state.childRefs {
-		C.Py_DecRef(obj)
+	for _, watcher := range state.Watchers {
+		C.Py_DecRef(watcher.callback)
 	}
 
 	if state.Print != nil {
 		C.Py_DecRef(state.Print)
 	}
 
+	if state.StructFactory != nil {
+		C.Py_DecRef(state.StructFactory)
+	}
+
 	C.starlarkFree(self)
 }
 
@@ -0,0 +1,238 @@
+package main
+
+/*
+#include "starlark.h"
+*/
+import "C"
+
+import (
+	"fmt"
+	"sync"
+	"unsafe"
+
+	"go.starlark.net/syntax"
+)
+
+// Guards the options used for anything that isn't set by FileOptions, which
+// configure_starlark changes. They're kept in the module state, so that each
+// interpreter has its own, but interpreters with their own GIL, or none at
+// all, can still change them while code is being compiled.
+var defaultOptionsMutex sync.RWMutex
+
+// fileOptions is the Go side of starlark_go.FileOptions. Options that are nil
+// are inherited.
+type fileOptions struct {
+	set               *bool
+	globalReassign    *bool
+	recursion         *bool
+	while             *bool
+	topLevelControl   *bool
+	loadBindsGlobally *bool
+}
+
+type fileOptionsField struct {
+	name  string
+	value **bool
+}
+
+// fields returns the Python names of each option, along with where it's kept.
+func (o *fileOptions) fields() []fileOptionsField {
+	return []fileOptionsField{
+		{"allow_set", &o.set},
+		{"allow_global_reassign", &o.globalReassign},
+		{"allow_recursion", &o.recursion},
+		{"allow_while", &o.while},
+		{"allow_top_level_control", &o.topLevelControl},
+		{"load_binds_globally", &o.loadBindsGlobally},
+	}
+}
+
+// inherit returns the options in o, with any that aren't set taken from base.
+func (o fileOptions) inherit(base fileOptions) fileOptions {
+	retval := o
+	baseFields := base.fields()
+	for i, field := range retval.fields() {
+		if *field.value == nil {
+			*field.value = *baseFields[i].value
+		}
+	}
+
+	return retval
+}
+
+// defaultOptions returns the options set by configure_starlark in the
+// interpreter of module.
+func defaultOptions(module *C.StarlarkModuleState) syntax.FileOptions {
+	defaultOptionsMutex.RLock()
+	defer defaultOptionsMutex.RUnlock()
+
+	return syntax.FileOptions{
+		Set:             module.AllowSet != 0,
+		GlobalReassign:  module.AllowGlobalReassign != 0,
+		TopLevelControl: module.AllowGlobalReassign != 0,
+		Recursion:       module.AllowRecursion != 0,
+		While:           module.AllowRecursion != 0,
+	}
+}
+
+// syntaxOptions returns the options to compile code with, taking any that
+// aren't set from the defaults of module.
+func (o fileOptions) syntaxOptions(module *C.StarlarkModuleState) *syntax.FileOptions {
+	opts := defaultOptions(module)
+
+	for _, option := range []struct {
+		value  *bool
+		target *bool
+	}{
+		{o.set, &opts.Set},
+		{o.globalReassign, &opts.GlobalReassign},
+		{o.recursion, &opts.Recursion},
+		{o.while, &opts.While},
+		{o.topLevelControl, &opts.TopLevelControl},
+		{o.loadBindsGlobally, &opts.LoadBindsGlobally},
+	} {
+		if option.value != nil {
+			*option.target = *option.value
+		}
+	}
+
+	return &opts
+}
+
+// resolve returns the options that o results in, with none left unset.
+func (o fileOptions) resolve(module *C.StarlarkModuleState) fileOptions {
+	opts := o.syntaxOptions(module)
+	value := func(b bool) *bool { return &b }
+
+	return fileOptions{
+		set:               value(opts.Set),
+		globalReassign:    value(opts.GlobalReassign),
+		recursion:         value(opts.Recursion),
+		while:             value(opts.While),
+		topLevelControl:   value(opts.TopLevelControl),
+		loadBindsGlobally: value(opts.LoadBindsGlobally),
+	}
+}
+
+// pythonToFileOptions converts a starlark_go.FileOptions, or None, to Go.
+func (state *StarlarkState) pythonToFileOptions(obj *C.PyObject) (fileOptions, bool) {
+	options := fileOptions{}
+	if obj == nil || obj == C.Py_None {
+		return options, true
+	}
+
+	if C.PyObject_IsInstance(obj, state.module.FileOptionsType) != 1 {
+		if C.PyErr_Occurred() == nil {
+			errmsg := C.CString(fmt.Sprintf("options must be FileOptions, not %s", C.GoString(obj.ob_type.tp_name)))
+			defer C.free(unsafe.Pointer(errmsg))
+			C.PyErr_SetString(C.PyExc_TypeError, errmsg)
+		}
+		return options, false
+	}
+
+	for _, field := range options.fields() {
+		value := getPythonAttr(obj, field.name)
+		if value == nil {
+			return options, false
+		}
+
+		if value != C.Py_None {
+			isTrue := C.PyObject_IsTrue(value)
+			if isTrue < 0 {
+				C.Py_DecRef(value)
+				return options, false
+			}
+
+			b := isTrue == 1
+			*field.value = &b
+		}
+		C.Py_DecRef(value)
+	}
+
+	return options, true
+}
+
+// fileOptionsToPython converts options to a new starlark_go.FileOptions.
+func (state *StarlarkState) fileOptionsToPython(options fileOptions) *C.PyObject {
+	kwargs := C.PyDict_New()
+	if kwargs == nil {
+		return nil
+	}
+	defer C.Py_DecRef(kwargs)
+
+	for _, field := range options.fields() {
+		if *field.value == nil {
+			continue
+		}
+
+		value := C.Py_False
+		if **field.value {
+			value = C.Py_True
+		}
+
+		cname := C.CString(field.name)
+		defer C.free(unsafe.Pointer(cname))
+
+		// This does not steal references
+		if C.PyDict_SetItemString(kwargs, cname, value) != 0 {
+			return nil
+		}
+	}
+
+	args := C.PyTuple_New(0)
+	if args == nil {
+		return nil
+	}
+	defer C.Py_DecRef(args)
+
+	return C.PyObject_Call(state.module.FileOptionsType, args, kwargs)
+}
+
+// options returns the options for a call, which inherit from the options of
+// the instance.
+func (state *StarlarkState) options(callOptions fileOptions) fileOptions {
+	defer state.Mutex.rlock()()
+
+	return callOptions.inherit(state.Options)
+}
+
+//export StarlarkGo_resolveOptions
+func StarlarkGo_resolveOptions(module *C.StarlarkModuleState, obj *C.PyObject) (retval *C.PyObject) {
+	defer recoverPanic(module.StarlarkType, nil, func() { retval = nil })
+
+	// Converting options only needs the module
+	state := &StarlarkState{module: module}
+	options, ok := state.pythonToFileOptions(obj)
+	if !ok {
+		return nil
+	}
+
+	return state.fileOptionsToPython(options.resolve(module))
+}
+
+//export Starlark_get_options
+func Starlark_get_options(self *C.Starlark, closure unsafe.Pointer) (retval *C.PyObject) {
+	defer recoverPanic(starlarkTypeOf(self), nil, func() { retval = nil })
+
+	state := stateOf(self)
+	return state.fileOptionsToPython(state.options(fileOptions{}))
+}
+
+//export Starlark_set_options
+func Starlark_set_options(self *C.Starlark, value *C.PyObject, closure unsafe.Pointer) (retval C.int) {
+	defer recoverPanic(starlarkTypeOf(self), nil, func() { retval = -1 })
+
+	state := stateOf(self)
+	options, ok := state.pythonToFileOptions(value)
+	if !ok {
+		return -1
+	}
+
+	if !state.Mutex.lock() {
+		return -1
+	}
+	state.Options = options
+	state.Mutex.unlock()
+
+	return 0
+}
@@ -0,0 +1,86 @@
+package main
+
+/*
+#include "starlark.h"
+*/
+import "C"
+
+import (
+	"fmt"
+	"runtime/debug"
+	"unsafe"
+)
+
+// recoverPanic turns a Go panic into a StarlarkInternalError, so that a bug
+// in a builtin, in the conversion code or in starlark-go doesn't take down
+// the whole Python process. Every exported function must defer it, with the
+// type of the Starlark object it was called for and a function that sets its
+// result on the way out. If the panic happened while Starlark was running
+// without the GIL, *pt gets it back first.
+func recoverPanic(pytype *C.PyTypeObject, pt **pythonThread, onPanic func()) {
+	r := recover()
+	if r == nil {
+		return
+	}
+
+	stack := debug.Stack()
+
+	if pt != nil {
+		(*pt).ReattachGIL()
+	}
+
+	raiseInternalError(pytype, r, stack)
+	onPanic()
+}
+
+func raiseInternalError(pytype *C.PyTypeObject, r interface{}, stack []byte) {
+	module := C.starlarkModuleState(pytype)
+	if module == nil {
+		return
+	}
+
+	var msg string
+	if err, ok := r.(error); ok {
+		msg = err.Error()
+	} else {
+		msg = fmt.Sprint(r)
+	}
+
+	error_msg := C.CString(msg)
+	defer C.free(unsafe.Pointer(error_msg))
+
+	error_type := C.CString(fmt.Sprintf("%T", r))
+	defer C.free(unsafe.Pointer(error_type))
+
+	go_stack := C.CString(string(stack))
+	defer C.free(unsafe.Pointer(go_stack))
+
+	exc_args := C.makeInternalErrorArgs(error_msg, error_type, go_stack)
+	if exc_args == nil {
+		return
+	}
+	defer C.Py_DecRef(exc_args)
+
+	// Raise an instance rather than the arguments, since a builtin called by
+	// Starlark reports str() of whatever it raised without normalizing it
+	exc := C.PyObject_CallObject(module.StarlarkInternalError, exc_args)
+	if exc == nil {
+		return
+	}
+	defer C.Py_DecRef(exc)
+
+	C.PyErr_SetObject(module.StarlarkInternalError, exc)
+}
+
+// starlarkTypeOf returns the type of a Starlark object.
+func starlarkTypeOf(self *C.Starlark) *C.PyTypeObject {
+	return (*C.PyTypeObject)(unsafe.Pointer(C.cgoPy_TYPE(self)))
+}
+
+//export StarlarkGo_panic
+func StarlarkGo_panic(module *C.StarlarkModuleState, msg *C.char) (result *C.PyObject) {
+	defer recoverPanic(module.StarlarkType, nil, func() { result = nil })
+
+	// Only used to test that panics are recovered
+	panic(C.GoString(msg))
+}
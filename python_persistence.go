@@ -0,0 +1,277 @@
+package main
+
+/*
+#include "starlark.h"
+*/
+import "C"
+
+import (
+	"unsafe"
+
+	"go.starlark.net/resolve"
+	"go.starlark.net/starlark"
+	"go.starlark.net/syntax"
+)
+
+// execSource is the code run by one call to exec, which is kept so that
+// globals that can't be converted to Python, like functions, can be saved by
+// saving the code that defined them. It is never modified once created.
+type execSource struct {
+	filename string
+	source   string
+	// Resolved completely, so that the code compiles the same way even if the
+	// defaults change
+	options fileOptions
+	names   []string
+	// The global variables the code reads, so that the code that defined
+	// them is kept even once they're gone
+	uses []string
+}
+
+// addExecSource returns sources with source appended, leaving out any that
+// define neither a global variable nor anything that code that is kept uses.
+// Code is replayed in order, so later code can still use what earlier code
+// defined, even if it has been redefined since. sources is shared, so it
+// isn't modified.
+func addExecSource(sources []*execSource, source *execSource, globals starlark.StringDict) []*execSource {
+	all := append(sources[:len(sources):len(sources)], source)
+	keep := make([]bool, len(all))
+	used := map[string]bool{}
+
+	// Code can only use what came before it, so start from the end
+	for i := len(all) - 1; i >= 0; i-- {
+		for _, name := range all[i].names {
+			if _, ok := globals[name]; ok || used[name] {
+				keep[i] = true
+				break
+			}
+		}
+
+		if keep[i] {
+			for _, name := range all[i].uses {
+				used[name] = true
+			}
+		}
+	}
+
+	retval := make([]*execSource, 0, len(all))
+	for i, s := range all {
+		if keep[i] {
+			retval = append(retval, s)
+		}
+	}
+
+	return retval
+}
+
+// predeclaredNames returns the predeclared names that resolved code uses,
+// which are the global variables it was run with.
+func predeclaredNames(node syntax.Node) []string {
+	seen := map[string]bool{}
+	var names []string
+
+	walkSyntax(node, func(n syntax.Node) bool {
+		if ident, ok := n.(*syntax.Ident); ok && !seen[ident.Name] {
+			if binding, ok := ident.Binding.(*resolve.Binding); ok && binding.Scope == resolve.Predeclared {
+				seen[ident.Name] = true
+				names = append(names, ident.Name)
+			}
+		}
+		return true
+	})
+
+	return names
+}
+
+// walkSyntax is syntax.Walk, which panics on while statements.
+func walkSyntax(node syntax.Node, f func(syntax.Node) bool) {
+	var visit func(n syntax.Node) bool
+	visit = func(n syntax.Node) bool {
+		if n, ok := n.(*syntax.WhileStmt); ok {
+			if f(n) {
+				syntax.Walk(n.Cond, visit)
+				for _, stmt := range n.Body {
+					syntax.Walk(stmt, visit)
+				}
+			}
+			return false
+		}
+		return f(n)
+	}
+	syntax.Walk(node, visit)
+}
+
+//export Starlark_persistent_state
+func Starlark_persistent_state(self *C.Starlark, _ *C.PyObject) (retval *C.PyObject) {
+	defer recoverPanic(starlarkTypeOf(self), nil, func() { retval = nil })
+
+	state := stateOf(self)
+	runlock := state.Mutex.rlock()
+	sources := state.Sources
+	coerceArgs := state.CoerceArgs
+	runlock()
+
+	list := C.PyList_New(0)
+	if list == nil {
+		return nil
+	}
+	defer C.Py_DecRef(list)
+
+	for _, source := range sources {
+		item := state.execSourceToPython(source)
+		if item == nil {
+			return nil
+		}
+
+		ok := C.PyList_Append(list, item) == 0
+		C.Py_DecRef(item)
+		if !ok {
+			return nil
+		}
+	}
+
+	pyCoerceArgs := C.Py_False
+	if coerceArgs {
+		pyCoerceArgs = C.Py_True
+	}
+
+	return buildPythonDict(map[string]*C.PyObject{
+		"coerce_args": pyCoerceArgs,
+		"sources":     list,
+	})
+}
+
+func (state *StarlarkState) execSourceToPython(source *execSource) *C.PyObject {
+	cfilename := C.CString(source.filename)
+	defer C.free(unsafe.Pointer(cfilename))
+
+	filename := C.cgoPy_BuildString(cfilename)
+	if filename == nil {
+		return nil
+	}
+	defer C.Py_DecRef(filename)
+
+	csource := C.CString(source.source)
+	defer C.free(unsafe.Pointer(csource))
+
+	code := C.cgoPy_BuildString(csource)
+	if code == nil {
+		return nil
+	}
+	defer C.Py_DecRef(code)
+
+	options := state.fileOptionsToPython(source.options)
+	if options == nil {
+		return nil
+	}
+	defer C.Py_DecRef(options)
+
+	names := C.PyList_New(0)
+	if names == nil {
+		return nil
+	}
+	defer C.Py_DecRef(names)
+
+	for _, name := range source.names {
+		cname := C.CString(name)
+		defer C.free(unsafe.Pointer(cname))
+
+		pyname := C.cgoPy_BuildString(cname)
+		if pyname == nil {
+			return nil
+		}
+
+		ok := C.PyList_Append(names, pyname) == 0
+		C.Py_DecRef(pyname)
+		if !ok {
+			return nil
+		}
+	}
+
+	return buildPythonDict(map[string]*C.PyObject{
+		"filename": filename,
+		"source":   code,
+		"options":  options,
+		"names":    names,
+	})
+}
+
+// buildPythonDict returns a new dict with the given items, whose references
+// aren't stolen.
+func buildPythonDict(items map[string]*C.PyObject) *C.PyObject {
+	dict := C.PyDict_New()
+	if dict == nil {
+		return nil
+	}
+
+	for key, value := range items {
+		ckey := C.CString(key)
+		defer C.free(unsafe.Pointer(ckey))
+
+		if C.PyDict_SetItemString(dict, ckey, value) != 0 {
+			C.Py_DecRef(dict)
+			return nil
+		}
+	}
+
+	return dict
+}
+
+// callPersistence calls a function of starlark_go.persistence with first
+// followed by args and kwargs, either of which may be nil.
+func callPersistence(name string, first *C.PyObject, args *C.PyObject, kwargs *C.PyObject) *C.PyObject {
+	cmodule := C.CString("starlark_go.persistence")
+	defer C.free(unsafe.Pointer(cmodule))
+
+	module := C.PyImport_ImportModule(cmodule)
+	if module == nil {
+		return nil
+	}
+	defer C.Py_DecRef(module)
+
+	fn := getPythonAttr(module, name)
+	if fn == nil {
+		return nil
+	}
+	defer C.Py_DecRef(fn)
+
+	prefix := C.PyTuple_New(1)
+	if prefix == nil {
+		return nil
+	}
+	defer C.Py_DecRef(prefix)
+
+	// This steals a reference
+	C.PyTuple_SetItem(prefix, 0, C.cgoPy_NewRef(first))
+
+	if args != nil {
+		prefix = C.PySequence_Concat(prefix, args)
+		if prefix == nil {
+			return nil
+		}
+		defer C.Py_DecRef(prefix)
+	}
+
+	return C.PyObject_Call(fn, prefix, kwargs)
+}
+
+//export Starlark_dump
+func Starlark_dump(self *C.Starlark, args *C.PyObject, kwargs *C.PyObject) (retval *C.PyObject) {
+	defer recoverPanic(starlarkTypeOf(self), nil, func() { retval = nil })
+
+	return callPersistence("dump", (*C.PyObject)(unsafe.Pointer(self)), args, kwargs)
+}
+
+//export Starlark_load_state
+func Starlark_load_state(cls *C.PyObject, args *C.PyObject, kwargs *C.PyObject) (retval *C.PyObject) {
+	defer recoverPanic((*C.PyTypeObject)(unsafe.Pointer(cls)), nil, func() { retval = nil })
+
+	return callPersistence("load", cls, args, kwargs)
+}
+
+//export Starlark_reduce
+func Starlark_reduce(self *C.Starlark, _ *C.PyObject) (retval *C.PyObject) {
+	defer recoverPanic(starlarkTypeOf(self), nil, func() { retval = nil })
+
+	return callPersistence("reduce", (*C.PyObject)(unsafe.Pointer(self)), nil, nil)
+}
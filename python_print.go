@@ -11,12 +11,11 @@ import (
 )
 
 //export Starlark_get_print
-func Starlark_get_print(self *C.Starlark, closure unsafe.Pointer) *C.PyObject {
-	state := rlockSelf(self)
-	if state == nil {
-		return nil
-	}
-	defer state.Mutex.RUnlock()
+func Starlark_get_print(self *C.Starlark, closure unsafe.Pointer) (retval *C.PyObject) {
+	defer recoverPanic(starlarkTypeOf(self), nil, func() { retval = nil })
+
+	state := stateOf(self)
+	defer state.Mutex.rlock()()
 
 	if state.Print == nil {
 		return C.cgoPy_NewRef(C.Py_None)
@@ -26,7 +25,9 @@ func Starlark_get_print(self *C.Starlark, closure unsafe.Pointer) *C.PyObject {
 }
 
 //export Starlark_set_print
-func Starlark_set_print(self *C.Starlark, value *C.PyObject, closure unsafe.Pointer) C.int {
+func Starlark_set_print(self *C.Starlark, value *C.PyObject, closure unsafe.Pointer) (retval C.int) {
+	defer recoverPanic(starlarkTypeOf(self), nil, func() { retval = -1 })
+
 	if value == C.Py_None {
 		value = nil
 	}
@@ -38,26 +39,43 @@ func Starlark_set_print(self *C.Starlark, value *C.PyObject, closure unsafe.Poin
 			C.PyErr_SetString(C.PyExc_TypeError, errmsg)
 			return -1
 		}
+
+		C.Py_IncRef(value)
 	}
 
 	state := lockSelf(self)
 	if state == nil {
+		if value != nil {
+			C.Py_DecRef(value)
+		}
 		return -1
 	}
-	defer state.Mutex.Unlock()
+	old := state.Print
+	state.Print = value
+	state.Mutex.unlock()
+
+	// Releasing the old value can run arbitrary Python code, which mustn't
+	// happen while holding the Mutex
+	if old != nil {
+		C.Py_DecRef(old)
+	}
 
-	state.Print = C.cgoPy_NewRef(value)
 	return 0
 }
 
+// pythonPrint returns a new reference to the print function to use for a
+// call, which is print if it was given, or else the one set on the instance.
 func pythonPrint(self *C.Starlark, print *C.PyObject) *C.PyObject {
 	if print == nil {
-		state := rlockSelf(self)
-		if state == nil {
-			return nil
-		}
-		defer state.Mutex.RUnlock()
+		state := stateOf(self)
+		runlock := state.Mutex.rlock()
 		print = state.Print
+		if print != nil {
+			C.Py_IncRef(print)
+		}
+		runlock()
+	} else {
+		C.Py_IncRef(print)
 	}
 
 	if print == nil {
@@ -75,6 +93,7 @@ func pythonPrint(self *C.Starlark, print *C.PyObject) *C.PyObject {
 		errmsg := C.CString(fmt.Sprintf("%s is not callable", C.GoString(print.ob_type.tp_name)))
 		defer C.free(unsafe.Pointer(errmsg))
 		C.PyErr_SetString(C.PyExc_TypeError, errmsg)
+		C.Py_DecRef(print)
 		return nil
 	}
 
@@ -89,7 +108,15 @@ func pythonBuiltinPrint() *C.PyObject {
 
 	cstr := C.CString("print")
 	defer C.free(unsafe.Pointer(cstr))
-	return C.PyDict_GetItemString(builtins, cstr)
+
+	// Take a new reference rather than borrowing one, since the builtins can
+	// be changed by another thread without a GIL
+	print := C.PyMapping_GetItemString(builtins, cstr)
+	if print == nil {
+		C.PyErr_Clear()
+	}
+
+	return print
 }
 
 func callPythonPrint(print *C.PyObject, msg string) {
@@ -0,0 +1,139 @@
+package main
+
+/*
+#include "starlark.h"
+*/
+import "C"
+
+import (
+	"runtime"
+	"sync"
+)
+
+// pythonRefs keeps track of the references to Python objects held by
+// Starlark values. counts is nil once every reference has been released.
+// A state's pythonRefs is only replaced when it is cleared or deallocated,
+// when no other thread can be using it.
+type pythonRefs struct {
+	mutex  sync.Mutex
+	counts map[*C.PyObject]int
+	dead   []*C.PyObject
+}
+
+func newPythonRefs() *pythonRefs {
+	return &pythonRefs{counts: map[*C.PyObject]int{}}
+}
+
+// pythonRef is a reference to a Python object that is owned by a Starlark
+// value, such as the function called by a builtin. Whatever owns the
+// pythonRef must keep a pointer to it for as long as it needs the object.
+type pythonRef struct {
+	obj *C.PyObject
+}
+
+// newPythonRef takes a new reference to obj on behalf of a Starlark value.
+// The reference is released once the pythonRef becomes unreachable, which
+// is after nothing in Starlark can use it any more.
+func (state *StarlarkState) newPythonRef(obj *C.PyObject) *pythonRef {
+	C.Py_IncRef(obj)
+
+	refs := state.childRefs
+	refs.mutex.Lock()
+	refs.counts[obj] += 1
+	refs.mutex.Unlock()
+
+	ref := &pythonRef{obj: obj}
+	// The Go garbage collector runs finalizers on a goroutine of its own,
+	// which doesn't hold the GIL, so the reference can't be released here.
+	runtime.SetFinalizer(ref, func(ref *pythonRef) {
+		refs.mutex.Lock()
+		defer refs.mutex.Unlock()
+
+		// Everything has already been released if Starlark was deallocated
+		// or cleared
+		if refs.counts != nil {
+			refs.dead = append(refs.dead, ref.obj)
+		}
+	})
+
+	return ref
+}
+
+// releasePythonRefs releases references whose owners have been garbage
+// collected. The GIL must be held, and the state's Mutex must not be, since
+// releasing a reference can run arbitrary Python code.
+func (state *StarlarkState) releasePythonRefs() {
+	refs := state.childRefs
+	refs.mutex.Lock()
+	dead := refs.dead
+	refs.dead = nil
+
+	for _, obj := range dead {
+		refs.counts[obj] -= 1
+		if refs.counts[obj] == 0 {
+			delete(refs.counts, obj)
+		}
+	}
+	refs.mutex.Unlock()
+
+	for _, obj := range dead {
+		C.Py_DecRef(obj)
+	}
+}
+
+// clone returns a new pythonRefs that holds its own reference to each object
+// held by refs, for a state that shares refs' Starlark values. Those values'
+// pythonRefs only report to refs when they become unreachable, so the clone
+// keeps its references until it is released entirely. The GIL must be held.
+func (refs *pythonRefs) clone() *pythonRefs {
+	refs.mutex.Lock()
+	defer refs.mutex.Unlock()
+
+	clone := newPythonRefs()
+	for obj, count := range refs.counts {
+		clone.counts[obj] = count
+		for i := 0; i < count; i++ {
+			C.Py_IncRef(obj)
+		}
+	}
+
+	return clone
+}
+
+// visitPythonRefs calls visit once for every reference held by Starlark
+// values, stopping if it returns non-zero.
+func (state *StarlarkState) visitPythonRefs(visit func(*C.PyObject) C.int) C.int {
+	refs := state.childRefs
+	refs.mutex.Lock()
+	defer refs.mutex.Unlock()
+
+	for obj, count := range refs.counts {
+		for i := 0; i < count; i++ {
+			if ret := visit(obj); ret != 0 {
+				return ret
+			}
+		}
+	}
+
+	return 0
+}
+
+// releaseAllPythonRefs releases every reference held by Starlark values,
+// when Starlark is deallocated or cleared. Anything that might still use
+// one of those values has to be dropped first.
+func (state *StarlarkState) releaseAllPythonRefs() {
+	refs := state.childRefs
+	state.childRefs = newPythonRefs()
+
+	refs.mutex.Lock()
+	counts := refs.counts
+	refs.counts = nil
+	refs.dead = nil
+	refs.mutex.Unlock()
+
+	for obj, count := range counts {
+		for i := 0; i < count; i++ {
+			C.Py_DecRef(obj)
+		}
+	}
+}
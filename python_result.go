@@ -0,0 +1,112 @@
+package main
+
+/*
+#include "starlark.h"
+*/
+import "C"
+
+import (
+	"sort"
+	"time"
+	"unsafe"
+
+	"go.starlark.net/starlark"
+)
+
+// execResultToPython returns a new starlark_go.ExecResult for a call to exec.
+// pyvalues is nil unless the values were asked for.
+func (state *StarlarkState) execResultToPython(defined []string, replaced []string, elapsed time.Duration, pyvalues *C.PyObject) *C.PyObject {
+	pydefined := stringsToPythonTuple(defined)
+	if pydefined == nil {
+		return nil
+	}
+	defer C.Py_DecRef(pydefined)
+
+	sort.Strings(replaced)
+	pyreplaced := stringsToPythonTuple(replaced)
+	if pyreplaced == nil {
+		return nil
+	}
+	defer C.Py_DecRef(pyreplaced)
+
+	pyelapsed := C.PyFloat_FromDouble(C.double(elapsed.Seconds()))
+	if pyelapsed == nil {
+		return nil
+	}
+	defer C.Py_DecRef(pyelapsed)
+
+	if pyvalues == nil {
+		pyvalues = C.Py_None
+	}
+
+	kwargs := buildPythonDict(map[string]*C.PyObject{
+		"defined":  pydefined,
+		"replaced": pyreplaced,
+		"elapsed":  pyelapsed,
+		"values":   pyvalues,
+	})
+	if kwargs == nil {
+		return nil
+	}
+	defer C.Py_DecRef(kwargs)
+
+	args := C.PyTuple_New(0)
+	if args == nil {
+		return nil
+	}
+	defer C.Py_DecRef(args)
+
+	return C.PyObject_Call(state.module.ExecResultType, args, kwargs)
+}
+
+// execValuesToPython returns a new dict of the values of the given names for
+// ExecResult.values. Values that can't be converted, like functions, are
+// given as their Starlark representation.
+func (state *StarlarkState) execValuesToPython(names []string, values starlark.StringDict) *C.PyObject {
+	pyvalues := C.PyDict_New()
+	if pyvalues == nil {
+		return nil
+	}
+
+	for _, name := range names {
+		value := state.starlarkValueOrReprToPython(values[name])
+		if value == nil {
+			C.Py_DecRef(pyvalues)
+			return nil
+		}
+
+		cname := C.CString(name)
+		ok := C.PyDict_SetItemString(pyvalues, cname, value) == 0
+		C.free(unsafe.Pointer(cname))
+		C.Py_DecRef(value)
+		if !ok {
+			C.Py_DecRef(pyvalues)
+			return nil
+		}
+	}
+
+	return pyvalues
+}
+
+// stringsToPythonTuple returns a new tuple of str.
+func stringsToPythonTuple(strs []string) *C.PyObject {
+	tuple := C.PyTuple_New(C.Py_ssize_t(len(strs)))
+	if tuple == nil {
+		return nil
+	}
+
+	for i, s := range strs {
+		cstr := C.CString(s)
+		pystr := C.cgoPy_BuildString(cstr)
+		C.free(unsafe.Pointer(cstr))
+		if pystr == nil {
+			C.Py_DecRef(tuple)
+			return nil
+		}
+
+		// This steals the reference
+		C.PyTuple_SetItem(tuple, C.Py_ssize_t(i), pystr)
+	}
+
+	return tuple
+}
@@ -0,0 +1,168 @@
+package main
+
+/*
+#include "starlark.h"
+*/
+import "C"
+
+import (
+	"math"
+	"runtime"
+	"runtime/debug"
+	"runtime/metrics"
+	"time"
+	"unsafe"
+)
+
+// The Go runtime is shared by every Starlark object in the process, including
+// those in other Python subinterpreters, so none of these functions look at
+// the module state except to raise exceptions.
+
+//export StarlarkGo_runtimeStats
+func StarlarkGo_runtimeStats(module *C.StarlarkModuleState) (retval *C.PyObject) {
+	defer recoverPanic(module.StarlarkType, nil, func() { retval = nil })
+
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+
+	stats := []struct {
+		name  string
+		value *C.PyObject
+	}{
+		{"goroutines", C.PyLong_FromLongLong(C.longlong(runtime.NumGoroutine()))},
+		{"gomaxprocs", C.PyLong_FromLongLong(C.longlong(runtime.GOMAXPROCS(0)))},
+		{"gc_percent", gcPercent()},
+		{"memory_limit", memoryLimitToPython(debug.SetMemoryLimit(-1))},
+		{"num_gc", C.PyLong_FromUnsignedLongLong(C.ulonglong(mem.NumGC))},
+		{"num_forced_gc", C.PyLong_FromUnsignedLongLong(C.ulonglong(mem.NumForcedGC))},
+		{"gc_pause_total", C.PyFloat_FromDouble(C.double(time.Duration(mem.PauseTotalNs).Seconds()))},
+		{"heap_alloc", C.PyLong_FromUnsignedLongLong(C.ulonglong(mem.HeapAlloc))},
+		{"heap_sys", C.PyLong_FromUnsignedLongLong(C.ulonglong(mem.HeapSys))},
+		{"heap_objects", C.PyLong_FromUnsignedLongLong(C.ulonglong(mem.HeapObjects))},
+		{"next_gc", C.PyLong_FromUnsignedLongLong(C.ulonglong(mem.NextGC))},
+		{"total_alloc", C.PyLong_FromUnsignedLongLong(C.ulonglong(mem.TotalAlloc))},
+		{"sys", C.PyLong_FromUnsignedLongLong(C.ulonglong(mem.Sys))},
+	}
+
+	failed := false
+	for _, stat := range stats {
+		if stat.value == nil {
+			failed = true
+		} else {
+			defer C.Py_DecRef(stat.value)
+		}
+	}
+	if failed {
+		return nil
+	}
+
+	dict := C.PyDict_New()
+	if dict == nil {
+		return nil
+	}
+
+	for _, stat := range stats {
+		cname := C.CString(stat.name)
+		defer C.free(unsafe.Pointer(cname))
+
+		// This does not steal references
+		if C.PyDict_SetItemString(dict, cname, stat.value) != 0 {
+			C.Py_DecRef(dict)
+			return nil
+		}
+	}
+
+	return dict
+}
+
+// gcPercent returns the current GOGC value, or None if the garbage collector
+// is off. Go has no way to ask for it other than runtime/metrics.
+func gcPercent() *C.PyObject {
+	sample := []metrics.Sample{{Name: "/gc/gogc:percent"}}
+	metrics.Read(sample)
+
+	if sample[0].Value.Kind() != metrics.KindUint64 {
+		return C.cgoPy_NewRef(C.Py_None)
+	}
+
+	percent := sample[0].Value.Uint64()
+	if percent == math.MaxUint64 {
+		return C.cgoPy_NewRef(C.Py_None)
+	}
+
+	return C.PyLong_FromUnsignedLongLong(C.ulonglong(percent))
+}
+
+// memoryLimitToPython returns a memory limit, or None if there isn't one.
+func memoryLimitToPython(limit int64) *C.PyObject {
+	if limit == math.MaxInt64 {
+		return C.cgoPy_NewRef(C.Py_None)
+	}
+
+	return C.PyLong_FromLongLong(C.longlong(limit))
+}
+
+//export StarlarkGo_setGOMAXPROCS
+func StarlarkGo_setGOMAXPROCS(module *C.StarlarkModuleState, n C.Py_ssize_t) (retval *C.PyObject) {
+	defer recoverPanic(module.StarlarkType, nil, func() { retval = nil })
+
+	if n < 1 {
+		raiseValueError("n must be at least 1")
+		return nil
+	}
+
+	return C.PyLong_FromLongLong(C.longlong(runtime.GOMAXPROCS(int(n))))
+}
+
+//export StarlarkGo_setGCPercent
+func StarlarkGo_setGCPercent(module *C.StarlarkModuleState, percent *C.PyObject) (retval *C.PyObject) {
+	defer recoverPanic(module.StarlarkType, nil, func() { retval = nil })
+
+	// Go turns the garbage collector off for any negative value
+	goPercent := -1
+	if percent != C.Py_None {
+		value := C.PyLong_AsSsize_t(percent)
+		if value == -1 && C.PyErr_Occurred() != nil {
+			return nil
+		}
+		if value < 0 {
+			raiseValueError("percent must be None or at least 0")
+			return nil
+		}
+		if value > math.MaxInt32 {
+			value = math.MaxInt32
+		}
+		goPercent = int(value)
+	}
+
+	previous := debug.SetGCPercent(goPercent)
+	if previous < 0 {
+		return C.cgoPy_NewRef(C.Py_None)
+	}
+
+	return C.PyLong_FromLongLong(C.longlong(previous))
+}
+
+//export StarlarkGo_setMemoryLimit
+func StarlarkGo_setMemoryLimit(module *C.StarlarkModuleState, limit *C.PyObject) (retval *C.PyObject) {
+	defer recoverPanic(module.StarlarkType, nil, func() { retval = nil })
+
+	var goLimit int64 = math.MaxInt64
+	if limit != C.Py_None {
+		overflow := C.int(0)
+		value := C.PyLong_AsLongLongAndOverflow(limit, &overflow)
+		if value == -1 && C.PyErr_Occurred() != nil {
+			return nil
+		}
+		if overflow < 0 || (overflow == 0 && value < 0) {
+			raiseValueError("limit must be None or at least 0")
+			return nil
+		}
+		// Anything too large for Go means there's no limit
+		if overflow == 0 {
+			goLimit = int64(value)
+		}
+	}
+
+	return memoryLimitToPython(debug.SetMemoryLimit(goLimit))
+}
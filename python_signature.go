@@ -0,0 +1,331 @@
+package main
+
+/*
+#include "starlark.h"
+*/
+import "C"
+
+import (
+	"fmt"
+	"strings"
+	"unsafe"
+
+	"go.starlark.net/starlark"
+)
+
+// These match the values of inspect.Parameter.kind
+const (
+	paramPositionalOnly = iota
+	paramPositionalOrKeyword
+	paramVarPositional
+	paramKeywordOnly
+	paramVarKeyword
+)
+
+type pythonParam struct {
+	name       string
+	kind       int
+	hasDefault bool
+	annotation *pythonRef
+}
+
+type pythonSignature struct {
+	params []pythonParam
+}
+
+// argumentError is returned when Starlark calls a Python function with
+// arguments that don't match its signature. Unlike other errors from Python
+// functions, it is reported at the Starlark call site.
+type argumentError struct {
+	msg string
+}
+
+func (e *argumentError) Error() string {
+	return e.msg
+}
+
+func newArgumentError(format string, args ...interface{}) error {
+	return &argumentError{fmt.Sprintf(format, args...)}
+}
+
+func (state *StarlarkState) getPythonSignature(obj *C.PyObject) *C.PyObject {
+	args := C.PyTuple_New(1)
+	if args == nil {
+		return nil
+	}
+	defer C.Py_DecRef(args)
+	C.PyTuple_SetItem(args, 0, C.cgoPy_NewRef(obj))
+
+	// Try to resolve string annotations first, but settle for leaving them as
+	// strings if they can't be resolved
+	kwargs := C.PyDict_New()
+	if kwargs == nil {
+		return nil
+	}
+	defer C.Py_DecRef(kwargs)
+
+	cname := C.CString("eval_str")
+	defer C.free(unsafe.Pointer(cname))
+	C.PyDict_SetItemString(kwargs, cname, C.Py_True)
+
+	sig := C.PyObject_Call(state.module.InspectSignature, args, kwargs)
+	if sig == nil {
+		C.PyErr_Clear()
+		sig = C.PyObject_CallObject(state.module.InspectSignature, args)
+	}
+
+	return sig
+}
+
+// pythonCallableSignature returns the signature of a Python callable, or nil
+// if Python can't tell us what it is (which is the case for some builtins.)
+func (state *StarlarkState) pythonCallableSignature(obj *C.PyObject) *pythonSignature {
+	sig := state.getPythonSignature(obj)
+	if sig == nil {
+		C.PyErr_Clear()
+		return nil
+	}
+	defer C.Py_DecRef(sig)
+
+	parameters := getPythonAttr(sig, "parameters")
+	if parameters == nil {
+		C.PyErr_Clear()
+		return nil
+	}
+	defer C.Py_DecRef(parameters)
+
+	values := C.PyMapping_Values(parameters)
+	if values == nil {
+		C.PyErr_Clear()
+		return nil
+	}
+	defer C.Py_DecRef(values)
+
+	result := &pythonSignature{}
+	for i := C.Py_ssize_t(0); i < C.PyList_Size(values); i++ {
+		param, ok := state.pythonParam(C.PyList_GetItem(values, i))
+		if !ok {
+			C.PyErr_Clear()
+			return nil
+		}
+		result.params = append(result.params, param)
+	}
+
+	return result
+}
+
+func (state *StarlarkState) pythonParam(obj *C.PyObject) (pythonParam, bool) {
+	param := pythonParam{}
+
+	pyname := getPythonAttr(obj, "name")
+	if pyname == nil {
+		return param, false
+	}
+	defer C.Py_DecRef(pyname)
+
+	name, err := pythonToStarlarkString(pyname)
+	if err != nil {
+		return param, false
+	}
+	param.name = string(name)
+
+	pykind := getPythonAttr(obj, "kind")
+	if pykind == nil {
+		return param, false
+	}
+	defer C.Py_DecRef(pykind)
+	param.kind = int(C.PyLong_AsLong(pykind))
+
+	pydefault := getPythonAttr(obj, "default")
+	if pydefault == nil {
+		return param, false
+	}
+	defer C.Py_DecRef(pydefault)
+	param.hasDefault = pydefault != state.module.InspectEmpty
+
+	// Annotations that are still strings couldn't be resolved, so we can't
+	// coerce to them
+	annotation := getPythonAttr(obj, "annotation")
+	if annotation == nil {
+		return param, false
+	}
+	defer C.Py_DecRef(annotation)
+
+	if annotation != state.module.InspectEmpty && C.cgoPyUnicode_Check(annotation) != 1 {
+		param.annotation = state.newPythonRef(annotation)
+	}
+
+	return param, true
+}
+
+// bind checks that args and kwargs would be accepted by the signature, using
+// the same messages as starlark.UnpackArgs, and returns the parameter that
+// each argument will be bound to.
+func (sig *pythonSignature) bind(fnname string, args starlark.Tuple, kwargs []starlark.Tuple) ([]*pythonParam, []*pythonParam, error) {
+	var (
+		positional []*pythonParam
+		varargs    *pythonParam
+		varkwargs  *pythonParam
+	)
+
+	byName := map[string]*pythonParam{}
+	for i := range sig.params {
+		param := &sig.params[i]
+		switch param.kind {
+		case paramPositionalOnly:
+			positional = append(positional, param)
+		case paramPositionalOrKeyword:
+			positional = append(positional, param)
+			byName[param.name] = param
+		case paramVarPositional:
+			varargs = param
+		case paramKeywordOnly:
+			byName[param.name] = param
+		case paramVarKeyword:
+			varkwargs = param
+		}
+	}
+
+	if len(args) > len(positional) && varargs == nil {
+		return nil, nil, newArgumentError("%s: got %d arguments, want at most %d", fnname, len(args), len(positional))
+	}
+
+	bound := map[*pythonParam]bool{}
+	argParams := make([]*pythonParam, len(args))
+	for i := range args {
+		if i < len(positional) {
+			argParams[i] = positional[i]
+			bound[positional[i]] = true
+		} else {
+			argParams[i] = varargs
+		}
+	}
+
+	kwargParams := make([]*pythonParam, len(kwargs))
+	for i, kwarg := range kwargs {
+		name := string(kwarg[0].(starlark.String))
+
+		param, ok := byName[name]
+		if !ok {
+			if varkwargs == nil {
+				return nil, nil, newArgumentError("%s: unexpected keyword argument %s", fnname, name)
+			}
+			kwargParams[i] = varkwargs
+			continue
+		}
+
+		if bound[param] {
+			return nil, nil, newArgumentError("%s: got multiple values for keyword argument %s", fnname, name)
+		}
+
+		kwargParams[i] = param
+		bound[param] = true
+	}
+
+	for i := range sig.params {
+		param := &sig.params[i]
+		if param.kind == paramVarPositional || param.kind == paramVarKeyword {
+			continue
+		}
+
+		if !param.hasDefault && !bound[param] {
+			return nil, nil, newArgumentError("%s: missing argument for %s", fnname, param.name)
+		}
+	}
+
+	return argParams, kwargParams, nil
+}
+
+func (state *StarlarkState) starlarkArgumentToPython(fnname string, param *pythonParam, coerceArgs bool, x starlark.Value) (*C.PyObject, error) {
+	if param == nil || param.annotation == nil || !coerceArgs {
+		return state.innerStarlarkValueToPython(x)
+	}
+
+	c, err := newTypedConverter(state)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+
+	value, err := c.convert(x, param.annotation.obj, "$")
+	if err != nil {
+		if value != nil {
+			C.Py_DecRef(value)
+		}
+		return nil, err
+	}
+
+	if len(c.mismatches) > 0 {
+		if value != nil {
+			C.Py_DecRef(value)
+		}
+
+		msgs := make([]string, len(c.mismatches))
+		for i, m := range c.mismatches {
+			if m.path == "$" {
+				msgs[i] = m.msg
+			} else {
+				msgs[i] = fmt.Sprintf("%s: %s", m.path, m.msg)
+			}
+		}
+
+		return nil, newArgumentError("%s: for parameter %s: %s", fnname, param.name, strings.Join(msgs, "; "))
+	}
+
+	return value, nil
+}
+
+// starlarkCallArgsToPython checks and converts the arguments of a call from
+// Starlark to a Python callable with the given signature, which may be nil.
+func (state *StarlarkState) starlarkCallArgsToPython(fnname string, sig *pythonSignature, args starlark.Tuple, kwargs []starlark.Tuple) (*C.PyObject, *C.PyObject, error) {
+	argParams := make([]*pythonParam, len(args))
+	kwargParams := make([]*pythonParam, len(kwargs))
+
+	// Read once, since without a GIL it could be changed meanwhile
+	runlock := state.Mutex.rlock()
+	coerceArgs := state.CoerceArgs
+	runlock()
+
+	if sig != nil {
+		var err error
+		argParams, kwargParams, err = sig.bind(fnname, args, kwargs)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	cargs := C.PyTuple_New(C.Py_ssize_t(len(args)))
+	if cargs == nil {
+		return nil, nil, fmt.Errorf("Could not initialize argument list")
+	}
+
+	for i, arg := range args {
+		value, err := state.starlarkArgumentToPython(fnname, argParams[i], coerceArgs, arg)
+		if err != nil {
+			C.Py_DecRef(cargs)
+			return nil, nil, err
+		}
+
+		// This "steals" the ref to value so we don't need to DecRef after
+		C.PyTuple_SetItem(cargs, C.Py_ssize_t(i), value)
+	}
+
+	ckwargs := C.PyDict_New()
+	for i, kwarg := range kwargs {
+		value, err := state.starlarkArgumentToPython(fnname, kwargParams[i], coerceArgs, kwarg[1])
+		if err != nil {
+			C.Py_DecRef(cargs)
+			C.Py_DecRef(ckwargs)
+			return nil, nil, err
+		}
+
+		cname := C.CString(string(kwarg[0].(starlark.String)))
+		defer C.free(unsafe.Pointer(cname))
+
+		// This does not steal references
+		C.PyDict_SetItemString(ckwargs, cname, value)
+		C.Py_DecRef(value)
+	}
+
+	return cargs, ckwargs, nil
+}
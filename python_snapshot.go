@@ -0,0 +1,116 @@
+package main
+
+/*
+#include "starlark.h"
+*/
+import "C"
+
+import (
+	"runtime/cgo"
+	"unsafe"
+
+	"go.starlark.net/starlark"
+)
+
+// starlarkSnapshot is what a StarlarkSnapshot's handle refers to. The globals
+// are a copy, since the state changes its own in place, but the values in it
+// are shared, which is safe because every one of them is frozen.
+type starlarkSnapshot struct {
+	globals starlark.StringDict
+	sources []*execSource
+	// The references held by the values in globals belong to the state's
+	// pythonRefs. If the state is cleared they are released, and the snapshot
+	// can't be restored any more.
+	refs *pythonRefs
+}
+
+//export Starlark_snapshot
+func Starlark_snapshot(self *C.Starlark, _ *C.PyObject) (retval *C.PyObject) {
+	defer recoverPanic(starlarkTypeOf(self), nil, func() { retval = nil })
+
+	state := stateOf(self)
+
+	snapshot := C.starlarkSnapshotAlloc(state.module.StarlarkSnapshotType)
+	if snapshot == nil {
+		return nil
+	}
+
+	runlock := state.Mutex.rlock()
+	snap := &starlarkSnapshot{
+		globals: state.someGlobals(nil),
+		sources: state.Sources,
+		refs:    state.childRefs,
+	}
+	runlock()
+
+	snapshot.handle = C.uintptr_t(cgo.NewHandle(snap))
+	snapshot.owner = C.cgoPy_NewRef((*C.PyObject)(unsafe.Pointer(self)))
+
+	return (*C.PyObject)(unsafe.Pointer(snapshot))
+}
+
+//export Starlark_restore
+func Starlark_restore(self *C.Starlark, obj *C.PyObject) (retval *C.PyObject) {
+	defer recoverPanic(starlarkTypeOf(self), nil, func() { retval = nil })
+
+	state := stateOf(self)
+	state.releasePythonRefs()
+
+	snapshot := C.asStarlarkSnapshot(state.module, obj)
+	if snapshot == nil {
+		return nil
+	}
+
+	if snapshot.owner != (*C.PyObject)(unsafe.Pointer(self)) {
+		raiseValueError("snapshot was taken from a different Starlark object")
+		return nil
+	}
+
+	snap := cgo.Handle(snapshot.handle).Value().(*starlarkSnapshot)
+
+	cleared := false
+	ok := state.updateGlobals(nil, func(globals starlark.StringDict) {
+		if snap.refs != state.childRefs {
+			cleared = true
+			return
+		}
+
+		// Functions defined before the snapshot was taken use these globals,
+		// so they're changed in place
+		for name := range globals {
+			if _, ok := snap.globals[name]; !ok {
+				delete(globals, name)
+			}
+		}
+		for name, value := range snap.globals {
+			globals[name] = value
+		}
+		// The Mutex is held while updating, so the sources can be replaced too
+		state.Sources = snap.sources
+	})
+	if !ok {
+		return nil
+	}
+
+	if cleared {
+		raiseValueError("snapshot was taken before the Starlark object was cleared")
+		return nil
+	}
+
+	return C.cgoPy_NewRef(C.Py_None)
+}
+
+//export StarlarkSnapshot_release
+func StarlarkSnapshot_release(self *C.StarlarkSnapshot) {
+	// Called while deallocating, which can't handle exceptions
+	defer recoverPanic(self.ob_base.ob_type, nil, func() {
+		C.PyErr_WriteUnraisable(nil)
+	})
+
+	if self.handle == 0 {
+		return
+	}
+
+	cgo.Handle(self.handle).Delete()
+	self.handle = 0
+}
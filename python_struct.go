@@ -0,0 +1,76 @@
+package main
+
+/*
+#include "starlark.h"
+*/
+import "C"
+
+import (
+	"fmt"
+	"unsafe"
+)
+
+//export Starlark_get_struct_factory
+func Starlark_get_struct_factory(self *C.Starlark, closure unsafe.Pointer) (retval *C.PyObject) {
+	defer recoverPanic(starlarkTypeOf(self), nil, func() { retval = nil })
+
+	state := stateOf(self)
+	defer state.Mutex.rlock()()
+
+	if state.StructFactory == nil {
+		return C.cgoPy_NewRef(C.Py_None)
+	}
+
+	return C.cgoPy_NewRef(state.StructFactory)
+}
+
+//export Starlark_set_struct_factory
+func Starlark_set_struct_factory(self *C.Starlark, value *C.PyObject, closure unsafe.Pointer) (retval C.int) {
+	defer recoverPanic(starlarkTypeOf(self), nil, func() { retval = -1 })
+
+	if value == C.Py_None {
+		value = nil
+	}
+
+	if value != nil {
+		if C.PyCallable_Check(value) != 1 {
+			errmsg := C.CString(fmt.Sprintf("%s is not callable", C.GoString(value.ob_type.tp_name)))
+			defer C.free(unsafe.Pointer(errmsg))
+			C.PyErr_SetString(C.PyExc_TypeError, errmsg)
+			return -1
+		}
+
+		C.Py_IncRef(value)
+	}
+
+	state := lockSelf(self)
+	if state == nil {
+		if value != nil {
+			C.Py_DecRef(value)
+		}
+		return -1
+	}
+	old := state.StructFactory
+	state.StructFactory = value
+	state.Mutex.unlock()
+
+	// Releasing the old value can run arbitrary Python code, which mustn't
+	// happen while holding the Mutex
+	if old != nil {
+		C.Py_DecRef(old)
+	}
+
+	return 0
+}
+
+// structFactory returns a new reference to the struct factory, or nil if
+// there isn't one.
+func (state *StarlarkState) structFactory() *C.PyObject {
+	defer state.Mutex.rlock()()
+
+	if state.StructFactory == nil {
+		return nil
+	}
+
+	return C.cgoPy_NewRef(state.StructFactory)
+}
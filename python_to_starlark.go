@@ -2,8 +2,6 @@ package main
 
 /*
 #include "starlark.h"
-
-extern PyObject *ConversionToStarlarkFailed;
 */
 import "C"
 
@@ -13,6 +11,7 @@ import (
 	"unsafe"
 
 	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
 )
 
 func (state *StarlarkState) pythonToStarlarkTuple(obj *C.PyObject) (starlark.Tuple, error) {
@@ -152,7 +151,7 @@ func (state *StarlarkState) pythonToStarlarkSet(obj *C.PyObject) (*starlark.Set,
 
 		err = set.Insert(value)
 		if err != nil {
-			raisePythonException(err)
+			state.raisePythonException(err)
 			return &starlark.Set{}, fmt.Errorf("While inserting %v into Starlark set: %v", value, err)
 		}
 	}
@@ -164,6 +163,127 @@ func (state *StarlarkState) pythonToStarlarkSet(obj *C.PyObject) (*starlark.Set,
 	return set, nil
 }
 
+func pythonFieldNames(fields *C.PyObject, nameAttr string) ([]string, error) {
+	var names []string
+	pyiter := C.PyObject_GetIter(fields)
+	if pyiter == nil {
+		return nil, fmt.Errorf("Couldn't get iterator for Python fields")
+	}
+	defer C.Py_DecRef(pyiter)
+
+	for pyfield := C.PyIter_Next(pyiter); pyfield != nil; pyfield = C.PyIter_Next(pyiter) {
+		defer C.Py_DecRef(pyfield)
+
+		pyname := pyfield
+		if nameAttr != "" {
+			cattr := C.CString(nameAttr)
+			defer C.free(unsafe.Pointer(cattr))
+
+			pyname = C.PyObject_GetAttrString(pyfield, cattr)
+			if pyname == nil {
+				return nil, fmt.Errorf("Couldn't get name of Python field")
+			}
+			defer C.Py_DecRef(pyname)
+		}
+
+		name, err := pythonToStarlarkString(pyname)
+		if err != nil {
+			return nil, err
+		}
+
+		names = append(names, name.GoString())
+	}
+
+	if C.PyErr_Occurred() != nil {
+		return nil, fmt.Errorf("Python exception while iterating through Python fields")
+	}
+
+	return names, nil
+}
+
+func (state *StarlarkState) pythonToStarlarkStruct(obj *C.PyObject, names []string) (*starlarkstruct.Struct, error) {
+	typeName := C.GoString(obj.ob_type.tp_name)
+	members := make(starlark.StringDict, len(names))
+
+	for _, name := range names {
+		cname := C.CString(name)
+		defer C.free(unsafe.Pointer(cname))
+
+		pyvalue := C.PyObject_GetAttrString(obj, cname)
+		if pyvalue == nil {
+			return &starlarkstruct.Struct{}, fmt.Errorf("Couldn't get value of field %v in Python %s", name, typeName)
+		}
+		defer C.Py_DecRef(pyvalue)
+
+		value, err := state.innerPythonToStarlarkValue(pyvalue)
+		if err != nil {
+			return &starlarkstruct.Struct{}, fmt.Errorf("While converting value of field %v in Python %s: %v", name, typeName, err)
+		}
+
+		members[name] = value
+	}
+
+	return starlarkstruct.FromStringDict(starlarkstruct.Default, members), nil
+}
+
+func (state *StarlarkState) pythonNamedTupleToStarlark(obj *C.PyObject) (*starlarkstruct.Struct, error) {
+	cattr := C.CString("_fields")
+	defer C.free(unsafe.Pointer(cattr))
+
+	fields := C.PyObject_GetAttrString(obj, cattr)
+	if fields == nil {
+		return &starlarkstruct.Struct{}, fmt.Errorf("Couldn't get fields of Python named tuple")
+	}
+	defer C.Py_DecRef(fields)
+
+	names, err := pythonFieldNames(fields, "")
+	if err != nil {
+		return &starlarkstruct.Struct{}, err
+	}
+
+	return state.pythonToStarlarkStruct(obj, names)
+}
+
+func (state *StarlarkState) pythonDataclassToStarlark(obj *C.PyObject) (*starlarkstruct.Struct, error) {
+	args := C.PyTuple_New(1)
+	if args == nil {
+		return &starlarkstruct.Struct{}, fmt.Errorf("Could not initialize argument list")
+	}
+	defer C.Py_DecRef(args)
+
+	C.PyTuple_SetItem(args, 0, C.cgoPy_NewRef(obj))
+	fields := C.PyObject_CallObject(state.module.DataclassFields, args)
+	if fields == nil {
+		return &starlarkstruct.Struct{}, fmt.Errorf("Couldn't get fields of Python dataclass")
+	}
+	defer C.Py_DecRef(fields)
+
+	names, err := pythonFieldNames(fields, "name")
+	if err != nil {
+		return &starlarkstruct.Struct{}, err
+	}
+
+	return state.pythonToStarlarkStruct(obj, names)
+}
+
+func (state *StarlarkState) pythonNamespaceToStarlark(obj *C.PyObject) (*starlarkstruct.Struct, error) {
+	cattr := C.CString("__dict__")
+	defer C.free(unsafe.Pointer(cattr))
+
+	attrs := C.PyObject_GetAttrString(obj, cattr)
+	if attrs == nil {
+		return &starlarkstruct.Struct{}, fmt.Errorf("Couldn't get attributes of Python SimpleNamespace")
+	}
+	defer C.Py_DecRef(attrs)
+
+	names, err := pythonFieldNames(attrs, "")
+	if err != nil {
+		return &starlarkstruct.Struct{}, err
+	}
+
+	return state.pythonToStarlarkStruct(obj, names)
+}
+
 func pythonToStarlarkString(obj *C.PyObject) (starlark.String, error) {
 	var size C.Py_ssize_t
 	cstr := C.PyUnicode_AsUTF8AndSize(obj, &size)
@@ -212,9 +332,27 @@ func pythonToStarlarkFloat(obj *C.PyObject) (starlark.Float, error) {
 }
 
 func getFuncName(obj *C.PyObject) (string, error) {
-	nameAttr := C.CString("__name__")
+	nameAttr := C.CString("__qualname__")
 	defer C.free(unsafe.Pointer(nameAttr))
-	funcName, err := pythonToStarlarkString(C.PyObject_GetAttrString(obj, nameAttr))
+
+	// Functions, methods, and classes have a __qualname__; other callables,
+	// like functools.partial objects or instances with __call__, use their repr
+	pyname := C.PyObject_GetAttrString(obj, nameAttr)
+	if pyname == nil || C.cgoPyUnicode_Check(pyname) != 1 {
+		if pyname == nil {
+			C.PyErr_Clear()
+		} else {
+			C.Py_DecRef(pyname)
+		}
+
+		pyname = C.PyObject_Repr(obj)
+		if pyname == nil {
+			return "", fmt.Errorf("Couldn't get name of Python callable")
+		}
+	}
+	defer C.Py_DecRef(pyname)
+
+	funcName, err := pythonToStarlarkString(pyname)
 	if err != nil {
 		return "", err
 	}
@@ -241,96 +379,51 @@ func getPyError() error {
 	return fmt.Errorf(errStr.GoString())
 }
 
-func (state *StarlarkState) pythonToStarlarkFunc(obj *C.PyObject) (starlark.Value, error) {
+func (state *StarlarkState) pythonToStarlarkCallable(obj *C.PyObject) (starlark.Value, error) {
 	funcName, err := getFuncName(obj)
 	if err != nil {
 		return starlark.None, err
 	}
 
-	C.Py_IncRef(obj)
-	state.childRefs = append(state.childRefs, obj)
+	// The builtin owns ref, and with it, the reference to obj
+	ref := state.newPythonRef(obj)
+	sig := state.pythonCallableSignature(obj)
 
 	return starlark.NewBuiltin(funcName, func(
-		_ *starlark.Thread,
-		_ *starlark.Builtin,
+		thread *starlark.Thread,
+		b *starlark.Builtin,
 		args starlark.Tuple,
 		kwargs []starlark.Tuple,
 	) (starlark.Value, error) {
-		state.ReattachGIL()
-		defer state.DetachGIL()
-
-		cargs, err := starlarkTupleToPython(args)
-		if err != nil {
-			return starlark.None, err
-		}
-		defer C.Py_DecRef(cargs)
-
-		ckwargs, err := starlarkDictItemsToPython(kwargs)
-		if err != nil {
-			return starlark.None, err
-		}
-		defer C.Py_DecRef(ckwargs)
-
-		res := C.PyObject_Call(obj, cargs, ckwargs)
-		if C.PyErr_Occurred() != nil {
-			return starlark.None, getPyError()
+		// The builtin may have been copied to a fork or a module since, whose
+		// settings the call has to use, and which has to own the references
+		// to anything the callable returns
+		caller := stateOfThread(thread)
+		if caller == nil {
+			return starlark.None, fmt.Errorf("%s: can't be called outside of Starlark.eval or Starlark.exec", b.Name())
 		}
 
-		defer C.Py_DecRef(res)
-		return state.innerPythonToStarlarkValue(res)
-	}), nil
-}
-
-func (state *StarlarkState) pythonToStarlarkMethod(obj *C.PyObject) (starlark.Value, error) {
-	self := C.PyMethod_Self(obj)
-	f := C.PyMethod_Function(obj)
-
-	funcName, err := getFuncName(f)
-	if err != nil {
-		return starlark.None, err
-	}
-
-	C.Py_IncRef(obj)
-	state.childRefs = append(state.childRefs, obj)
-
-	return starlark.NewBuiltin(funcName, func(
-		_ *starlark.Thread,
-		_ *starlark.Builtin,
-		args starlark.Tuple,
-		kwargs []starlark.Tuple,
-	) (starlark.Value, error) {
-		state.ReattachGIL()
-		defer state.DetachGIL()
+		pt := pythonThreadOf(thread)
+		pt.ReattachGIL()
+		defer pt.DetachGIL()
 
-		// create args list with self at the front
-		cargsList, err := starlarkTupleToPythonList(args)
+		cargs, ckwargs, err := caller.starlarkCallArgsToPython(b.Name(), sig, args, kwargs)
 		if err != nil {
+			if C.PyErr_Occurred() != nil {
+				C.PyErr_Clear()
+			}
 			return starlark.None, err
 		}
-		cargs := C.PyTuple_New(C.Py_ssize_t(len(cargsList) + 1))
-		if cargs == nil {
-			return starlark.None, fmt.Errorf("Could not initialize argument list")
-		}
-		C.Py_IncRef(self)
-		C.PyTuple_SetItem(cargs, 0, self)
-		for i, arg := range cargsList {
-			C.PyTuple_SetItem(cargs, C.Py_ssize_t(i + 1), arg)
-		}
 		defer C.Py_DecRef(cargs)
-
-		ckwargs, err := starlarkDictItemsToPython(kwargs)
-		if err != nil {
-			return starlark.None, err
-		}
 		defer C.Py_DecRef(ckwargs)
 
-		res := C.PyObject_Call(f, cargs, ckwargs)
+		res := C.PyObject_Call(ref.obj, cargs, ckwargs)
 		if C.PyErr_Occurred() != nil {
 			return starlark.None, getPyError()
 		}
 
 		defer C.Py_DecRef(res)
-		return state.innerPythonToStarlarkValue(res)
+		return caller.innerPythonToStarlarkValue(res)
 	}), nil
 }
 
@@ -359,16 +452,20 @@ func (state *StarlarkState) innerPythonToStarlarkValue(obj *C.PyObject) (starlar
 		value, err = state.pythonToStarlarkDict(obj)
 	case C.cgoPyList_Check(obj) == 1:
 		value, err = state.pythonToStarlarkList(obj)
+	case C.cgoPyNamedTuple_Check(obj) == 1:
+		value, err = state.pythonNamedTupleToStarlark(obj)
 	case C.cgoPyTuple_Check(obj) == 1:
 		value, err = state.pythonToStarlarkTuple(obj)
+	case C.cgoPyDataclass_Check(obj) == 1:
+		value, err = state.pythonDataclassToStarlark(obj)
+	case C.PyObject_IsInstance(obj, state.module.SimpleNamespaceType) == 1:
+		value, err = state.pythonNamespaceToStarlark(obj)
 	case C.PySequence_Check(obj) == 1:
 		value, err = state.pythonToStarlarkList(obj)
 	case C.PyMapping_Check(obj) == 1:
 		value, err = state.pythonToStarlarkDict(obj)
-	case C.cgoPyFunc_Check(obj) == 1:
-		value, err = state.pythonToStarlarkFunc(obj)
-	case C.cgoPyMethod_Check(obj) == 1:
-		value, err = state.pythonToStarlarkMethod(obj)
+	case C.PyCallable_Check(obj) == 1:
+		value, err = state.pythonToStarlarkCallable(obj)
 	default:
 		err = fmt.Errorf("Don't know how to convert Python %s to Starlark", C.GoString(obj.ob_type.tp_name))
 	}
@@ -385,7 +482,7 @@ func (state *StarlarkState) innerPythonToStarlarkValue(obj *C.PyObject) (starlar
 func (state *StarlarkState) pythonToStarlarkValue(obj *C.PyObject) (starlark.Value, error) {
 	value, err := state.innerPythonToStarlarkValue(obj)
 	if err != nil {
-		handleConversionError(err, C.ConversionToStarlarkFailed)
+		handleConversionError(err, state.module.ConversionToStarlarkFailed)
 		return starlark.None, err
 	}
 
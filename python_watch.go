@@ -0,0 +1,255 @@
+package main
+
+/*
+#include "starlark.h"
+*/
+import "C"
+
+import (
+	"reflect"
+	"sort"
+	"unsafe"
+
+	"go.starlark.net/starlark"
+)
+
+// globalsWatcher is a callback registered with watch, which is called when
+// global variables change.
+type globalsWatcher struct {
+	callback *C.PyObject
+	// nil means every name
+	names map[string]bool
+	// Set, holding the Mutex, once unwatch has removed it, so that it isn't
+	// called for the rest of a change that was already being reported
+	removed bool
+}
+
+//export Starlark_watch
+func Starlark_watch(self *C.Starlark, args *C.PyObject, kwargs *C.PyObject) (retval *C.PyObject) {
+	defer recoverPanic(starlarkTypeOf(self), nil, func() { retval = nil })
+
+	var (
+		callback *C.PyObject = nil
+		pynames  *C.PyObject = nil
+	)
+
+	if C.parseWatchArgs(args, kwargs, &callback, &pynames) == 0 {
+		return nil
+	}
+
+	if C.PyCallable_Check(callback) != 1 {
+		raiseTypeError(C.GoString(callback.ob_type.tp_name) + " is not callable")
+		return nil
+	}
+
+	watcher := &globalsWatcher{callback: callback}
+
+	if pynames != nil && pynames != C.Py_None {
+		if C.cgoPyUnicode_Check(pynames) == 1 {
+			raiseTypeError("names must be an iterable of str, not str")
+			return nil
+		}
+
+		iter := C.PyObject_GetIter(pynames)
+		if iter == nil {
+			return nil
+		}
+		defer C.Py_DecRef(iter)
+
+		watcher.names = map[string]bool{}
+		for pyname := C.PyIter_Next(iter); pyname != nil; pyname = C.PyIter_Next(iter) {
+			name, ok := globalName(pyname)
+			C.Py_DecRef(pyname)
+			if !ok {
+				if C.PyErr_Occurred() == nil {
+					raiseTypeError("names must be an iterable of str")
+				}
+				return nil
+			}
+
+			watcher.names[name] = true
+		}
+
+		if C.PyErr_Occurred() != nil {
+			return nil
+		}
+	}
+
+	C.Py_IncRef(callback)
+
+	state := lockSelf(self)
+	if state == nil {
+		C.Py_DecRef(callback)
+		return nil
+	}
+	state.Watchers = append(state.Watchers[:len(state.Watchers):len(state.Watchers)], watcher)
+	state.Mutex.unlock()
+
+	return C.cgoPy_NewRef(C.Py_None)
+}
+
+//export Starlark_unwatch
+func Starlark_unwatch(self *C.Starlark, callback *C.PyObject) (retval *C.PyObject) {
+	defer recoverPanic(starlarkTypeOf(self), nil, func() { retval = nil })
+
+	state := stateOf(self)
+	runlock := state.Mutex.rlock()
+	watchers := state.Watchers
+	runlock()
+
+	// Comparing can run Python code, so it can't be done holding the Mutex
+	remove := map[*globalsWatcher]bool{}
+	for _, watcher := range watchers {
+		switch C.PyObject_RichCompareBool(watcher.callback, callback, C.Py_EQ) {
+		case -1:
+			return nil
+		case 1:
+			remove[watcher] = true
+		}
+	}
+
+	if len(remove) == 0 {
+		raiseValueError("callback is not watching this Starlark object")
+		return nil
+	}
+
+	if !state.Mutex.lock() {
+		return nil
+	}
+	kept := make([]*globalsWatcher, 0, len(state.Watchers))
+	for _, watcher := range state.Watchers {
+		if remove[watcher] {
+			watcher.removed = true
+		} else {
+			kept = append(kept, watcher)
+		}
+	}
+	state.Watchers = kept
+	state.Mutex.unlock()
+
+	for watcher := range remove {
+		C.Py_DecRef(watcher.callback)
+	}
+
+	return C.cgoPy_NewRef(C.Py_None)
+}
+
+// notifyWatchers calls the watchers for each global variable that differs
+// between old and new, which hold the globals that may have changed. The GIL
+// must be held, and the Mutex must not be. Exceptions raised by watchers are
+// reported as unraisable, since the globals have already changed by then.
+func (state *StarlarkState) notifyWatchers(old starlark.StringDict, new starlark.StringDict) {
+	runlock := state.Mutex.rlock()
+	watchers := state.Watchers
+	// unwatch could release them while they're being called
+	for _, watcher := range watchers {
+		C.Py_IncRef(watcher.callback)
+	}
+	runlock()
+
+	if len(watchers) == 0 {
+		return
+	}
+
+	defer func() {
+		for _, watcher := range watchers {
+			C.Py_DecRef(watcher.callback)
+		}
+	}()
+
+	var changed []string
+	for name, value := range new {
+		if oldValue, ok := old[name]; !ok || !sameValue(oldValue, value) {
+			changed = append(changed, name)
+		}
+	}
+	for name := range old {
+		if _, ok := new[name]; !ok {
+			changed = append(changed, name)
+		}
+	}
+	sort.Strings(changed)
+
+	for _, name := range changed {
+		var pyname, pyold, pynew *C.PyObject
+
+		for _, watcher := range watchers {
+			if watcher.names != nil && !watcher.names[name] {
+				continue
+			}
+
+			runlock := state.Mutex.rlock()
+			removed := watcher.removed
+			runlock()
+			if removed {
+				continue
+			}
+
+			// Only convert the values if somebody is watching this name
+			if pyname == nil {
+				cname := C.CString(name)
+				pyname = C.cgoPy_BuildString(cname)
+				C.free(unsafe.Pointer(cname))
+				pyold = state.starlarkValueOrReprToPython(old[name])
+				pynew = state.starlarkValueOrReprToPython(new[name])
+
+				if pyname == nil || pyold == nil || pynew == nil {
+					C.PyErr_WriteUnraisable(nil)
+					break
+				}
+			}
+
+			result := C.cgoPyObject_CallThree(watcher.callback, pyname, pyold, pynew)
+			if result == nil {
+				C.PyErr_WriteUnraisable(watcher.callback)
+				continue
+			}
+			C.Py_DecRef(result)
+		}
+
+		for _, obj := range []*C.PyObject{pyname, pyold, pynew} {
+			if obj != nil {
+				C.Py_DecRef(obj)
+			}
+		}
+	}
+}
+
+// sameValue reports whether x and y are the same Starlark value. Some values,
+// like tuples, are slices, which can't be compared with ==.
+func sameValue(x, y starlark.Value) bool {
+	vx, vy := reflect.ValueOf(x), reflect.ValueOf(y)
+	if vx.Type() != vy.Type() {
+		return false
+	}
+
+	if vx.Comparable() {
+		return vx.Equal(vy)
+	}
+
+	if vx.Kind() == reflect.Slice {
+		return vx.Pointer() == vy.Pointer() && vx.Len() == vy.Len()
+	}
+
+	return false
+}
+
+// releaseWatchers drops every watcher, when Starlark is cleared. Nothing can
+// reach the object by then, so the Mutex isn't needed.
+func (state *StarlarkState) releaseWatchers() {
+	watchers := state.Watchers
+	for _, watcher := range watchers {
+		watcher.removed = true
+	}
+	state.Watchers = nil
+
+	for _, watcher := range watchers {
+		C.Py_DecRef(watcher.callback)
+	}
+}
+
+func raiseTypeError(msg string) {
+	cmsg := C.CString(msg)
+	defer C.free(unsafe.Pointer(cmsg))
+	C.PyErr_SetString(C.PyExc_TypeError, cmsg)
+}
@@ -1,29 +1,58 @@
 from starlark_go.errors import (
     ConversionError,
+    ConversionErrorItem,
     ConversionToPythonFailed,
     ConversionToStarlarkFailed,
+    ConversionToTypeFailed,
     EvalError,
+    EvalMemoryLimitError,
     EvalTimeoutError,
     ResolveError,
     ResolveErrorItem,
     StarlarkError,
+    StarlarkInternalError,
     SyntaxError,
+    WorkerError,
 )
+from starlark_go.isolation import SubprocessSnapshot, SubprocessStarlark
+from starlark_go.options import FileOptions
+from starlark_go.result import ExecResult
 from starlark_go.starlark_go import (  # pyright: reportMissingModuleSource=false
     Starlark,
+    StarlarkSnapshot,
     configure_starlark,
+    run_batch,
+    runtime_stats,
+    set_gc_percent,
+    set_gomaxprocs,
+    set_memory_limit,
 )
 
 __all__ = [
     "configure_starlark",
+    "run_batch",
+    "runtime_stats",
+    "set_gc_percent",
+    "set_gomaxprocs",
+    "set_memory_limit",
+    "ExecResult",
+    "FileOptions",
     "Starlark",
     "StarlarkError",
+    "StarlarkInternalError",
+    "StarlarkSnapshot",
     "ConversionError",
+    "ConversionErrorItem",
     "ConversionToPythonFailed",
     "ConversionToStarlarkFailed",
+    "ConversionToTypeFailed",
     "EvalError",
+    "EvalMemoryLimitError",
     "EvalTimeoutError",
     "ResolveError",
     "ResolveErrorItem",
+    "SubprocessSnapshot",
+    "SubprocessStarlark",
     "SyntaxError",
+    "WorkerError",
 ]
@@ -0,0 +1,42 @@
+"""
+The worker process of a :py:class:`starlark_go.SubprocessStarlark`.
+
+This is run as a script rather than with ``-m``, so that the limits are in place
+before starlark_go is imported and the Go runtime starts.
+"""
+
+import os
+import signal
+import sys
+
+
+def main(argv):
+    memory_limit, cpu_limit = (int(arg) if arg else None for arg in argv)
+
+    if memory_limit is not None or cpu_limit is not None:
+        import resource
+
+        if memory_limit is not None:
+            resource.setrlimit(resource.RLIMIT_AS, (memory_limit, memory_limit))
+        if cpu_limit is not None:
+            # The soft limit sends SIGXCPU, which kills us; the hard limit is in
+            # case something ignores it
+            resource.setrlimit(resource.RLIMIT_CPU, (cpu_limit, cpu_limit + 1))
+
+    # Ctrl-C goes to the whole process group, but it's up to the parent to
+    # decide what happens
+    signal.signal(signal.SIGINT, signal.SIG_IGN)
+
+    # Keep stdout for ourselves, so that nothing else can write to the pipe
+    writer = os.fdopen(os.dup(sys.stdout.fileno()), "wb")
+    os.dup2(sys.stderr.fileno(), sys.stdout.fileno())
+
+    from starlark_go.isolation import _WorkerChannel
+
+    _WorkerChannel(sys.stdin.buffer, writer).serve()
+
+
+if __name__ == "__main__":
+    # Don't let the other modules of the package be imported as top-level ones
+    del sys.path[0]
+    main(sys.argv[1:])
@@ -1,6 +1,14 @@
 from typing import Any, Optional, Tuple
 
-__all__ = ["StarlarkError", "SyntaxError", "EvalError", "EvalTimeoutError"]
+__all__ = [
+    "StarlarkError",
+    "SyntaxError",
+    "EvalError",
+    "EvalTimeoutError",
+    "EvalMemoryLimitError",
+    "StarlarkInternalError",
+    "WorkerError",
+]
 
 
 class StarlarkError(Exception):
@@ -144,6 +152,45 @@ class EvalTimeoutError(EvalError):
     """
 
 
+class EvalMemoryLimitError(EvalError):
+    """
+    A Starlark evaluation memory limit error.
+
+    This exception is raised when the Go heap grows beyond the specified
+    ``max_memory`` during an evaluation or execution.
+    """
+
+
+class StarlarkInternalError(StarlarkError):
+    """
+    An internal error in starlark-go or in this module.
+
+    This exception is raised instead of crashing the Python process when Go
+    code panics, which is always a bug. The Starlark object it was raised for
+    can still be used afterwards.
+    """
+
+    def __init__(self, error: str, error_type: str, go_stack: str):
+        super().__init__(error, error_type, go_stack)
+        self.go_stack = go_stack
+        """
+        The stack of the Go goroutine that panicked.
+
+        :type: str
+        """
+
+
+class WorkerError(StarlarkError):
+    """
+    An error raised when the worker process of a
+    :py:class:`starlark_go.SubprocessStarlark` has exited, for example because it
+    exceeded its memory or CPU limit.
+    """
+
+    def __init__(self, error: str, error_type: Optional[str] = None):
+        super().__init__(error, error_type)
+
+
 class ResolveErrorItem:
     """
     A location associated with a :py:class:`ResolveError`.
@@ -208,6 +255,49 @@ class ConversionToPythonFailed(ConversionError):
     """
 
 
+class ConversionErrorItem:
+    """
+    A location associated with a :py:class:`ConversionToTypeFailed`.
+    """
+
+    def __init__(self, msg: str, path: str):
+        self.msg = msg
+        """
+        A description of the problem at the location.
+
+        :type: str
+        """
+        self.path = path
+        """
+        The location of the problem within the converted value, as a JSON path
+        (for example, ``$.servers[0].port``.)
+
+        :type: str
+        """
+
+
+class ConversionToTypeFailed(ConversionToPythonFailed):
+    """
+    An error when converting a Starlark value to a specific Python type.
+
+    This exception is raised by :py:meth:`starlark_go.Starlark.eval`
+    and :py:meth:`starlark_go.Starlark.get` when a ``result_type`` is given
+    and the Starlark value does not match it.
+    """
+
+    def __init__(
+        self, error: str, error_type: str, errors: Tuple[ConversionErrorItem, ...]
+    ):
+        super().__init__(error, error_type, errors)
+        self.errors = list(errors)
+        """
+        A list of locations where the value did not match the requested type. A
+        ConversionToTypeFailed may contain one or more locations.
+
+        :type: typing.List[ConversionErrorItem]
+        """
+
+
 class ConversionToStarlarkFailed(ConversionError):
     """
     An error when converting a Python value to a Starlark value.
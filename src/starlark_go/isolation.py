@@ -0,0 +1,790 @@
+import builtins
+import inspect
+import io
+import itertools
+import os
+import pickle
+import signal
+import struct
+import subprocess
+import sys
+import threading
+import types
+from collections.abc import MutableMapping
+from typing import (
+    Any,
+    BinaryIO,
+    Callable,
+    Dict,
+    Iterable,
+    List,
+    Optional,
+    Set,
+    Tuple,
+)
+
+from starlark_go import errors, persistence
+from starlark_go.errors import WorkerError
+from starlark_go.options import FileOptions
+from starlark_go.result import ExecResult
+
+__all__ = ["SubprocessSnapshot", "SubprocessStarlark"]
+
+# Each message is a kind and the length of the pickled payload that follows
+_HEADER = struct.Struct("!BI")
+_REQUEST = 0
+_RESULT = 1
+_ERROR = 2
+
+# In the order of their values, which are what's sent to the worker
+_PARAMETER_KINDS = (
+    inspect.Parameter.POSITIONAL_ONLY,
+    inspect.Parameter.POSITIONAL_OR_KEYWORD,
+    inspect.Parameter.VAR_POSITIONAL,
+    inspect.Parameter.KEYWORD_ONLY,
+    inspect.Parameter.VAR_KEYWORD,
+)
+
+# Classes that the worker may send without the parent having sent them first
+_SAFE_BUILTINS = {"bytearray", "complex", "frozenset", "range", "set", "slice"}
+_SAFE_CLASSES = {
+    ("copyreg", "__newobj__"),
+    ("copyreg", "__newobj_ex__"),
+    ("types", "SimpleNamespace"),
+    ("starlark_go.options", "FileOptions"),
+    ("starlark_go.result", "ExecResult"),
+}
+
+
+class _Pickler(pickle.Pickler):
+    def __init__(self, file: BinaryIO, channel: "_Channel", proxy: bool):
+        super().__init__(file, protocol=pickle.HIGHEST_PROTOCOL)
+        self._channel = channel
+        self._proxy = proxy
+
+    def persistent_id(self, obj: Any) -> Any:
+        if not self._proxy:
+            return None
+        return self._channel.persistent_id(obj)
+
+    def reducer_override(self, obj: Any) -> Any:
+        self._channel.pickled(obj)
+        return NotImplemented
+
+
+class _Unpickler(pickle.Unpickler):
+    def __init__(self, file: BinaryIO, channel: "_Channel"):
+        super().__init__(file)
+        self._channel = channel
+
+    def persistent_load(self, pid: Any) -> Any:
+        return self._channel.persistent_load(pid)
+
+    def find_class(self, module: str, name: str) -> Any:
+        if not self._channel.allow_class(module, name):
+            raise pickle.UnpicklingError(f"{module}.{name} can't be received")
+        return super().find_class(module, name)
+
+
+class _Channel:
+    """
+    One end of the pipe between a process and its Starlark worker. Either end
+    can make requests while it waits for the answer to one of its own, so that
+    Python functions called by Starlark can call back into Starlark.
+    """
+
+    def __init__(self, reader: BinaryIO, writer: BinaryIO):
+        self._reader = reader
+        self._writer = writer
+
+    def persistent_id(self, obj: Any) -> Any:
+        return None
+
+    def persistent_load(self, pid: Any) -> Any:
+        raise pickle.UnpicklingError(f"unknown persistent id {pid!r}")
+
+    def pickled(self, obj: Any) -> None:
+        pass
+
+    def allow_class(self, module: str, name: str) -> bool:
+        return True
+
+    def dispatch(self, op: str, *args: Any) -> Any:
+        raise ValueError(f"unknown request {op!r}")
+
+    def dumps(self, obj: Any, proxy: bool = True) -> bytes:
+        """
+        Pickle obj. If proxy is False, functions are pickled by reference rather
+        than being called remotely, which is needed for types: List[int] is
+        pickled as a call to operator.getitem, for example.
+        """
+        buf = io.BytesIO()
+        _Pickler(buf, self, proxy).dump(obj)
+        return buf.getvalue()
+
+    def send(self, kind: int, payload: Any) -> None:
+        data = self.dumps(payload)
+
+        self._writer.write(_HEADER.pack(kind, len(data)))
+        self._writer.write(data)
+        self._writer.flush()
+
+    def receive(self) -> Tuple[int, bytes]:
+        kind, size = _HEADER.unpack(self._read(_HEADER.size))
+        return kind, self._read(size)
+
+    def loads(self, data: bytes) -> Any:
+        return _Unpickler(io.BytesIO(data), self).load()
+
+    def _read(self, size: int) -> bytes:
+        data = self._reader.read(size)
+        if len(data) < size:
+            raise EOFError("the other end of the pipe was closed")
+        return data
+
+    def request(self, *msg: Any) -> Any:
+        self.send(_REQUEST, msg)
+
+        while True:
+            kind, data = self.receive()
+            if kind == _RESULT:
+                return self.loads(data)
+            if kind == _ERROR:
+                raise self.loads(data)
+            self.handle(data)
+
+    def handle(self, data: bytes) -> None:
+        try:
+            result = self.dispatch(*self.loads(data))
+        except Exception as e:
+            self.send_error(e)
+            return
+
+        try:
+            self.send(_RESULT, result)
+        except Exception as e:
+            self.send_error(e)
+
+    def send_error(self, e: Exception) -> None:
+        try:
+            self.send(_ERROR, e)
+        except Exception:
+            # The exception can't be pickled, so send its message instead
+            self.send(_ERROR, RuntimeError(f"{type(e).__name__}: {e}"))
+
+    def serve(self) -> None:
+        while True:
+            try:
+                _, data = self.receive()
+            except EOFError:
+                return
+            self.handle(data)
+
+
+class _Type:
+    """
+    A type that's sent to the worker, pickled on its own.
+    """
+
+    def __init__(self, data: bytes):
+        self.data = data
+
+    def load(self) -> Any:
+        try:
+            return pickle.loads(self.data)
+        except Exception:
+            return inspect.Parameter.empty
+
+
+def _parameters(
+    channel: _Channel, fn: Callable[..., Any]
+) -> Optional[List[Tuple[str, int, bool, Optional[_Type]]]]:
+    try:
+        try:
+            sig = inspect.signature(fn, eval_str=True)
+        except Exception:
+            sig = inspect.signature(fn)
+    except (TypeError, ValueError):
+        return None
+
+    params = []
+    for p in sig.parameters.values():
+        # Annotations are pickled on their own, so that the worker can ignore
+        # those it can't import rather than failing the whole request
+        annotation = None
+        if p.annotation is not p.empty:
+            try:
+                annotation = _Type(channel.dumps(p.annotation, proxy=False))
+            except Exception:
+                pass
+        params.append((p.name, int(p.kind), p.default is not p.empty, annotation))
+    return params
+
+
+
+def _is_type(obj: Any) -> bool:
+    # Generic aliases like List[int] are callable, but they're types, so they're
+    # sent by reference rather than called in this process
+    return (
+        isinstance(obj, (type, types.GenericAlias, types.UnionType))
+        or type(obj).__module__ == "typing"
+    )
+
+
+class _ParentChannel(_Channel):
+    """
+    The end of the pipe in the process that uses :py:class:`SubprocessStarlark`.
+    Python functions that are sent to the worker stay here, and the worker
+    calls them by ID. Since the worker runs untrusted code, it may only send
+    back classes that were sent to it, and a few harmless ones.
+    """
+
+    def __init__(self, reader: BinaryIO, writer: BinaryIO):
+        super().__init__(reader, writer)
+        self._ids = itertools.count(1)
+        self._callbacks: Dict[int, Callable[..., Any]] = {}
+        self._callback_ids: Dict[int, int] = {}
+        self._allowed: Set[Tuple[str, str]] = set(_SAFE_CLASSES)
+
+    def persistent_id(self, obj: Any) -> Any:
+        if not callable(obj) or _is_type(obj):
+            return None
+
+        cb_id = self._callback_ids.get(id(obj))
+        if cb_id is None:
+            cb_id = next(self._ids)
+            self._callback_ids[id(obj)] = cb_id
+            # Keep the callable alive, so that its id() isn't reused
+            self._callbacks[cb_id] = obj
+
+        name = getattr(obj, "__qualname__", None)
+        if not isinstance(name, str):
+            name = repr(obj)
+
+        return ("callback", cb_id, name, _parameters(self, obj))
+
+    def persistent_load(self, pid: Any) -> Any:
+        if pid[0] != "callback" or pid[1] not in self._callbacks:
+            return super().persistent_load(pid)
+        return self._callbacks[pid[1]]
+
+    def pickled(self, obj: Any) -> None:
+        cls = obj if isinstance(obj, type) else type(obj)
+        self._allowed.add((cls.__module__, cls.__qualname__))
+
+    def allow_class(self, module: str, name: str) -> bool:
+        if (module, name) in self._allowed:
+            return True
+        if module == "starlark_go.errors":
+            obj = getattr(errors, name, None)
+            return isinstance(obj, type) and (
+                issubclass(obj, errors.StarlarkError) or name.endswith("Item")
+            )
+        if module == "builtins":
+            obj = getattr(builtins, name, None)
+            return name in _SAFE_BUILTINS or (
+                isinstance(obj, type) and issubclass(obj, Exception)
+            )
+        return False
+
+    def dispatch(self, op: str, *args: Any) -> Any:
+        if op != "call":
+            return super().dispatch(op, *args)
+
+        cb_id, call_args, call_kwargs = args
+        return self._callbacks[cb_id](*call_args, **call_kwargs)
+
+
+class _RemoteFunction:
+    """
+    A Python function of the parent process, as seen by the worker.
+    """
+
+    def __init__(
+        self,
+        channel: _Channel,
+        cb_id: int,
+        name: str,
+        params: Optional[List[Tuple[str, int, bool, Optional[_Type]]]],
+    ):
+        self._channel = channel
+        self._id = cb_id
+        self.__qualname__ = name
+
+        if params is not None:
+            self.__signature__ = inspect.Signature(
+                [
+                    inspect.Parameter(
+                        pname,
+                        _PARAMETER_KINDS[kind],
+                        default=None if has_default else inspect.Parameter.empty,
+                        annotation=(
+                            annotation.load()
+                            if annotation is not None
+                            else inspect.Parameter.empty
+                        ),
+                    )
+                    for pname, kind, has_default, annotation in params
+                ]
+            )
+
+    def __call__(self, *args: Any, **kwargs: Any) -> Any:
+        return self._channel.request("call", self._id, args, kwargs)
+
+
+class _WorkerChannel(_Channel):
+    """
+    The end of the pipe in the worker, which owns the actual Starlark object.
+    """
+
+    _METHODS = {
+        "eval",
+        "eval_many",
+        "exec",
+        "globals",
+        "get",
+        "set",
+        "pop",
+        "clear",
+        "__getitem__",
+        "__setitem__",
+        "__delitem__",
+        "__len__",
+        "__contains__",
+        "watch",
+        "unwatch",
+        "_persistent_state",
+    }
+    _PROPERTIES = {"print", "struct_factory", "options"}
+
+    def __init__(self, reader: BinaryIO, writer: BinaryIO):
+        super().__init__(reader, writer)
+        self._remote_functions: Dict[int, _RemoteFunction] = {}
+        self._starlark: Any = None
+        self._snapshots: Dict[int, Any] = {}
+        self._next_snapshot_id = 0
+
+    def persistent_id(self, obj: Any) -> Any:
+        if isinstance(obj, _RemoteFunction):
+            return ("callback", obj._id)
+        return None
+
+    def persistent_load(self, pid: Any) -> Any:
+        if pid[0] != "callback":
+            return super().persistent_load(pid)
+
+        _, cb_id, name, params = pid
+        fn = self._remote_functions.get(cb_id)
+        if fn is None:
+            fn = _RemoteFunction(self, cb_id, name, params)
+            self._remote_functions[cb_id] = fn
+        return fn
+
+    def dispatch(self, op: str, *args: Any) -> Any:
+        from starlark_go.starlark_go import Starlark
+
+        if op == "init":
+            (kwargs,) = args
+            self._starlark = Starlark(**kwargs)
+            return None
+
+        if op in self._METHODS:
+            call_args, call_kwargs = args
+            if isinstance(call_kwargs.get("result_type"), _Type):
+                call_kwargs["result_type"] = pickle.loads(
+                    call_kwargs["result_type"].data
+                )
+            return getattr(self._starlark, op)(*call_args, **call_kwargs)
+
+        if op == "snapshot":
+            self._next_snapshot_id += 1
+            self._snapshots[self._next_snapshot_id] = self._starlark.snapshot()
+            return self._next_snapshot_id
+
+        if op == "restore":
+            (snapshot_id,) = args
+            self._starlark.restore(self._snapshots[snapshot_id])
+            return None
+
+        if op == "release_snapshots":
+            (snapshot_ids,) = args
+            for snapshot_id in snapshot_ids:
+                self._snapshots.pop(snapshot_id, None)
+            return None
+
+        if op == "getattr" and args[0] in self._PROPERTIES:
+            return getattr(self._starlark, args[0])
+
+        if op == "setattr" and args[0] in self._PROPERTIES:
+            setattr(self._starlark, args[0], args[1])
+            return None
+
+        return super().dispatch(op, *args)
+
+
+class SubprocessSnapshot:
+    """
+    A snapshot of the global variables of a
+    :py:class:`starlark_go.SubprocessStarlark`, taken by
+    :py:meth:`starlark_go.SubprocessStarlark.snapshot`. The snapshot itself stays
+    in the worker process.
+    """
+
+    def __init__(self, owner: "SubprocessStarlark", snapshot_id: int):
+        self._owner = owner
+        self._id = snapshot_id
+
+    def __del__(self) -> None:
+        # Sending a request from here could happen in the middle of another
+        # one, so the worker is told with the next request instead
+        owner = getattr(self, "_owner", None)
+        if owner is not None:
+            owner._released_snapshots.append(self._id)
+
+
+class SubprocessStarlark(MutableMapping):
+    """
+    A Starlark object that runs Starlark in a separate process, for code that
+    isn't trusted. This is what ``Starlark(isolation="subprocess")`` returns, and
+    it can also be created directly. It takes the same arguments as
+    :py:class:`starlark_go.Starlark`, along with the limits below, and has the same
+    methods and properties, except that :py:meth:`starlark_go.Starlark.fork` and
+    :py:meth:`starlark_go.Starlark.exec_module` aren't supported. Copying it with
+    :py:func:`copy.copy` or pickling it saves its state like :py:meth:`dump`, and
+    loads it into a new worker.
+
+    Arguments, globals and results are pickled to and from the worker process.
+    Python functions stay in this process, and are called by the worker over the
+    same pipe. Since the worker is not trusted, only classes that were sent to it
+    can be sent back, along with built-in types and exceptions.
+
+    If the worker exits, for example because it exceeded ``memory_limit`` or
+    ``cpu_limit``, a :py:class:`starlark_go.WorkerError` is raised, and so is
+    every later call. Its global variables are lost.
+
+    :param memory_limit: The maximum size in bytes of the address space of the
+        worker (``RLIMIT_AS``).
+    :type memory_limit: typing.Optional[int]
+    :param cpu_limit: The maximum CPU time in seconds the worker may use over its
+        lifetime (``RLIMIT_CPU``).
+    :type cpu_limit: typing.Optional[int]
+    """
+
+    def __init__(
+        self,
+        *,
+        globals: Optional[Any] = None,
+        print: Optional[Callable[[str], Any]] = None,
+        struct_factory: Optional[Callable[..., Any]] = None,
+        coerce_args: bool = False,
+        options: Optional[FileOptions] = None,
+        memory_limit: Optional[int] = None,
+        cpu_limit: Optional[int] = None,
+    ):
+        from starlark_go.starlark_go import _resolve_options
+
+        self._lock = threading.RLock()
+        self._print = print
+        self._struct_factory = struct_factory
+        self._process: Optional[subprocess.Popen[bytes]] = None
+        self._exit_status: Optional[int] = None
+        self._closed = False
+        self._released_snapshots: List[int] = []
+
+        # The worker doesn't share the defaults set by configure_starlark, so
+        # send it the options they result in
+        options = _resolve_options(options)
+
+        package_dir = os.path.dirname(os.path.dirname(os.path.abspath(__file__)))
+        env = dict(os.environ)
+        env["PYTHONPATH"] = os.pathsep.join(
+            [package_dir] + ([env["PYTHONPATH"]] if env.get("PYTHONPATH") else [])
+        )
+
+        limits = [
+            str(int(x)) if x is not None else "" for x in (memory_limit, cpu_limit)
+        ]
+        worker = os.path.join(os.path.dirname(os.path.abspath(__file__)), "_worker.py")
+
+        self._process = subprocess.Popen(
+            [sys.executable, worker] + limits,
+            stdin=subprocess.PIPE,
+            stdout=subprocess.PIPE,
+            env=env,
+        )
+        assert self._process.stdin is not None and self._process.stdout is not None
+        self._channel = _ParentChannel(self._process.stdout, self._process.stdin)
+
+        kwargs = {
+            "globals": globals,
+            "print": self._print_in_parent,
+            "struct_factory": struct_factory,
+            "coerce_args": coerce_args,
+            "options": options,
+        }
+        self._request("init", {k: v for k, v in kwargs.items() if v is not None})
+
+    def _print_in_parent(self, msg: str) -> None:
+        if self._print is None:
+            builtins.print(msg)
+        else:
+            self._print(msg)
+
+    def _request(self, *msg: Any) -> Any:
+        with self._lock:
+            if self._process is None or self._exit_status is not None:
+                raise WorkerError(self._exit_message())
+
+            try:
+                if self._released_snapshots:
+                    released = self._released_snapshots
+                    self._released_snapshots = []
+                    self._channel.request("release_snapshots", released)
+                return self._channel.request(*msg)
+            except (EOFError, BrokenPipeError):
+                self._kill()
+                raise WorkerError(self._exit_message()) from None
+            except BaseException as e:
+                # Something like KeyboardInterrupt left the worker halfway
+                # through a request, so it can't be used any more
+                if not isinstance(e, Exception):
+                    self._kill()
+                raise
+
+    def _kill(self) -> None:
+        if self._process is None or self._exit_status is not None:
+            return
+
+        self._process.kill()
+        self._exit_status = self._process.wait()
+        self._close_pipes()
+
+    def _close_pipes(self) -> None:
+        assert self._process is not None
+        for pipe in (self._process.stdin, self._process.stdout):
+            if pipe is not None:
+                try:
+                    pipe.close()
+                except OSError:
+                    pass
+
+    def _exit_message(self) -> str:
+        status = self._exit_status
+        if self._closed or status is None:
+            return "Starlark worker is closed"
+        if status < 0:
+            try:
+                name = signal.Signals(-status).name
+            except ValueError:
+                name = str(-status)
+            return f"Starlark worker was killed by {name}"
+        return f"Starlark worker exited with status {status}"
+
+    def close(self) -> None:
+        """
+        Stop the worker process. Any later calls raise
+        :py:class:`starlark_go.WorkerError`.
+        """
+        with self._lock:
+            if self._process is None or self._exit_status is not None:
+                return
+
+            self._closed = True
+            self._close_pipes()
+            try:
+                self._exit_status = self._process.wait(timeout=5)
+            except subprocess.TimeoutExpired:
+                self._kill()
+
+    def __enter__(self) -> "SubprocessStarlark":
+        return self
+
+    def __exit__(self, *exc_info: Any) -> None:
+        self.close()
+
+    def __del__(self) -> None:
+        if getattr(self, "_process", None) is not None:
+            self._kill()
+
+    def _send_result_type(self, kwargs: Dict[str, Any]) -> Dict[str, Any]:
+        if kwargs.get("result_type") is not None:
+            kwargs["result_type"] = _Type(
+                self._channel.dumps(kwargs["result_type"], proxy=False)
+            )
+        return kwargs
+
+    def eval(self, *args: Any, **kwargs: Any) -> Any:
+        """
+        Evaluate a Starlark expression. See :py:meth:`starlark_go.Starlark.eval`.
+        """
+        return self._request("eval", args, self._send_result_type(kwargs))
+
+    def eval_many(self, expr: str, records: Any, **kwargs: Any) -> List[Any]:
+        """
+        Evaluate a Starlark expression for many records. See
+        :py:meth:`starlark_go.Starlark.eval_many`.
+        """
+        return self._request(
+            "eval_many", (expr, list(records)), self._send_result_type(kwargs)
+        )
+
+    def exec(self, *args: Any, **kwargs: Any) -> Optional[ExecResult]:
+        """
+        Execute Starlark code. See :py:meth:`starlark_go.Starlark.exec`.
+        """
+        return self._request("exec", args, kwargs)
+
+    def globals(self) -> List[str]:
+        """
+        Get the names of the global variables. See
+        :py:meth:`starlark_go.Starlark.globals`.
+        """
+        return self._request("globals", (), {})
+
+    def get(self, *args: Any, **kwargs: Any) -> Any:
+        """
+        Get the value of a global variable. See :py:meth:`starlark_go.Starlark.get`.
+        """
+        return self._request("get", args, self._send_result_type(kwargs))
+
+    def set(self, **kwargs: Any) -> None:
+        """
+        Set global variables. See :py:meth:`starlark_go.Starlark.set`.
+        """
+        return self._request("set", (), kwargs)
+
+    def pop(self, *args: Any, **kwargs: Any) -> Any:
+        """
+        Remove a global variable. See :py:meth:`starlark_go.Starlark.pop`.
+        """
+        return self._request("pop", args, kwargs)
+
+    def snapshot(self) -> SubprocessSnapshot:
+        """
+        Take a snapshot of the global variables. See
+        :py:meth:`starlark_go.Starlark.snapshot`.
+        """
+        return SubprocessSnapshot(self, self._request("snapshot"))
+
+    def restore(self, snapshot: SubprocessSnapshot) -> None:
+        """
+        Restore a snapshot of the global variables. See
+        :py:meth:`starlark_go.Starlark.restore`.
+        """
+        if not isinstance(snapshot, SubprocessSnapshot):
+            raise TypeError(
+                f"expected a SubprocessSnapshot, got {type(snapshot).__name__}"
+            )
+        if snapshot._owner is not self:
+            raise ValueError("snapshot was taken from a different Starlark object")
+        self._request("restore", snapshot._id)
+
+    def watch(
+        self,
+        callback: Callable[[str, Any, Any], Any],
+        names: Optional[Iterable[str]] = None,
+    ) -> None:
+        """
+        Call a function whenever a global variable changes. See
+        :py:meth:`starlark_go.Starlark.watch`. The callback stays in this
+        process, and exceptions it raises are reported by the worker.
+        """
+        if not callable(callback):
+            raise TypeError(f"{type(callback).__name__} is not callable")
+        if names is not None and not isinstance(names, str):
+            names = list(names)
+        self._request("watch", (callback, names), {})
+
+    def unwatch(self, callback: Callable[[str, Any, Any], Any]) -> None:
+        """
+        Stop calling a function registered with :py:meth:`watch`. See
+        :py:meth:`starlark_go.Starlark.unwatch`.
+        """
+        self._request("unwatch", (callback,), {})
+
+    def dump(self, fp: BinaryIO) -> None:
+        """
+        Save the global variables and options to a file. See
+        :py:meth:`starlark_go.Starlark.dump`.
+        """
+        persistence.dump(self, fp)
+
+    @classmethod
+    def load_state(cls, fp: BinaryIO, **kwargs: Any) -> "SubprocessStarlark":
+        """
+        Create a SubprocessStarlark from a file written by :py:meth:`dump`. See
+        :py:meth:`starlark_go.Starlark.load_state`.
+        """
+        return persistence.load(cls, fp, **kwargs)
+
+    def __reduce__(self) -> Tuple[Any, ...]:
+        return persistence.reduce(self)
+
+    def _persistent_state(self) -> Dict[str, Any]:
+        return self._request("_persistent_state", (), {})
+
+    def clear(self) -> None:
+        """
+        Remove all global variables. See :py:meth:`starlark_go.Starlark.clear`.
+        """
+        return self._request("clear", (), {})
+
+    def __getitem__(self, name: str) -> Any:
+        return self._request("__getitem__", (name,), {})
+
+    def __setitem__(self, name: str, value: Any) -> None:
+        self._request("__setitem__", (name, value), {})
+
+    def __delitem__(self, name: str) -> None:
+        self._request("__delitem__", (name,), {})
+
+    def __len__(self) -> int:
+        return self._request("__len__", (), {})
+
+    def __contains__(self, name: object) -> bool:
+        return self._request("__contains__", (name,), {})
+
+    def __iter__(self) -> Any:
+        return iter(self.globals())
+
+    # Like Starlark, compare by identity rather than as mappings
+    __eq__ = object.__eq__
+    __hash__ = object.__hash__
+
+    @property
+    def print(self) -> Optional[Callable[[str], Any]]:
+        """
+        See :py:attr:`starlark_go.Starlark.print`.
+        """
+        return self._print
+
+    @print.setter
+    def print(self, value: Optional[Callable[[str], Any]]) -> None:
+        if value is not None and not callable(value):
+            raise TypeError(f"{type(value).__name__} is not callable")
+        self._print = value
+
+    @property
+    def struct_factory(self) -> Optional[Callable[..., Any]]:
+        """
+        See :py:attr:`starlark_go.Starlark.struct_factory`.
+        """
+        return self._struct_factory
+
+    @struct_factory.setter
+    def struct_factory(self, value: Optional[Callable[..., Any]]) -> None:
+        self._request("setattr", "struct_factory", value)
+        self._struct_factory = value
+
+    @property
+    def options(self) -> FileOptions:
+        """
+        See :py:attr:`starlark_go.Starlark.options`.
+        """
+        return self._request("getattr", "options")
+
+    @options.setter
+    def options(self, value: Optional[FileOptions]) -> None:
+        from starlark_go.starlark_go import _resolve_options
+
+        self._request("setattr", "options", _resolve_options(value))
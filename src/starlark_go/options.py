@@ -0,0 +1,71 @@
+from dataclasses import dataclass
+from typing import Optional
+
+__all__ = ["FileOptions"]
+
+
+@dataclass(frozen=True)
+class FileOptions:
+    """
+    Options that control which Starlark dialect is accepted.
+
+    These can be passed to :py:class:`starlark_go.Starlark`, and to
+    :py:meth:`starlark_go.Starlark.eval` and :py:meth:`starlark_go.Starlark.exec` to
+    override the options of the instance for one call.
+
+    Options that are ``None`` are inherited: options passed to a call inherit from
+    the options of the instance, and those inherit from the defaults set by
+    :py:func:`starlark_go.configure_starlark`.
+
+    Options take effect when code is compiled, so functions keep the options they
+    were defined with when they are called by later code.
+
+    See the `starlark-go documentation
+    <https://pkg.go.dev/go.starlark.net/syntax#FileOptions>`_ for more information.
+    """
+
+    allow_set: Optional[bool] = None
+    """
+    If ``True``, allow references to the ``set`` built-in function.
+
+    :type: typing.Optional[bool]
+    """
+
+    allow_global_reassign: Optional[bool] = None
+    """
+    If ``True``, allow reassignment to top-level names.
+
+    :type: typing.Optional[bool]
+    """
+
+    allow_recursion: Optional[bool] = None
+    """
+    If ``True``, allow functions to call themselves recursively.
+
+    :type: typing.Optional[bool]
+    """
+
+    allow_while: Optional[bool] = None
+    """
+    If ``True``, allow ``while`` statements. Defaults to the value of
+    ``allow_recursion`` given to :py:func:`starlark_go.configure_starlark`.
+
+    :type: typing.Optional[bool]
+    """
+
+    allow_top_level_control: Optional[bool] = None
+    """
+    If ``True``, allow ``if``, ``for`` and ``while`` statements at top-level.
+    Defaults to the value of ``allow_global_reassign`` given to
+    :py:func:`starlark_go.configure_starlark`.
+
+    :type: typing.Optional[bool]
+    """
+
+    load_binds_globally: Optional[bool] = None
+    """
+    If ``True``, ``load`` statements create global bindings rather than file-local
+    ones. This is deprecated in starlark-go.
+
+    :type: typing.Optional[bool]
+    """
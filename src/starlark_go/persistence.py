@@ -0,0 +1,82 @@
+import pickle
+from typing import Any, BinaryIO, Dict, Tuple, Type
+
+from starlark_go.errors import ConversionToPythonFailed
+
+__all__ = ["dump", "load", "reduce"]
+
+# Bumped whenever the saved state changes in a way that older versions can't
+# load
+_VERSION = 1
+
+
+def _discard_print(msg: str) -> None:
+    pass
+
+
+def _state(starlark: Any) -> Dict[str, Any]:
+    names = starlark.globals()
+    values: Dict[str, Any] = {}
+    unconverted: Dict[str, ConversionToPythonFailed] = {}
+
+    for name in names:
+        try:
+            values[name] = starlark.get(name)
+        except ConversionToPythonFailed as e:
+            unconverted[name] = e
+
+    persistent = starlark._persistent_state()
+
+    defined = {name for source in persistent["sources"] for name in source["names"]}
+    for name, error in unconverted.items():
+        if name not in defined:
+            raise error
+
+    return {
+        "version": _VERSION,
+        "names": names,
+        "globals": values,
+        "sources": persistent["sources"],
+        "options": starlark.options,
+        "coerce_args": persistent["coerce_args"],
+    }
+
+
+def _restore(cls: Type[Any], state: Dict[str, Any], **kwargs: Any) -> Any:
+    if state.get("version") != _VERSION:
+        raise ValueError(f"Unsupported Starlark state version {state.get('version')}")
+
+    kwargs.setdefault("options", state["options"])
+    kwargs.setdefault("coerce_args", state["coerce_args"])
+    starlark = cls(**kwargs)
+
+    # The code is run against the values as they were saved rather than as they
+    # were at the time, which is as close as we can get
+    starlark.set(**state["globals"])
+    for source in state["sources"]:
+        starlark.exec(
+            source["source"],
+            filename=source["filename"],
+            options=source["options"],
+            print=_discard_print,
+        )
+    starlark.set(**state["globals"])
+
+    names = set(state["names"])
+    for name in starlark.globals():
+        if name not in names:
+            del starlark[name]
+
+    return starlark
+
+
+def dump(starlark: Any, fp: BinaryIO) -> None:
+    pickle.dump(_state(starlark), fp)
+
+
+def load(cls: Type[Any], fp: BinaryIO, **kwargs: Any) -> Any:
+    return _restore(cls, pickle.load(fp), **kwargs)
+
+
+def reduce(starlark: Any) -> Tuple[Any, ...]:
+    return (_restore, (type(starlark), _state(starlark)))
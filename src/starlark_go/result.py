@@ -0,0 +1,44 @@
+from dataclasses import dataclass
+from typing import Any, Dict, Optional, Tuple
+
+__all__ = ["ExecResult"]
+
+
+@dataclass(frozen=True)
+class ExecResult:
+    """
+    What a call to :py:meth:`starlark_go.Starlark.exec` did, returned when it's
+    called with ``return_result=True``.
+    """
+
+    defined: Tuple[str, ...]
+    """
+    The names of the global variables the code defined, in alphabetical order.
+
+    :type: typing.Tuple[str, ...]
+    """
+
+    replaced: Tuple[str, ...]
+    """
+    The names in :py:attr:`defined` that were already global variables before
+    the code ran, whose old values were replaced.
+
+    :type: typing.Tuple[str, ...]
+    """
+
+    elapsed: float
+    """
+    The number of seconds it took to compile and run the code.
+
+    :type: float
+    """
+
+    values: Optional[Dict[str, Any]] = None
+    """
+    The values of the variables in :py:attr:`defined`, converted to Python, if
+    ``convert_values=True`` was passed to :py:meth:`starlark_go.Starlark.exec`.
+    Otherwise ``None``. Values that can't be converted, like functions, are given
+    as their Starlark representation.
+
+    :type: typing.Optional[typing.Dict[str, typing.Any]]
+    """
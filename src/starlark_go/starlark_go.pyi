@@ -1,4 +1,21 @@
-from typing import Any, Callable, List, Mapping, Optional
+from typing import (
+    Any,
+    BinaryIO,
+    Callable,
+    Dict,
+    Iterable,
+    Iterator,
+    List,
+    Mapping,
+    MutableMapping,
+    Optional,
+    Tuple,
+    Union,
+)
+
+from starlark_go.errors import StarlarkError
+from starlark_go.options import FileOptions
+from starlark_go.result import ExecResult
 
 def configure_starlark(
     *,
@@ -6,13 +23,34 @@ def configure_starlark(
     allow_global_reassign: Optional[bool] = ...,
     allow_recursion: Optional[bool] = ...,
 ) -> None: ...
+def _resolve_options(options: Optional[FileOptions]) -> FileOptions: ...
+def runtime_stats() -> Dict[str, Any]: ...
+def set_gomaxprocs(n: int) -> int: ...
+def set_gc_percent(percent: Optional[int]) -> Optional[int]: ...
+def set_memory_limit(limit: Optional[int]) -> Optional[int]: ...
+def run_batch(
+    jobs: Iterable[Tuple[str, Optional[str], Optional[Mapping[str, Any]]]],
+    *,
+    workers: Optional[int] = ...,
+    print: Optional[Callable[[str], Any]] = ...,
+    options: Optional[FileOptions] = ...,
+    timeout: Optional[float] = ...,
+) -> List[Union[Starlark, StarlarkError]]: ...
+
+class StarlarkSnapshot: ...
 
-class Starlark:
+class Starlark(MutableMapping[str, Any]):
     def __init__(
         self,
         *,
         globals: Optional[Mapping[str, Any]] = ...,
         print: Callable[[str], Any] = ...,
+        struct_factory: Optional[Callable[..., Any]] = ...,
+        coerce_args: bool = ...,
+        options: Optional[FileOptions] = ...,
+        isolation: Optional[str] = ...,
+        memory_limit: Optional[int] = ...,
+        cpu_limit: Optional[int] = ...,
     ) -> None: ...
     def eval(
         self,
@@ -22,7 +60,25 @@ class Starlark:
         convert: Optional[bool] = ...,
         print: Callable[[str], Any] = ...,
         timeout: Optional[float] = ...,
+        max_memory: Optional[int] = ...,
+        result_type: Optional[Any] = ...,
+        options: Optional[FileOptions] = ...,
+        locals: Optional[Mapping[str, Any]] = ...,
     ) -> Any: ...
+    def eval_many(
+        self,
+        expr: str,
+        records: Iterable[Mapping[str, Any]],
+        *,
+        filename: Optional[str] = ...,
+        convert: Optional[bool] = ...,
+        print: Callable[[str], Any] = ...,
+        timeout: Optional[float] = ...,
+        max_memory: Optional[int] = ...,
+        result_type: Optional[Any] = ...,
+        options: Optional[FileOptions] = ...,
+        return_exceptions: bool = ...,
+    ) -> List[Any]: ...
     def exec(
         self,
         defs: str,
@@ -30,14 +86,64 @@ class Starlark:
         filename: Optional[str] = ...,
         print: Callable[[str], Any] = ...,
         timeout: Optional[float] = ...,
-    ) -> None: ...
+        max_memory: Optional[int] = ...,
+        options: Optional[FileOptions] = ...,
+        return_result: bool = ...,
+        convert_values: bool = ...,
+    ) -> Optional[ExecResult]: ...
     def globals(self) -> List[str]: ...
-    def get(self, name: str, default_value: Optional[Any] = ...) -> None: ...
+    def get(
+        self,
+        name: str,
+        default_value: Optional[Any] = ...,
+        *,
+        result_type: Optional[Any] = ...,
+    ) -> Any: ...
     def set(self, **kwargs: Any) -> None: ...
     def pop(self, name: str, default_value: Optional[Any] = ...) -> Any: ...
+    def clear(self) -> None: ...
+    def __getitem__(self, name: str) -> Any: ...
+    def __setitem__(self, name: str, value: Any) -> None: ...
+    def __delitem__(self, name: str) -> None: ...
+    def __len__(self) -> int: ...
+    def __contains__(self, name: object) -> bool: ...
+    def __iter__(self) -> Iterator[str]: ...
+    def snapshot(self) -> StarlarkSnapshot: ...
+    def restore(self, snapshot: StarlarkSnapshot) -> None: ...
+    def watch(
+        self,
+        callback: Callable[[str, Any, Any], Any],
+        names: Optional[Iterable[str]] = ...,
+    ) -> None: ...
+    def unwatch(self, callback: Callable[[str, Any, Any], Any]) -> None: ...
+    def fork(self) -> Starlark: ...
+    def exec_module(
+        self,
+        source: str,
+        *,
+        filename: Optional[str] = ...,
+        print: Callable[[str], Any] = ...,
+        timeout: Optional[float] = ...,
+        max_memory: Optional[int] = ...,
+        options: Optional[FileOptions] = ...,
+    ) -> Starlark: ...
+    def __copy__(self) -> Starlark: ...
+    def dump(self, fp: BinaryIO) -> None: ...
+    @classmethod
+    def load_state(cls, fp: BinaryIO, **kwargs: Any) -> Starlark: ...
     @property
     def print(self) -> Optional[Callable[[str], Any]]: ...
     @print.setter
     def print(
         self, value: Optional[Callable[[str], Any]]
     ) -> Optional[Callable[[str], Any]]: ...
+    @property
+    def struct_factory(self) -> Optional[Callable[..., Any]]: ...
+    @struct_factory.setter
+    def struct_factory(
+        self, value: Optional[Callable[..., Any]]
+    ) -> Optional[Callable[..., Any]]: ...
+    @property
+    def options(self) -> FileOptions: ...
+    @options.setter
+    def options(self, value: Optional[FileOptions]) -> Optional[FileOptions]: ...
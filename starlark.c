@@ -1,11 +1,17 @@
 #include "starlark.h"
+#include <signal.h>
+#include <structmember.h>
 
 /* Declarations for object methods written in Go */
-void ConfigureStarlark(int allowSet, int allowGlobalReassign, int allowRecursion);
+void ConfigureStarlark(
+    StarlarkModuleState *state, int allowSet, int allowGlobalReassign, int allowRecursion
+);
 
 int Starlark_init(Starlark *self, PyObject *args, PyObject *kwds);
 Starlark *Starlark_new(PyTypeObject *type, PyObject *args, PyObject *kwds);
 void Starlark_dealloc(Starlark *self);
+int Starlark_traverse(Starlark *self, visitproc visit, void *arg);
+int Starlark_clear(Starlark *self);
 PyObject *Starlark_eval(Starlark *self, PyObject *args);
 PyObject *Starlark_exec(Starlark *self, PyObject *args);
 PyObject *Starlark_global_names(Starlark *self, PyObject *_);
@@ -14,17 +20,42 @@ PyObject *Starlark_set_globals(Starlark *self, PyObject *args, PyObject **kwargs
 PyObject *Starlark_pop_global(Starlark *self, PyObject *args, PyObject **kwargs);
 PyObject *Starlark_get_print(Starlark *self, void *closure);
 int Starlark_set_print(Starlark *self, PyObject *value, void *closure);
+PyObject *Starlark_get_struct_factory(Starlark *self, void *closure);
+int Starlark_set_struct_factory(Starlark *self, PyObject *value, void *closure);
+PyObject *Starlark_get_options(Starlark *self, void *closure);
+int Starlark_set_options(Starlark *self, PyObject *value, void *closure);
 PyObject *Starlark_tp_iter(Starlark *self);
-
-/* Exceptions - the module init function will fill these in */
-PyObject *StarlarkError;
-PyObject *SyntaxError;
-PyObject *EvalError;
-PyObject *EvalTimeoutError;
-PyObject *ResolveError;
-PyObject *ResolveErrorItem;
-PyObject *ConversionToPythonFailed;
-PyObject *ConversionToStarlarkFailed;
+Py_ssize_t Starlark_mp_length(Starlark *self);
+PyObject *Starlark_mp_subscript(Starlark *self, PyObject *key);
+int Starlark_mp_ass_subscript(Starlark *self, PyObject *key, PyObject *value);
+int Starlark_sq_contains(Starlark *self, PyObject *key);
+PyObject *Starlark_snapshot(Starlark *self, PyObject *_);
+PyObject *Starlark_fork(Starlark *self, PyObject *_);
+PyObject *Starlark_exec_module(Starlark *self, PyObject *args, PyObject *kwargs);
+PyObject *Starlark_eval_many(Starlark *self, PyObject *args, PyObject *kwargs);
+PyObject *Starlark_persistent_state(Starlark *self, PyObject *_);
+PyObject *Starlark_dump(Starlark *self, PyObject *args, PyObject *kwargs);
+PyObject *Starlark_load_state(PyObject *cls, PyObject *args, PyObject *kwargs);
+PyObject *Starlark_reduce(Starlark *self, PyObject *_);
+PyObject *Starlark_clear_globals(Starlark *self, PyObject *_);
+PyObject *Starlark_restore(Starlark *self, PyObject *snapshot);
+PyObject *Starlark_watch(Starlark *self, PyObject *args, PyObject *kwargs);
+PyObject *Starlark_unwatch(Starlark *self, PyObject *callback);
+void StarlarkSnapshot_release(StarlarkSnapshot *self);
+PyObject *StarlarkGo_panic(StarlarkModuleState *state, char *msg);
+PyObject *StarlarkGo_resolveOptions(StarlarkModuleState *state, PyObject *options);
+PyObject *StarlarkGo_runtimeStats(StarlarkModuleState *state);
+PyObject *StarlarkGo_setGOMAXPROCS(StarlarkModuleState *state, Py_ssize_t n);
+PyObject *StarlarkGo_setGCPercent(StarlarkModuleState *state, PyObject *percent);
+PyObject *StarlarkGo_setMemoryLimit(StarlarkModuleState *state, PyObject *limit);
+PyObject *StarlarkGo_runBatch(
+    StarlarkModuleState *state,
+    PyObject *jobs,
+    PyObject *workers,
+    PyObject *print,
+    PyObject *options,
+    double timeout
+);
 
 /* Wrapper for setting Starlark configuration options */
 static char *configure_keywords[] = {
@@ -48,7 +79,11 @@ PyObject *configure_starlark(PyObject *self, PyObject *args, PyObject *kwargs)
     return NULL;
   }
 
-  ConfigureStarlark(allow_set, allow_global_reassign, allow_recursion);
+  ConfigureStarlark(
+      PyModule_GetState(self), allow_set, allow_global_reassign, allow_recursion
+  );
+  if (PyErr_Occurred()) return NULL;
+
   Py_RETURN_NONE;
 }
 
@@ -56,14 +91,14 @@ PyDoc_STRVAR(
     configure_starlark_doc,
     "configure_starlark(*, allow_set=None, allow_global_reassign=None, "
     "allow_recursion=None)\n--\n\n"
-    "Change what features the Starlark interpreter allows. Unfortunately, "
-    "this manipulates global variables, and affects all Starlark interpreters "
-    "in your application. It is not possible to have one Starlark "
-    "interpreter with ``allow_set=True`` and another with ``allow_set=False`` "
-    "simultaneously.\n\n"
-    "All feature flags are initially ``False``.\n\n"
+    "Change the default features that the Starlark interpreter allows. This "
+    "affects all Starlark objects in the current Python interpreter, except for "
+    "options that they set with :class:`FileOptions`. Each subinterpreter has "
+    "its own defaults.\n\n"
+    "All feature flags are initially ``False``. Changes take effect for code that "
+    "is compiled afterwards.\n\n"
     "See the `starlark-go documentation "
-    "<https://pkg.go.dev/go.starlark.net/resolve#pkg-variables>`_ for "
+    "<https://pkg.go.dev/go.starlark.net/syntax#FileOptions>`_ for "
     "more information.\n\n"
     ":param allow_set: If ``True``, allow the creation of `set "
     "<https://github.com/google/starlark-go/blob/master/doc/spec.md#sets=>`_ objects "
@@ -77,14 +112,215 @@ PyDoc_STRVAR(
     ":type allow_recursion:  typing.Optional[bool]\n"
 );
 
+/* Wrappers for inspecting and tuning the Go runtime */
+PyDoc_STRVAR(
+    runtime_stats_doc,
+    "runtime_stats()\n--\n\n"
+    "Get statistics about the Go runtime that runs Starlark. The Go runtime is "
+    "shared by all Starlark objects in the process, including those in other "
+    "Python subinterpreters, but not by the workers of "
+    ":class:`SubprocessStarlark`.\n\n"
+    "The statistics are returned in a dictionary with these keys:\n\n"
+    "- ``goroutines``: the number of goroutines\n"
+    "- ``gomaxprocs``: the value set by :func:`set_gomaxprocs`\n"
+    "- ``gc_percent``: the value set by :func:`set_gc_percent`\n"
+    "- ``memory_limit``: the value set by :func:`set_memory_limit`\n"
+    "- ``num_gc``: the number of completed garbage collections\n"
+    "- ``num_forced_gc``: how many of those were forced rather than triggered by "
+    "the heap growing\n"
+    "- ``gc_pause_total``: the total time in seconds that garbage collection has "
+    "stopped the world for\n"
+    "- ``heap_alloc``: the size in bytes of the objects on the heap, including "
+    "garbage that hasn't been collected yet\n"
+    "- ``heap_sys``: the size in bytes of the memory that the heap has obtained "
+    "from the OS\n"
+    "- ``heap_objects``: the number of objects on the heap\n"
+    "- ``next_gc``: the heap size in bytes at which the next garbage collection "
+    "will start\n"
+    "- ``total_alloc``: the total size in bytes of all objects ever allocated\n"
+    "- ``sys``: the total size in bytes of the memory obtained from the OS\n\n"
+    "See the `Go documentation <https://pkg.go.dev/runtime#MemStats>`_ for more "
+    "information.\n\n"
+    ":rtype: typing.Dict[str, typing.Any]\n"
+);
+
+PyObject *runtime_stats(PyObject *self, PyObject *_)
+{
+  return StarlarkGo_runtimeStats(PyModule_GetState(self));
+}
+
+static char *set_gomaxprocs_keywords[] = {"n", NULL};
+
+PyDoc_STRVAR(
+    set_gomaxprocs_doc,
+    "set_gomaxprocs(n)\n--\n\n"
+    "Set the maximum number of OS threads that can run Go code at the same time, "
+    "like the ``GOMAXPROCS`` environment variable. This does not limit threads "
+    "waiting for the GIL or for Python functions called by Starlark.\n\n"
+    ":param n: The maximum number of threads, which must be at least 1.\n"
+    ":type n: int\n"
+    ":return: The previous maximum.\n"
+    ":rtype: int\n"
+);
+
+PyObject *set_gomaxprocs(PyObject *self, PyObject *args, PyObject *kwargs)
+{
+  /* Necessary because Cgo can't do varargs */
+  Py_ssize_t n = 0;
+
+  if (PyArg_ParseTupleAndKeywords(
+          args, kwargs, "n:set_gomaxprocs", set_gomaxprocs_keywords, &n
+      ) == 0) {
+    return NULL;
+  }
+
+  return StarlarkGo_setGOMAXPROCS(PyModule_GetState(self), n);
+}
+
+static char *set_gc_percent_keywords[] = {"percent", NULL};
+
+PyDoc_STRVAR(
+    set_gc_percent_doc,
+    "set_gc_percent(percent)\n--\n\n"
+    "Set how much the Go heap may grow after a garbage collection before the "
+    "next one starts, like the ``GOGC`` environment variable.\n\n"
+    ":param percent: The growth as a percentage of the heap that was still in "
+    "use after the last garbage collection, or ``None`` to turn the garbage "
+    "collector off except to stay under the limit set by "
+    ":func:`set_memory_limit`.\n"
+    ":type percent: typing.Optional[int]\n"
+    ":return: The previous percentage, or ``None`` if the garbage collector was "
+    "off.\n"
+    ":rtype: typing.Optional[int]\n"
+);
+
+PyObject *set_gc_percent(PyObject *self, PyObject *args, PyObject *kwargs)
+{
+  /* Necessary because Cgo can't do varargs */
+  PyObject *percent = NULL;
+
+  if (PyArg_ParseTupleAndKeywords(
+          args, kwargs, "O:set_gc_percent", set_gc_percent_keywords, &percent
+      ) == 0) {
+    return NULL;
+  }
+
+  return StarlarkGo_setGCPercent(PyModule_GetState(self), percent);
+}
+
+static char *set_memory_limit_keywords[] = {"limit", NULL};
+
+PyDoc_STRVAR(
+    set_memory_limit_doc,
+    "set_memory_limit(limit)\n--\n\n"
+    "Set a soft limit on the memory used by the Go runtime, like the "
+    "``GOMEMLIMIT`` environment variable. The garbage collector runs more often "
+    "as the limit gets closer, but evaluations aren't stopped when it is "
+    "exceeded; use ``max_memory`` with :meth:`Starlark.eval` and "
+    ":meth:`Starlark.exec` for that.\n\n"
+    ":param limit: The limit in bytes, or ``None`` for no limit.\n"
+    ":type limit: typing.Optional[int]\n"
+    ":return: The previous limit, or ``None`` if there wasn't one.\n"
+    ":rtype: typing.Optional[int]\n"
+);
+
+PyObject *set_memory_limit(PyObject *self, PyObject *args, PyObject *kwargs)
+{
+  /* Necessary because Cgo can't do varargs */
+  PyObject *limit = NULL;
+
+  if (PyArg_ParseTupleAndKeywords(
+          args, kwargs, "O:set_memory_limit", set_memory_limit_keywords, &limit
+      ) == 0) {
+    return NULL;
+  }
+
+  return StarlarkGo_setMemoryLimit(PyModule_GetState(self), limit);
+}
+
+/* Wrapper for running many scripts at once */
+static char *run_batch_keywords[] = {
+    "jobs", "workers", "print", "options", "timeout", NULL
+};
+
+PyDoc_STRVAR(
+    run_batch_doc,
+    "run_batch(jobs, *, workers=None, print=None, options=None, timeout=None)\n--\n\n"
+    "Execute many independent Starlark scripts in parallel, and return what "
+    "became of each one, in order.\n\n"
+    "Each job is a ``(source, filename, globals)`` tuple, where ``filename`` and "
+    "``globals`` may be ``None``. It is executed into a new :class:`Starlark` "
+    "object created with its ``globals``, which is returned with the global "
+    "variables the script defined. If the job fails, the exception it raised "
+    "is returned in its place instead.\n\n"
+    "The jobs run on ``workers`` goroutines without the GIL. Python functions "
+    "called by them, including ``print``, take turns to run with the GIL.\n\n"
+    ":param jobs: The scripts to execute.\n"
+    ":type jobs: typing.Iterable[typing.Tuple[str, typing.Optional[str], "
+    "typing.Optional[typing.Mapping[str, typing.Any]]]]\n"
+    ":param workers: How many jobs to run at once. Defaults to the value set by "
+    ":func:`set_gomaxprocs`, which is also the most that run at once: larger "
+    "values are lowered to it, rather than raising an error.\n"
+    ":type workers: typing.Optional[int]\n"
+    ":param print: A function to call in place of Starlark's ``print()`` "
+    "function, for every job.\n"
+    ":type print: typing.Optional[typing.Callable[[str], typing.Any]]\n"
+    ":param options: Options that control which Starlark dialect is accepted, "
+    "for every job.\n"
+    ":type options: typing.Optional[FileOptions]\n"
+    ":param timeout: Maximum number of seconds to allow each job to run. Jobs "
+    "that exceed it fail with :py:class:`EvalTimeoutError`.\n"
+    ":type timeout: typing.Optional[float]\n"
+    ":raises KeyboardInterrupt: if the jobs are interrupted by Ctrl-C\n"
+    ":raises TypeError: if a job isn't a ``(source, filename, globals)`` tuple\n"
+    ":rtype: typing.List[typing.Union[Starlark, StarlarkError]]\n"
+);
+
+PyObject *run_batch(PyObject *self, PyObject *args, PyObject *kwargs)
+{
+  /* Necessary because Cgo can't do varargs */
+  PyObject *jobs = NULL, *workers = NULL, *print = NULL, *options = NULL;
+  double timeout = 0;
+
+  if (PyArg_ParseTupleAndKeywords(
+          args,
+          kwargs,
+          "O|$OOOd:run_batch",
+          run_batch_keywords,
+          &jobs,
+          &workers,
+          &print,
+          &options,
+          &timeout
+      ) == 0) {
+    return NULL;
+  }
+
+  return StarlarkGo_runBatch(
+      PyModule_GetState(self), jobs, workers, print, options, timeout
+  );
+}
+
 /* Argument names and documentation for our methods */
-static char *init_keywords[] = {"globals", "print", NULL};
+static char *init_keywords[] = {
+    "globals",
+    "print",
+    "struct_factory",
+    "coerce_args",
+    "options",
+    "isolation",
+    "memory_limit",
+    "cpu_limit",
+    NULL
+};
 
 PyDoc_STRVAR(
     Starlark_init_doc,
-    "Starlark(*, globals=None, print=None)\n--\n\n"
+    "Starlark(*, globals=None, print=None, struct_factory=None, coerce_args=False, "
+    "options=None, isolation=None, memory_limit=None, cpu_limit=None)\n--\n\n"
     "Create a Starlark object. A Starlark object contains a set of global variables, "
-    "which can be manipulated by executing Starlark code.\n\n"
+    "which can be manipulated by executing Starlark code. A Starlark object can be "
+    "used from several threads at once.\n\n"
     ":param globals: Initial set of global variables. Keys must be strings. Values can "
     "be any type supported by :func:`set`.\n"
     ":type globals: typing.Mapping[str, typing.Any]\n"
@@ -92,13 +328,38 @@ PyDoc_STRVAR(
     "unspecified, Starlark's ``print()`` function will be forwarded to Python's "
     "built-in :py:func:`python:print`.\n"
     ":type print: typing.Callable[[str], typing.Any]\n"
+    ":param struct_factory: A function to call with the fields of a Starlark "
+    "``struct`` or ``module`` as keyword arguments, when converting one to Python. "
+    "If unspecified, :py:class:`python:types.SimpleNamespace` is used.\n"
+    ":type struct_factory: typing.Callable[..., typing.Any]\n"
+    ":param coerce_args: If ``True``, arguments passed from Starlark to Python "
+    "functions are converted to the types in the functions' annotations, as if by "
+    "the ``result_type`` parameter of :meth:`get`. Arguments that don't match raise "
+    "an :py:class:`EvalError`.\n"
+    ":type coerce_args: bool\n"
+    ":param options: Options that control which Starlark dialect is accepted. If "
+    "unspecified, the defaults set by :func:`configure_starlark` are used.\n"
+    ":type options: typing.Optional[FileOptions]\n"
+    ":param isolation: If ``\"subprocess\"``, run Starlark in a separate process "
+    "and return a :py:class:`SubprocessStarlark` rather than a :py:class:`Starlark`.\n"
+    ":type isolation: typing.Optional[str]\n"
+    ":param memory_limit: The maximum address space of the separate process, in "
+    "bytes. Requires ``isolation=\"subprocess\"``.\n"
+    ":type memory_limit: typing.Optional[int]\n"
+    ":param cpu_limit: The maximum CPU time of the separate process, in seconds. "
+    "Requires ``isolation=\"subprocess\"``.\n"
+    ":type cpu_limit: typing.Optional[int]\n"
 );
 
-static char *eval_keywords[] = {"expr", "filename", "convert", "print", "timeout", NULL};
+static char *eval_keywords[] = {
+    "expr", "filename", "convert", "print", "timeout", "max_memory", "result_type",
+    "options", "locals", NULL
+};
 
 PyDoc_STRVAR(
     Starlark_eval_doc,
-    "eval(self, expr, *, filename=None, convert=True, print=None, timeout=None)\n--\n\n"
+    "eval(self, expr, *, filename=None, convert=True, print=None, timeout=None, "
+    "max_memory=None, result_type=None, options=None, locals=None)\n--\n\n"
     "Evaluate a Starlark expression. The expression passed to ``eval`` must evaluate "
     "to a value. Function definitions, variable assignments, and control structures "
     "are not allowed by ``eval``. To use those, please use :meth:`exec`.\n\n"
@@ -117,6 +378,7 @@ PyDoc_STRVAR(
     ":type print: typing.Callable[[str], typing.Any]\n"
     ":raises ConversionToPythonFailed: if the value is of an unsupported type for "
     "conversion.\n"
+    ":raises ConversionToTypeFailed: if the value does not match ``result_type``.\n"
     ":raises EvalError: if there is a Starlark evaluation error\n"
     ":raises EvalTimeoutError: if the evaluation exceeds the specified timeout\n"
     ":raises ResolveError: if there is a Starlark resolution error\n"
@@ -124,19 +386,117 @@ PyDoc_STRVAR(
     ":param timeout: Maximum number of seconds to allow the evaluation to run. "
     "If the evaluation exceeds this time, an :py:class:`EvalTimeoutError` is raised.\n"
     ":type timeout: typing.Optional[float]\n"
+    ":param max_memory: Maximum size in bytes of the Go heap while the evaluation "
+    "runs. The heap is shared by everything Starlark does in this process, so "
+    "this is a ceiling for the process rather than for this evaluation alone. If "
+    "it is exceeded, the evaluation is cancelled and an "
+    ":py:class:`EvalMemoryLimitError` is raised.\n"
+    ":type max_memory: typing.Optional[int]\n"
+    ":raises EvalMemoryLimitError: if the Go heap exceeds ``max_memory``\n"
+    ":raises KeyboardInterrupt: if the evaluation is interrupted by Ctrl-C. The "
+    ":py:class:`EvalError` of the interrupted code is its ``__cause__``.\n"
+    ":param result_type: If specified, convert the result of the expression into "
+    "this type. See :meth:`get` for the supported types. Ignored if ``convert`` is "
+    "False.\n"
+    ":type result_type: typing.Optional[type]\n"
+    ":param options: Options that control which Starlark dialect is accepted, "
+    "overriding those of the Starlark object.\n"
+    ":type options: typing.Optional[FileOptions]\n"
+    ":param locals: Extra variables for this evaluation only, which hide global "
+    "variables of the same name. The global variables aren't changed.\n"
+    ":type locals: typing.Optional[typing.Mapping[str, typing.Any]]\n"
+    ":raises ConversionToStarlarkFailed: if a value in ``locals`` is of an "
+    "unsupported type for conversion.\n"
     ":raises StarlarkError: if there is an unexpected error\n"
     ":rtype: typing.Any\n"
 );
 
-static char *exec_keywords[] = {"defs", "filename", "print", "timeout", NULL};
+static char *eval_many_keywords[] = {
+    "expr",
+    "records",
+    "filename",
+    "convert",
+    "print",
+    "timeout",
+    "max_memory",
+    "result_type",
+    "options",
+    "return_exceptions",
+    NULL
+};
+
+PyDoc_STRVAR(
+    Starlark_eval_many_doc,
+    "eval_many(self, expr, records, *, filename=None, convert=True, print=None, "
+    "timeout=None, max_memory=None, result_type=None, options=None, "
+    "return_exceptions=False)\n--\n\n"
+    "Evaluate a Starlark expression once for each of many records, and return a "
+    "list of the results. Each record is a mapping of extra variables for its "
+    "evaluation, like ``locals`` for :meth:`eval`. The expression is compiled "
+    "once, and evaluated for every record without the GIL, which is much faster "
+    "than calling :meth:`eval` for each one.\n\n"
+    "Records that don't define a variable the expression uses fall back to the "
+    "global variables, and raise :py:class:`ResolveError` if there is none.\n\n"
+    ":param expr: A string containing a Starlark expression to evaluate\n"
+    ":type expr: str\n"
+    ":param records: The variables for each evaluation.\n"
+    ":type records: typing.Iterable[typing.Mapping[str, typing.Any]]\n"
+    ":param filename: An optional filename to use in exceptions.\n"
+    ":type filename: typing.Optional[str]\n"
+    ":param convert: If False, return the Starlark representation of each result "
+    "instead of converting it. Defaults to True.\n"
+    ":type convert: bool\n"
+    ":param print: A function to call in place of Starlark's ``print()`` function.\n"
+    ":type print: typing.Callable[[str], typing.Any]\n"
+    ":param timeout: Maximum number of seconds to allow all the evaluations to "
+    "run, together.\n"
+    ":type timeout: typing.Optional[float]\n"
+    ":param max_memory: Maximum size in bytes of the Go heap while the "
+    "evaluations run.\n"
+    ":type max_memory: typing.Optional[int]\n"
+    ":param result_type: If specified, convert each result into this type. See "
+    ":meth:`get` for the supported types.\n"
+    ":type result_type: typing.Optional[type]\n"
+    ":param options: Options that control which Starlark dialect is accepted, "
+    "overriding those of the Starlark object.\n"
+    ":type options: typing.Optional[FileOptions]\n"
+    ":param return_exceptions: If True, an exception raised for a record is put "
+    "in the list in place of its result, rather than raised. Exceptions that "
+    "stop every record, like :py:class:`EvalTimeoutError`, are still raised.\n"
+    ":type return_exceptions: bool\n"
+    ":raises ConversionToStarlarkFailed: if a record can't be converted to "
+    "Starlark\n"
+    ":raises ConversionToPythonFailed: if a result can't be converted to Python\n"
+    ":raises EvalError: if there is a Starlark evaluation error\n"
+    ":raises EvalTimeoutError: if the evaluations exceed the specified timeout\n"
+    ":raises EvalMemoryLimitError: if the Go heap exceeds ``max_memory``\n"
+    ":raises ResolveError: if there is a Starlark resolution error\n"
+    ":raises SyntaxError: if there is a Starlark syntax error\n"
+    ":raises StarlarkError: if there is an unexpected error\n"
+    ":rtype: typing.List[typing.Any]\n"
+);
+
+static char *exec_keywords[] = {
+    "defs",
+    "filename",
+    "print",
+    "timeout",
+    "max_memory",
+    "options",
+    "return_result",
+    "convert_values",
+    NULL
+};
 
 PyDoc_STRVAR(
     Starlark_exec_doc,
-    "exec(self, defs, *, filename=None, print=None, timeout=None)\n--\n\n"
+    "exec(self, defs, *, filename=None, print=None, timeout=None, max_memory=None, "
+    "options=None, return_result=False, convert_values=False)"
+    "\n--\n\n"
     "Execute Starlark code. All legal Starlark constructs may be used with "
     "``exec``.\n\n"
-    "``exec`` does not return a value. To evaluate the value of a Starlark expression, "
-    "please use func:`eval`.\n\n"
+    "``exec`` does not return a value unless ``return_result`` is true. To "
+    "evaluate the value of a Starlark expression, please use func:`eval`.\n\n"
     ":param defs: A string containing Starlark code to execute\n"
     ":type defs: str\n"
     ":param filename: An optional filename to use in exceptions, if evaluting the "
@@ -153,14 +513,107 @@ PyDoc_STRVAR(
     ":param timeout: Maximum number of seconds to allow the execution to run. "
     "If the execution exceeds this time, an :py:class:`EvalTimeoutError` is raised.\n"
     ":type timeout: typing.Optional[float]\n"
+    ":param max_memory: Maximum size in bytes of the Go heap while the execution "
+    "runs. The heap is shared by everything Starlark does in this process, so "
+    "this is a ceiling for the process rather than for this execution alone. If "
+    "it is exceeded, the execution is cancelled and an "
+    ":py:class:`EvalMemoryLimitError` is raised.\n"
+    ":type max_memory: typing.Optional[int]\n"
+    ":raises EvalMemoryLimitError: if the Go heap exceeds ``max_memory``\n"
+    ":raises KeyboardInterrupt: if the execution is interrupted by Ctrl-C. The "
+    ":py:class:`EvalError` of the interrupted code is its ``__cause__``.\n"
+    ":param options: Options that control which Starlark dialect is accepted, "
+    "overriding those of the Starlark object.\n"
+    ":type options: typing.Optional[FileOptions]\n"
+    ":param return_result: If true, return an :py:class:`ExecResult` describing "
+    "the global variables the code defined.\n"
+    ":type return_result: bool\n"
+    ":param convert_values: If true, and ``return_result`` is true, convert the "
+    "values of the variables the code defined to Python for "
+    ":py:attr:`ExecResult.values`. Values that can't be converted, like "
+    "functions, are given as their Starlark representation.\n"
+    ":type convert_values: bool\n"
+    ":raises StarlarkError: if there is an unexpected error\n"
+    ":rtype: typing.Optional[ExecResult]\n"
+);
+
+static char *exec_module_keywords[] = {
+    "source", "filename", "print", "timeout", "max_memory", "options", NULL
+};
+
+PyDoc_STRVAR(
+    Starlark_exec_module_doc,
+    "exec_module(self, source, *, filename=None, print=None, timeout=None, "
+    "max_memory=None, options=None)"
+    "\n--\n\n"
+    "Execute Starlark code as a separate module, like a file loaded with "
+    "``load()``. The global variables of this object are predeclared for the "
+    "code, so it can use them but not change them, and the variables it defines "
+    "are returned in a new :class:`Starlark` object rather than added to this "
+    "one. Many modules can be executed against the same globals without "
+    "interfering with each other.\n\n"
+    "The new object has the same ``print``, ``struct_factory`` and options as "
+    "this one, and only has the variables the code defined. It is a mapping, so "
+    "``dict()`` converts it to a dictionary, and functions the code defined can "
+    "still be called with :meth:`eval`.\n\n"
+    ":param source: A string containing Starlark code to execute\n"
+    ":type source: str\n"
+    ":param filename: An optional filename to use in exceptions.\n"
+    ":type filename: Optional[str]\n"
+    ":param print: A function to call in place of Starlark's ``print()`` function.\n"
+    ":type print: typing.Callable[[str], typing.Any]\n"
+    ":param timeout: Maximum number of seconds to allow the execution to run.\n"
+    ":type timeout: typing.Optional[float]\n"
+    ":param max_memory: Maximum size in bytes of the Go heap while the execution "
+    "runs.\n"
+    ":type max_memory: typing.Optional[int]\n"
+    ":param options: Options that control which Starlark dialect is accepted, "
+    "overriding those of the Starlark object.\n"
+    ":type options: typing.Optional[FileOptions]\n"
+    ":raises EvalError: if there is a Starlark evaluation error\n"
+    ":raises EvalTimeoutError: if the execution exceeds the specified timeout\n"
+    ":raises EvalMemoryLimitError: if the Go heap exceeds ``max_memory``\n"
+    ":raises ResolveError: if there is a Starlark resolution error\n"
+    ":raises SyntaxError: if there is a Starlark syntax error\n"
     ":raises StarlarkError: if there is an unexpected error\n"
+    ":rtype: Starlark\n"
 );
 
-static char *get_global_keywords[] = {"name", "default", NULL};
+static char *get_global_keywords[] = {"name", "default", "result_type", NULL};
+
+static char *pop_global_keywords[] = {"name", "default", NULL};
+
+static char *watch_keywords[] = {"callback", "names", NULL};
+
+PyDoc_STRVAR(
+    Starlark_watch_doc,
+    "watch(self, callback, names = None)\n--\n\n"
+    "Call ``callback`` whenever a global variable changes, whether by "
+    ":meth:`set`, :meth:`pop`, :meth:`exec`, :meth:`clear` or :meth:`restore`. "
+    "It's called with the name of the variable, its old value and its new "
+    "value, once for each variable that changed, after the change has been "
+    "made. Variables that didn't exist are passed as ``None``, and values that "
+    "can't be converted to Python, like Starlark functions, are passed as "
+    "their Starlark representation.\n\n"
+    "Exceptions raised by ``callback`` are reported with "
+    ":py:func:`python:sys.unraisablehook`, since the change can't be undone.\n\n"
+    ":param callback: A function to call with the name, old value and new value.\n"
+    ":type callback: typing.Callable[[str, typing.Any, typing.Any], typing.Any]\n"
+    ":param names: If given, only call ``callback`` for these variables.\n"
+    ":type names: typing.Optional[typing.Iterable[str]]\n"
+);
+
+PyDoc_STRVAR(
+    Starlark_unwatch_doc,
+    "unwatch(self, callback)\n--\n\n"
+    "Stop calling a callback registered with :meth:`watch`.\n\n"
+    ":param callback: The callback to remove.\n"
+    ":raises ValueError: if ``callback`` isn't watching this object.\n"
+);
 
 PyDoc_STRVAR(
     Starlark_get_doc,
-    "get(self, name, default_value = ...)\n--\n\n"
+    "get(self, name, default_value = ..., *, result_type=None)\n--\n\n"
     "Get the value of a Starlark global variable.\n\n"
     "Conversion from most Starlark data types is supported:\n\n"
     "* Starlark `None <https://pkg.go.dev/go.starlark.net/starlark#None>`_ to "
@@ -184,19 +637,44 @@ PyDoc_STRVAR(
     "* Starlark `set <https://pkg.go.dev/go.starlark.net/starlark#Set>`_ to "
     "Python :py:obj:`python:set`\n"
     "* Starlark `tuple <https://pkg.go.dev/go.starlark.net/starlark#Tuple>`_ to "
-    "Python :py:obj:`python:tuple`\n\n"
-    "For the aggregate types (``dict``, ``list``, ``set``, and ``tuple``,) all keys "
-    "and/or values must also be one of the supported types.\n\n"
+    "Python :py:obj:`python:tuple`\n"
+    "* Starlark `struct "
+    "<https://pkg.go.dev/go.starlark.net/starlarkstruct#Struct>`_ and `module "
+    "<https://pkg.go.dev/go.starlark.net/starlarkstruct#Module>`_ to Python "
+    ":py:class:`python:types.SimpleNamespace` (or the result of calling "
+    ":py:attr:`struct_factory`)\n\n"
+    "For the aggregate types (``dict``, ``list``, ``set``, ``tuple``, and "
+    "``struct``,) all keys and/or values must also be one of the supported types.\n\n"
     "Attempting to get the value of any other Starlark type will raise a "
     ":py:class:`ConversionToPythonFailed`.\n\n"
+    "If ``result_type`` is specified, the value is converted into that type "
+    "instead. The supported types are:\n\n"
+    "* :py:obj:`python:None`, :py:obj:`python:bool`, :py:obj:`python:bytes`, "
+    ":py:obj:`python:float` (which also accepts Starlark ``int``,) "
+    ":py:obj:`python:int`, and :py:obj:`python:str`\n"
+    "* ``list[T]``, ``set[T]``, ``dict[K, V]``, ``tuple[T, ...]``, and "
+    "``tuple[T1, T2]``\n"
+    "* ``typing.Optional[T]`` and other unions, where the first matching "
+    "type is used\n"
+    "* :py:mod:`dataclasses <python:dataclasses>`, which can be converted from a "
+    "Starlark ``dict`` or ``struct``\n"
+    "* :py:class:`typing.TypedDict <python:typing.TypedDict>`, which can be "
+    "converted from a Starlark ``dict``\n"
+    "* :py:obj:`typing.Any <python:typing.Any>` and :py:obj:`python:object`, "
+    "which use the conversions above\n\n"
+    "If the value does not match ``result_type``, a "
+    ":py:class:`ConversionToTypeFailed` listing every mismatch is raised.\n\n"
     ":param name: The name of the global variable.\n"
     ":type name: str\n"
     ":param default_value: A default value to return, if no global variable named "
     "``name`` is defined.\n"
     ":type default_value: typing.Any\n"
+    ":param result_type: If specified, convert the value into this type.\n"
+    ":type result_type: typing.Optional[type]\n"
     ":raises KeyError: if there is no global value named ``name`` defined.\n"
     ":raises ConversionToPythonFailed: if the value is of an unsupported type for "
     "conversion.\n"
+    ":raises ConversionToTypeFailed: if the value does not match ``result_type``.\n"
     ":rtype: typing.Any\n"
 );
 
@@ -235,7 +713,19 @@ PyDoc_STRVAR(
     "<https://pkg.go.dev/go.starlark.net/starlark#Set>`_\n"
     "* Python :py:obj:`python:tuple` to Starlark `tuple "
     "<https://pkg.go.dev/go.starlark.net/starlark#Tuple>`_\n"
-    "* Python functions can be registered as a Starlark function directly. "
+    "* Python :py:mod:`dataclasses <python:dataclasses>`, "
+    ":py:func:`named tuples <python:collections.namedtuple>`, and "
+    ":py:class:`python:types.SimpleNamespace` to Starlark `struct "
+    "<https://pkg.go.dev/go.starlark.net/starlarkstruct#Struct>`_\n"
+    "* Python callables (functions, methods, built-in functions, "
+    ":py:func:`functools.partial <python:functools.partial>` objects, classes, and "
+    "objects with a ``__call__`` method) can be registered as a Starlark function "
+    "directly. The function is named after the callable's ``__qualname__``, or its "
+    "``repr()`` if it doesn't have one. "
+    "If the callable's signature is available from :py:func:`inspect.signature "
+    "<python:inspect.signature>`, calls from Starlark are checked against it "
+    "before the callable is called, and mismatches are reported at the Starlark "
+    "call site. "
     "Any exceptions raised will be rethrown as :py:class:`EvalError`.\n"
     "\n"
     "For the aggregate types (``dict``, ``list``, ``set``, and ``tuple``,) all keys "
@@ -246,6 +736,102 @@ PyDoc_STRVAR(
     "conversion.\n"
 );
 
+PyDoc_STRVAR(
+    Starlark_clear_globals_doc,
+    "clear(self)\n--\n\n"
+    "Remove all global variables.\n"
+);
+
+PyDoc_STRVAR(
+    Starlark_snapshot_doc,
+    "snapshot(self)\n--\n\n"
+    "Take a snapshot of the global variables, which :meth:`restore` can roll "
+    "back to later. Starlark values are frozen once they are global variables, "
+    "so this is cheap: nothing is copied.\n\n"
+    ":rtype: StarlarkSnapshot\n"
+);
+
+PyDoc_STRVAR(
+    Starlark_restore_doc,
+    "restore(self, snapshot)\n--\n\n"
+    "Replace the global variables with those in a snapshot taken by "
+    ":meth:`snapshot`. Variables defined since then are removed. The snapshot "
+    "can be restored again later.\n\n"
+    ":param snapshot: A snapshot taken from this object.\n"
+    ":type snapshot: StarlarkSnapshot\n"
+    ":raises TypeError: if ``snapshot`` isn't a :class:`StarlarkSnapshot`\n"
+    ":raises ValueError: if ``snapshot`` was taken from another object\n"
+);
+
+PyDoc_STRVAR(
+    Starlark_fork_doc,
+    "fork(self)\n--\n\n"
+    "Create a new Starlark object with the same global variables, ``print``, "
+    "``struct_factory`` and options as this one. Starlark values are frozen once "
+    "they are global variables, so the two share them rather than copying them, "
+    "and forking is cheap however much code has been executed. Changes to the "
+    "global variables of either object afterwards don't affect the other's, but "
+    "functions defined before the fork still look up the variables that earlier "
+    "calls to :meth:`exec` defined in this object, and see its changes to them.\n\n"
+    "The new object keeps its own references to the Python objects used by the "
+    "global variables, so it remains usable if this one is deleted.\n\n"
+    ":rtype: Starlark\n"
+);
+
+PyDoc_STRVAR(
+    Starlark_copy_doc,
+    "__copy__(self)\n--\n\n"
+    "Support :py:func:`python:copy.copy`, which is the same as :meth:`fork`.\n"
+);
+
+PyDoc_STRVAR(
+    Starlark_dump_doc,
+    "dump(self, fp)\n--\n\n"
+    "Save the global variables and options to a binary file, so that "
+    ":meth:`load_state` can recreate an equivalent object later, for example "
+    "after a restart. The file is written with :py:mod:`python:pickle`.\n\n"
+    "Global variables that can be converted to Python are saved as Python "
+    "values. Those that can't, like Starlark functions, are saved as the code "
+    "that :meth:`exec` ran to define them, which is run again when loading. "
+    "That code runs against the saved values of the other global variables, "
+    "rather than the values they had when it first ran, and its side effects, "
+    "like calls to Python functions, happen again. ``print`` and "
+    "``struct_factory`` aren't saved.\n\n"
+    ":param fp: A file opened for writing in binary mode.\n"
+    ":type fp: typing.BinaryIO\n"
+    ":raises ConversionToPythonFailed: if a global variable can't be converted "
+    "to Python and wasn't defined by :meth:`exec`\n"
+);
+
+PyDoc_STRVAR(
+    Starlark_load_state_doc,
+    "load_state(cls, fp, **kwargs)\n--\n\n"
+    "Create a Starlark object from a file written by :meth:`dump`. The code that "
+    "defined functions and other values that couldn't be converted to Python is "
+    "run again, without calling ``print``, against the saved values of the other "
+    "global variables. Once it has run, the global variables are set to the "
+    "saved values again.\n\n"
+    "The file is read with :py:mod:`python:pickle`, and the Starlark code saved "
+    "in it is run, so only load files that you trust.\n\n"
+    ":param fp: A file opened for reading in binary mode.\n"
+    ":type fp: typing.BinaryIO\n"
+    ":param kwargs: Other arguments for the object, like ``print`` and "
+    "``struct_factory``.\n"
+    ":rtype: Starlark\n"
+);
+
+PyDoc_STRVAR(
+    Starlark_reduce_doc,
+    "__reduce__(self)\n--\n\n"
+    "Support :py:mod:`python:pickle`, saving the same state as :meth:`dump`.\n"
+);
+
+PyDoc_STRVAR(
+    Starlark_persistent_state_doc,
+    "_persistent_state(self)\n--\n\n"
+    "Get what :meth:`dump` saves besides the global variables and options.\n"
+);
+
 PyDoc_STRVAR(
     Starlark_pop_doc,
     "pop(self, name, default_value = ...)\n--\n\n"
@@ -273,11 +859,58 @@ PyDoc_STRVAR(
 );
 
 /* Container for module methods */
+PyDoc_STRVAR(
+    starlark_panic_doc,
+    "_panic(msg)\n--\n\n"
+    "Panic in Go with the given message. This is only meant for testing that "
+    "panics are raised as :py:class:`StarlarkInternalError`.\n"
+);
+
+PyObject *starlark_panic(PyObject *self, PyObject *msg)
+{
+  const char *cmsg = PyUnicode_AsUTF8(msg);
+  if (cmsg == NULL) return NULL;
+
+  return StarlarkGo_panic(PyModule_GetState(self), (char *)cmsg);
+}
+
+PyDoc_STRVAR(
+    starlark_resolve_options_doc,
+    "_resolve_options(options)\n--\n\n"
+    "Return the options that ``options`` results in, with none left unset. This "
+    "is only meant for :py:class:`SubprocessStarlark`, whose worker doesn't share "
+    "the defaults set by :func:`configure_starlark`.\n"
+);
+
+PyObject *starlark_resolve_options(PyObject *self, PyObject *options)
+{
+  return StarlarkGo_resolveOptions(PyModule_GetState(self), options);
+}
+
 static PyMethodDef module_methods[] = {
     {"configure_starlark",
      (PyCFunction)configure_starlark,
      METH_VARARGS | METH_KEYWORDS,
      configure_starlark_doc},
+    {"runtime_stats", (PyCFunction)runtime_stats, METH_NOARGS, runtime_stats_doc},
+    {"set_gomaxprocs",
+     (PyCFunction)set_gomaxprocs,
+     METH_VARARGS | METH_KEYWORDS,
+     set_gomaxprocs_doc},
+    {"set_gc_percent",
+     (PyCFunction)set_gc_percent,
+     METH_VARARGS | METH_KEYWORDS,
+     set_gc_percent_doc},
+    {"set_memory_limit",
+     (PyCFunction)set_memory_limit,
+     METH_VARARGS | METH_KEYWORDS,
+     set_memory_limit_doc},
+    {"run_batch", (PyCFunction)run_batch, METH_VARARGS | METH_KEYWORDS, run_batch_doc},
+    {"_panic", (PyCFunction)starlark_panic, METH_O, starlark_panic_doc},
+    {"_resolve_options",
+     (PyCFunction)starlark_resolve_options,
+     METH_O,
+     starlark_resolve_options_doc},
     {NULL} /* Sentinel */
 };
 
@@ -304,45 +937,215 @@ static PyMethodDef StarlarkGo_methods[] = {
      (PyCFunction)Starlark_pop_global,
      METH_VARARGS | METH_KEYWORDS,
      Starlark_pop_doc},
+    {"clear", (PyCFunction)Starlark_clear_globals, METH_NOARGS, Starlark_clear_globals_doc},
+    {"snapshot", (PyCFunction)Starlark_snapshot, METH_NOARGS, Starlark_snapshot_doc},
+    {"restore", (PyCFunction)Starlark_restore, METH_O, Starlark_restore_doc},
+    {"watch", (PyCFunction)Starlark_watch, METH_VARARGS | METH_KEYWORDS, Starlark_watch_doc},
+    {"unwatch", (PyCFunction)Starlark_unwatch, METH_O, Starlark_unwatch_doc},
+    {"fork", (PyCFunction)Starlark_fork, METH_NOARGS, Starlark_fork_doc},
+    {"eval_many",
+     (PyCFunction)Starlark_eval_many,
+     METH_VARARGS | METH_KEYWORDS,
+     Starlark_eval_many_doc},
+    {"exec_module",
+     (PyCFunction)Starlark_exec_module,
+     METH_VARARGS | METH_KEYWORDS,
+     Starlark_exec_module_doc},
+    {"__copy__", (PyCFunction)Starlark_fork, METH_NOARGS, Starlark_copy_doc},
+    {"dump", (PyCFunction)Starlark_dump, METH_VARARGS | METH_KEYWORDS, Starlark_dump_doc},
+    {"load_state",
+     (PyCFunction)Starlark_load_state,
+     METH_VARARGS | METH_KEYWORDS | METH_CLASS,
+     Starlark_load_state_doc},
+    {"__reduce__", (PyCFunction)Starlark_reduce, METH_NOARGS, Starlark_reduce_doc},
+    {"_persistent_state",
+     (PyCFunction)Starlark_persistent_state,
+     METH_NOARGS,
+     Starlark_persistent_state_doc},
     {NULL} /* Sentinel */
 };
 
+PyDoc_STRVAR(
+    Starlark_struct_factory_doc,
+    "A function to call with the fields of a Starlark ``struct`` or ``module`` as "
+    "keyword arguments, when converting one to Python. If unspecified, "
+    ":py:class:`python:types.SimpleNamespace` is used.\n\n"
+    ":type: typing.Callable[..., typing.Any]\n"
+);
+
+PyDoc_STRVAR(
+    Starlark_options_doc,
+    "Options that control which Starlark dialect is accepted. Options that aren't "
+    "set are taken from the defaults set by :func:`configure_starlark`.\n\n"
+    ":type: FileOptions\n"
+);
+
 static PyGetSetDef Starlark_getset[] = {
     {"print",
      (getter)Starlark_get_print,
      (setter)Starlark_set_print,
      Starlark_print_doc,
      NULL},
+    {"struct_factory",
+     (getter)Starlark_get_struct_factory,
+     (setter)Starlark_set_struct_factory,
+     Starlark_struct_factory_doc,
+     NULL},
+    {"options",
+     (getter)Starlark_get_options,
+     (setter)Starlark_set_options,
+     Starlark_options_doc,
+     NULL},
     {NULL},
 };
 
-/* Python type for object */
-static PyTypeObject StarlarkType = {
-    // clang-format off
-    PyVarObject_HEAD_INIT(NULL, 0)
-    .tp_name = "starlark_go.starlark_go.Starlark",
-    // clang-format on
-    .tp_doc = Starlark_init_doc,
-    .tp_basicsize = sizeof(Starlark),
-    .tp_itemsize = 0,
-    .tp_flags = Py_TPFLAGS_DEFAULT | Py_TPFLAGS_BASETYPE,
-    .tp_new = (newfunc)Starlark_new,
-    .tp_init = (initproc)Starlark_init,
-    .tp_dealloc = (destructor)Starlark_dealloc,
-    .tp_methods = StarlarkGo_methods,
-    .tp_iter = (getiterfunc)Starlark_tp_iter,
-    .tp_getset = Starlark_getset,
+static PyMemberDef Starlark_members[] = {
+    {"__weaklistoffset__", T_PYSSIZET, offsetof(Starlark, weakreflist), READONLY},
+    {NULL},
+};
+
+/* Python type for snapshots, which can only be created by Starlark.snapshot */
+PyDoc_STRVAR(
+    StarlarkSnapshot_doc,
+    "A snapshot of the global variables of a :class:`Starlark` object, taken by "
+    ":meth:`Starlark.snapshot`. It can only be restored into the object it was "
+    "taken from, which it keeps alive.\n"
+);
+
+static int StarlarkSnapshot_traverse(StarlarkSnapshot *self, visitproc visit, void *arg)
+{
+  Py_VISIT(Py_TYPE(self));
+  Py_VISIT(self->owner);
+  return 0;
+}
+
+static int StarlarkSnapshot_clear(StarlarkSnapshot *self)
+{
+  Py_CLEAR(self->owner);
+  return 0;
+}
+
+static void StarlarkSnapshot_dealloc(StarlarkSnapshot *self)
+{
+  PyTypeObject *type = Py_TYPE(self);
+
+  PyObject_GC_UnTrack(self);
+  StarlarkSnapshot_release(self);
+  Py_CLEAR(self->owner);
+  type->tp_free((PyObject *)self);
+
+  /* Instances of heap types own a reference to their type */
+  Py_DECREF(type);
+}
+
+static PyType_Slot StarlarkSnapshot_slots[] = {
+    {Py_tp_doc, (void *)StarlarkSnapshot_doc},
+    {Py_tp_dealloc, StarlarkSnapshot_dealloc},
+    {Py_tp_traverse, StarlarkSnapshot_traverse},
+    {Py_tp_clear, StarlarkSnapshot_clear},
+    {0, NULL},
+};
+
+static PyType_Spec StarlarkSnapshot_spec = {
+    .name = "starlark_go.starlark_go.StarlarkSnapshot",
+    .basicsize = sizeof(StarlarkSnapshot),
+    .itemsize = 0,
+    .flags = Py_TPFLAGS_DEFAULT | Py_TPFLAGS_HAVE_GC |
+             Py_TPFLAGS_DISALLOW_INSTANTIATION,
+    .slots = StarlarkSnapshot_slots,
+};
+
+/* Python type for object. Each interpreter creates its own from this spec. */
+static PyType_Slot Starlark_slots[] = {
+    {Py_tp_doc, (void *)Starlark_init_doc},
+    {Py_tp_new, Starlark_new},
+    {Py_tp_init, Starlark_init},
+    {Py_tp_dealloc, Starlark_dealloc},
+    {Py_tp_traverse, Starlark_traverse},
+    {Py_tp_clear, Starlark_clear},
+    {Py_tp_methods, StarlarkGo_methods},
+    {Py_tp_members, Starlark_members},
+    {Py_tp_iter, Starlark_tp_iter},
+    {Py_mp_length, Starlark_mp_length},
+    {Py_mp_subscript, Starlark_mp_subscript},
+    {Py_mp_ass_subscript, Starlark_mp_ass_subscript},
+    {Py_sq_contains, Starlark_sq_contains},
+    {Py_tp_getset, Starlark_getset},
+    {0, NULL},
+};
+
+static PyType_Spec Starlark_spec = {
+    .name = "starlark_go.starlark_go.Starlark",
+    .basicsize = sizeof(Starlark),
+    .itemsize = 0,
+    .flags = Py_TPFLAGS_DEFAULT | Py_TPFLAGS_BASETYPE | Py_TPFLAGS_HAVE_GC,
+    .slots = Starlark_slots,
 };
 
 /* Module */
+static PyObject *get_module_attr(const char *module, const char *name);
+static int starlark_go_exec(PyObject *m);
+static int starlark_go_traverse(PyObject *m, visitproc visit, void *arg);
+static int starlark_go_clear(PyObject *m);
+static void starlark_go_free(void *m);
+
+static PyModuleDef_Slot starlark_go_slots[] = {
+    {Py_mod_exec, starlark_go_exec},
+#ifdef Py_mod_multiple_interpreters
+    {Py_mod_multiple_interpreters, Py_MOD_PER_INTERPRETER_GIL_SUPPORTED},
+#endif
+#ifdef Py_mod_gil
+    /* Starlark runs with the thread state detached, and everything shared
+       between threads is guarded by Go mutexes, so we don't need the GIL */
+    {Py_mod_gil, Py_MOD_GIL_NOT_USED},
+#endif
+    {0, NULL},
+};
+
 static PyModuleDef starlark_go = {
     PyModuleDef_HEAD_INIT,
     .m_name = "starlark_go.starlark_go",
     .m_doc = "Interface to starlark-go",
-    .m_size = -1,
+    .m_size = sizeof(StarlarkModuleState),
     .m_methods = module_methods,
+    .m_slots = starlark_go_slots,
+    .m_traverse = starlark_go_traverse,
+    .m_clear = starlark_go_clear,
+    .m_free = starlark_go_free,
 };
 
+/* Find the module state for our type, or a subclass of it */
+StarlarkModuleState *starlarkModuleState(PyTypeObject *type)
+{
+#if PY_VERSION_HEX >= 0x030B0000
+  PyObject *m = PyType_GetModuleByDef(type, &starlark_go);
+#else
+  /* PyType_GetModuleByDef is new in 3.11, so do what it does */
+  PyObject *m = NULL;
+  PyObject *mro = type->tp_mro;
+
+  for (Py_ssize_t i = 0; mro != NULL && i < PyTuple_GET_SIZE(mro); i++) {
+    PyTypeObject *base = (PyTypeObject *)PyTuple_GET_ITEM(mro, i);
+    if (!PyType_HasFeature(base, Py_TPFLAGS_HEAPTYPE)) continue;
+
+    PyObject *module = ((PyHeapTypeObject *)base)->ht_module;
+    if (module != NULL && PyModule_GetDef(module) == &starlark_go) {
+      m = module;
+      break;
+    }
+  }
+
+  if (m == NULL) {
+    PyErr_Format(
+        PyExc_TypeError, "%s is not a subclass of Starlark", type->tp_name
+    );
+  }
+#endif
+
+  if (m == NULL) return NULL;
+  return (StarlarkModuleState *)PyModule_GetState(m);
+}
+
 /* Helpers to allocate and free our object */
 Starlark *starlarkAlloc(PyTypeObject *type)
 {
@@ -353,18 +1156,125 @@ Starlark *starlarkAlloc(PyTypeObject *type)
 void starlarkFree(Starlark *self)
 {
   /* Necessary because Cgo can't do function pointers */
-  Py_TYPE(self)->tp_free((PyObject *)self);
+  PyTypeObject *type = Py_TYPE(self);
+  type->tp_free((PyObject *)self);
+
+  /* Instances of heap types own a reference to their type */
+  Py_DECREF(type);
+}
+
+StarlarkSnapshot *starlarkSnapshotAlloc(PyTypeObject *type)
+{
+  /* Necessary because Cgo can't do function pointers */
+  return (StarlarkSnapshot *)type->tp_alloc(type, 0);
+}
+
+StarlarkSnapshot *asStarlarkSnapshot(StarlarkModuleState *state, PyObject *obj)
+{
+  /* Necessary because Cgo can't do macros */
+  if (!PyObject_TypeCheck(obj, state->StarlarkSnapshotType)) {
+    PyErr_Format(
+        PyExc_TypeError,
+        "expected a StarlarkSnapshot, got %s",
+        Py_TYPE(obj)->tp_name
+    );
+    return NULL;
+  }
+
+  return (StarlarkSnapshot *)obj;
+}
+
+/* Whether Python would raise KeyboardInterrupt in this thread on SIGINT:
+ * handlers only run on the main thread of the main interpreter, and only if
+ * SIGINT hasn't been ignored or reset to the default */
+int isInterruptible(void)
+{
+  PyOS_sighandler_t handler = PyOS_getsig(SIGINT);
+  if (handler == SIG_IGN || handler == SIG_DFL) return 0;
+
+  if (PyInterpreterState_Get() != PyInterpreterState_Main()) return 0;
+
+  PyObject *main_thread = get_module_attr("threading", "main_thread");
+  if (main_thread == NULL) {
+    PyErr_Clear();
+    return 0;
+  }
+
+  PyObject *thread = PyObject_CallNoArgs(main_thread);
+  Py_DECREF(main_thread);
+  if (thread == NULL) {
+    PyErr_Clear();
+    return 0;
+  }
+
+  PyObject *ident = PyObject_GetAttrString(thread, "ident");
+  Py_DECREF(thread);
+  if (ident == NULL) {
+    PyErr_Clear();
+    return 0;
+  }
+
+  unsigned long main_ident = PyLong_AsUnsignedLong(ident);
+  Py_DECREF(ident);
+  if (PyErr_Occurred()) {
+    PyErr_Clear();
+    return 0;
+  }
+
+  return main_ident == PyThread_get_thread_ident();
+}
+
+int cgoVisit(visitproc visit, PyObject *obj, void *arg)
+{
+  /* Necessary because Cgo can't do function pointers */
+  Py_VISIT(obj);
+  return 0;
+}
+
+PyObject *cgoPy_TYPE(Starlark *self)
+{
+  /* Necessary because Cgo can't do macros */
+  return (PyObject *)Py_TYPE(self);
+}
+
+/* The calling thread's state, or NULL if it has none */
+PyThreadState *currentThreadState(void)
+{
+#if PY_VERSION_HEX >= 0x030D0000
+  return PyThreadState_GetUnchecked();
+#else
+  return _PyThreadState_UncheckedGet();
+#endif
 }
 
 /* Helpers to parse method arguments */
 int parseInitArgs(
-    PyObject *args, PyObject *kwargs, PyObject **globals, PyObject **print
+    PyObject *args,
+    PyObject *kwargs,
+    PyObject **globals,
+    PyObject **print,
+    PyObject **struct_factory,
+    int *coerce_args,
+    PyObject **options
 )
 {
   /* Necessary because Cgo can't do varargs */
-  /* One optional object */
+  /* Three optional objects, an optional bool and another optional object. The
+   * isolation arguments have already been handled by Starlark_new. */
+  PyObject *isolation = NULL, *memory_limit = NULL, *cpu_limit = NULL;
   return PyArg_ParseTupleAndKeywords(
-      args, kwargs, "|$OO:Starlark", init_keywords, globals, print
+      args,
+      kwargs,
+      "|$OOOpOOOO:Starlark",
+      init_keywords,
+      globals,
+      print,
+      struct_factory,
+      coerce_args,
+      options,
+      &isolation,
+      &memory_limit,
+      &cpu_limit
   );
 }
 
@@ -375,36 +1285,135 @@ int parseEvalArgs(
     char **filename,
     unsigned int *convert,
     PyObject **print,
-    double *timeout
+    double *timeout,
+    Py_ssize_t *max_memory,
+    PyObject **result_type,
+    PyObject **options,
+    PyObject **locals
 )
 {
   /* Necessary because Cgo can't do varargs */
   /* One required string, folloed by an optional string and an optional bool */
   return PyArg_ParseTupleAndKeywords(
-      args, kwargs, "s|$spOd:eval", eval_keywords, expr, filename, convert, print, timeout
+      args,
+      kwargs,
+      "s|$spOdnOOO:eval",
+      eval_keywords,
+      expr,
+      filename,
+      convert,
+      print,
+      timeout,
+      max_memory,
+      result_type,
+      options,
+      locals
   );
 }
 
 int parseExecArgs(
-    PyObject *args, PyObject *kwargs, char **defs, char **filename, PyObject **print,
-    double *timeout
+    PyObject *args,
+    PyObject *kwargs,
+    char **defs,
+    char **filename,
+    PyObject **print,
+    double *timeout,
+    Py_ssize_t *max_memory,
+    PyObject **options,
+    int *return_result,
+    int *convert_values
 )
 {
   /* Necessary because Cgo can't do varargs */
   /* One required string, folloed by an optional string */
   return PyArg_ParseTupleAndKeywords(
-      args, kwargs, "s|$sOd:exec", exec_keywords, defs, filename, print, timeout
+      args,
+      kwargs,
+      "s|$sOdnOpp:exec",
+      exec_keywords,
+      defs,
+      filename,
+      print,
+      timeout,
+      max_memory,
+      options,
+      return_result,
+      convert_values
+  );
+}
+
+int parseEvalManyArgs(
+    PyObject *args,
+    PyObject *kwargs,
+    char **expr,
+    PyObject **records,
+    char **filename,
+    unsigned int *convert,
+    PyObject **print,
+    double *timeout,
+    Py_ssize_t *max_memory,
+    PyObject **result_type,
+    PyObject **options,
+    int *return_exceptions
+)
+{
+  /* Necessary because Cgo can't do varargs */
+  return PyArg_ParseTupleAndKeywords(
+      args,
+      kwargs,
+      "sO|$spOdnOOp:eval_many",
+      eval_many_keywords,
+      expr,
+      records,
+      filename,
+      convert,
+      print,
+      timeout,
+      max_memory,
+      result_type,
+      options,
+      return_exceptions
+  );
+}
+
+int parseExecModuleArgs(
+    PyObject *args,
+    PyObject *kwargs,
+    char **source,
+    char **filename,
+    PyObject **print,
+    double *timeout,
+    Py_ssize_t *max_memory,
+    PyObject **options
+)
+{
+  /* Necessary because Cgo can't do varargs */
+  return PyArg_ParseTupleAndKeywords(
+      args,
+      kwargs,
+      "s|$sOdnO:exec_module",
+      exec_module_keywords,
+      source,
+      filename,
+      print,
+      timeout,
+      max_memory,
+      options
   );
 }
 
 int parseGetGlobalArgs(
-    PyObject *args, PyObject *kwargs, char **name, PyObject **default_value
+    PyObject *args,
+    PyObject *kwargs,
+    char **name,
+    PyObject **default_value,
+    PyObject **result_type
 )
 {
   /* Necessary because Cgo can't do varargs */
-  /* One required string, full stop */
+  /* One required string, an optional object, and an optional keyword object */
   return PyArg_ParseTupleAndKeywords(
-      args, kwargs, "s|O:get", get_global_keywords, name, default_value
+      args, kwargs, "s|O$O:get", get_global_keywords, name, default_value, result_type
   );
 }
 
@@ -415,7 +1424,18 @@ int parsePopGlobalArgs(
   /* Necessary because Cgo can't do varargs */
   /* One required string, full stop */
   return PyArg_ParseTupleAndKeywords(
-      args, kwargs, "s|O:pop", get_global_keywords, name, default_value
+      args, kwargs, "s|O:pop", pop_global_keywords, name, default_value
+  );
+}
+
+int parseWatchArgs(
+    PyObject *args, PyObject *kwargs, PyObject **callback, PyObject **names
+)
+{
+  /* Necessary because Cgo can't do varargs */
+  /* One required object, and an optional one */
+  return PyArg_ParseTupleAndKeywords(
+      args, kwargs, "O|O:watch", watch_keywords, callback, names
   );
 }
 
@@ -457,14 +1477,25 @@ PyObject *makeEvalErrorArgs(
   );
 }
 
+PyObject *makeInternalErrorArgs(
+    const char *error_msg, const char *error_type, const char *go_stack
+)
+{
+  /* Necessary because Cgo can't do varargs */
+  return Py_BuildValue("sss", error_msg, error_type, go_stack);
+}
+
 PyObject *makeResolveErrorItem(
-    const char *msg, const unsigned int line, const unsigned int column
+    StarlarkModuleState *state,
+    const char *msg,
+    const unsigned int line,
+    const unsigned int column
 )
 {
   /* Necessary because Cgo can't do varargs */
   /* A string and two unsigned integers */
   PyObject *args = Py_BuildValue("sII", msg, line, column);
-  PyObject *obj = PyObject_CallObject(ResolveErrorItem, args);
+  PyObject *obj = PyObject_CallObject(state->ResolveErrorItem, args);
   Py_DECREF(args);
   return obj;
 }
@@ -478,6 +1509,27 @@ PyObject *makeResolveErrorArgs(
   return Py_BuildValue("ssO", error_msg, error_type, errors);
 }
 
+PyObject *makeConversionErrorItem(
+    StarlarkModuleState *state, const char *msg, const char *path
+)
+{
+  /* Necessary because Cgo can't do varargs */
+  /* Two strings */
+  PyObject *args = Py_BuildValue("ss", msg, path);
+  PyObject *obj = PyObject_CallObject(state->ConversionErrorItem, args);
+  Py_DECREF(args);
+  return obj;
+}
+
+PyObject *makeConversionToTypeFailedArgs(
+    const char *error_msg, const char *error_type, PyObject *errors
+)
+{
+  /* Necessary because Cgo can't do varargs */
+  /* Two strings and a Python object */
+  return Py_BuildValue("ssO", error_msg, error_type, errors);
+}
+
 /* Other assorted helpers for Cgo */
 PyObject *cgoPy_BuildString(const char *src)
 {
@@ -485,6 +1537,14 @@ PyObject *cgoPy_BuildString(const char *src)
   return Py_BuildValue("s", src);
 }
 
+PyObject *cgoPyObject_CallThree(
+    PyObject *callable, PyObject *a, PyObject *b, PyObject *c
+)
+{
+  /* Necessary because Cgo can't do varargs */
+  return PyObject_CallFunctionObjArgs(callable, a, b, c, NULL);
+}
+
 PyObject *cgoPy_NewRef(PyObject *obj)
 {
   /* Necessary because Cgo can't do macros and Py_NewRef is part of
@@ -542,16 +1602,24 @@ int cgoPyList_Check(PyObject *obj)
   return PyList_Check(obj);
 }
 
-int cgoPyFunc_Check(PyObject *obj)
+int cgoPyNamedTuple_Check(PyObject *obj)
 {
   /* Necessary because Cgo can't do macros */
-  return PyFunction_Check(obj);
+  /* There's no namedtuple base class, so look for its _fields attribute */
+  return PyTuple_Check(obj) && PyObject_HasAttrString(obj, "_fields");
 }
 
-int cgoPyMethod_Check(PyObject *obj)
+int cgoPyType_Check(PyObject *obj)
 {
   /* Necessary because Cgo can't do macros */
-  return PyMethod_Check(obj);
+  return PyType_Check(obj);
+}
+
+int cgoPyDataclass_Check(PyObject *obj)
+{
+  /* Necessary because Cgo can't do macros */
+  /* Same test as dataclasses.is_dataclass, except that classes are excluded */
+  return !PyType_Check(obj) && PyObject_HasAttrString(obj, "__dataclass_fields__");
 }
 
 /* Helper to fetch exception classes */
@@ -565,50 +1633,200 @@ static PyObject *get_exception_class(PyObject *errors, const char *name)
   return retval;
 }
 
-/* Module initialization */
-PyMODINIT_FUNC PyInit_starlark_go(void)
+/* Helper to fetch attributes of modules we depend on */
+static PyObject *get_module_attr(const char *module, const char *name)
+{
+  PyObject *m = PyImport_ImportModule(module);
+  if (m == NULL) return NULL;
+
+  PyObject *retval = PyObject_GetAttrString(m, name);
+  Py_DECREF(m);
+  return retval;
+}
+
+/* Make Starlark a collections.abc.MutableMapping, borrowing the methods that
+ * the ABC implements in terms of the mapping slots */
+static int register_mutable_mapping(PyTypeObject *type)
+{
+  static const char *mixins[] = {
+      "keys", "items", "values", "update", "setdefault", "popitem", NULL
+  };
+
+  PyObject *abc = get_module_attr("collections.abc", "MutableMapping");
+  if (abc == NULL) return -1;
+
+  for (const char **name = mixins; *name != NULL; name++) {
+    PyObject *method = PyObject_GetAttrString(abc, *name);
+    if (method == NULL ||
+        PyObject_SetAttrString((PyObject *)type, *name, method) < 0) {
+      Py_XDECREF(method);
+      Py_DECREF(abc);
+      return -1;
+    }
+    Py_DECREF(method);
+  }
+
+  PyObject *result = PyObject_CallMethod(abc, "register", "O", type);
+  Py_DECREF(abc);
+  if (result == NULL) return -1;
+
+  Py_DECREF(result);
+  return 0;
+}
+
+/* Module initialization, once for each interpreter that imports us */
+static int starlark_go_exec(PyObject *m)
 {
+  StarlarkModuleState *state = PyModule_GetState(m);
+
   PyObject *errors = PyImport_ImportModule("starlark_go.errors");
-  if (errors == NULL) return NULL;
+  if (errors == NULL) return -1;
+
+  state->StarlarkError = get_exception_class(errors, "StarlarkError");
+  state->SyntaxError = get_exception_class(errors, "SyntaxError");
+  state->EvalError = get_exception_class(errors, "EvalError");
+  state->EvalTimeoutError = get_exception_class(errors, "EvalTimeoutError");
+  state->EvalMemoryLimitError = get_exception_class(errors, "EvalMemoryLimitError");
+  state->StarlarkInternalError = get_exception_class(errors, "StarlarkInternalError");
+  state->ResolveError = get_exception_class(errors, "ResolveError");
+  state->ResolveErrorItem = get_exception_class(errors, "ResolveErrorItem");
+  state->ConversionToPythonFailed =
+      get_exception_class(errors, "ConversionToPythonFailed");
+  state->ConversionToStarlarkFailed =
+      get_exception_class(errors, "ConversionToStarlarkFailed");
+  state->ConversionToTypeFailed = get_exception_class(errors, "ConversionToTypeFailed");
+  state->ConversionErrorItem = get_exception_class(errors, "ConversionErrorItem");
+  Py_DECREF(errors);
 
-  StarlarkError = get_exception_class(errors, "StarlarkError");
-  if (StarlarkError == NULL) return NULL;
+  if (state->StarlarkError == NULL || state->SyntaxError == NULL ||
+      state->EvalError == NULL || state->EvalTimeoutError == NULL ||
+      state->EvalMemoryLimitError == NULL ||
+      state->StarlarkInternalError == NULL ||
+      state->ResolveError == NULL || state->ResolveErrorItem == NULL ||
+      state->ConversionToPythonFailed == NULL ||
+      state->ConversionToStarlarkFailed == NULL ||
+      state->ConversionToTypeFailed == NULL || state->ConversionErrorItem == NULL)
+    return -1;
 
-  SyntaxError = get_exception_class(errors, "SyntaxError");
-  if (SyntaxError == NULL) return NULL;
+  state->SimpleNamespaceType = get_module_attr("types", "SimpleNamespace");
+  if (state->SimpleNamespaceType == NULL) return -1;
 
-  EvalError = get_exception_class(errors, "EvalError");
-  if (EvalError == NULL) return NULL;
+  state->UnionType = get_module_attr("types", "UnionType");
+  if (state->UnionType == NULL) return -1;
 
-  EvalTimeoutError = get_exception_class(errors, "EvalTimeoutError");
-  if (EvalTimeoutError == NULL) return NULL;
+  state->TypingModule = PyImport_ImportModule("typing");
+  if (state->TypingModule == NULL) return -1;
 
-  ResolveError = get_exception_class(errors, "ResolveError");
-  if (ResolveError == NULL) return NULL;
+  state->DataclassFields = get_module_attr("dataclasses", "fields");
+  if (state->DataclassFields == NULL) return -1;
 
-  ResolveErrorItem = get_exception_class(errors, "ResolveErrorItem");
-  if (ResolveErrorItem == NULL) return NULL;
+  state->DataclassMissing = get_module_attr("dataclasses", "MISSING");
+  if (state->DataclassMissing == NULL) return -1;
 
-  ConversionToPythonFailed = get_exception_class(errors, "ConversionToPythonFailed");
-  if (ConversionToPythonFailed == NULL) return NULL;
+  state->InspectSignature = get_module_attr("inspect", "signature");
+  if (state->InspectSignature == NULL) return -1;
 
-  ConversionToStarlarkFailed =
-      get_exception_class(errors, "ConversionToStarlarkFailed");
-  if (ConversionToStarlarkFailed == NULL) return NULL;
+  state->InspectEmpty = get_module_attr("inspect", "Parameter");
+  if (state->InspectEmpty == NULL) return -1;
 
-  PyObject *m;
-  if (PyType_Ready(&StarlarkType) < 0) return NULL;
+  Py_SETREF(state->InspectEmpty, PyObject_GetAttrString(state->InspectEmpty, "empty"));
+  if (state->InspectEmpty == NULL) return -1;
 
-  m = PyModule_Create(&starlark_go);
-  if (m == NULL) return NULL;
+  state->FileOptionsType = get_module_attr("starlark_go.options", "FileOptions");
+  if (state->FileOptionsType == NULL) return -1;
 
-  Py_INCREF(&StarlarkType);
-  if (PyModule_AddObject(m, "Starlark", (PyObject *)&StarlarkType) < 0) {
-    Py_DECREF(&StarlarkType);
-    Py_DECREF(m);
+  state->ExecResultType = get_module_attr("starlark_go.result", "ExecResult");
+  if (state->ExecResultType == NULL) return -1;
 
-    return NULL;
+  state->StarlarkType =
+      (PyTypeObject *)PyType_FromModuleAndSpec(m, &Starlark_spec, NULL);
+  if (state->StarlarkType == NULL) return -1;
+
+  Py_INCREF(state->StarlarkType);
+  if (PyModule_AddObject(m, "Starlark", (PyObject *)state->StarlarkType) < 0) {
+    Py_DECREF(state->StarlarkType);
+    return -1;
   }
 
-  return m;
+  if (register_mutable_mapping(state->StarlarkType) < 0) return -1;
+
+  state->StarlarkSnapshotType =
+      (PyTypeObject *)PyType_FromModuleAndSpec(m, &StarlarkSnapshot_spec, NULL);
+  if (state->StarlarkSnapshotType == NULL) return -1;
+
+  Py_INCREF(state->StarlarkSnapshotType);
+  if (PyModule_AddObject(
+          m, "StarlarkSnapshot", (PyObject *)state->StarlarkSnapshotType
+      ) < 0) {
+    Py_DECREF(state->StarlarkSnapshotType);
+    return -1;
+  }
+
+  return 0;
+}
+
+static int starlark_go_traverse(PyObject *m, visitproc visit, void *arg)
+{
+  StarlarkModuleState *state = PyModule_GetState(m);
+  if (state == NULL) return 0;
+
+  Py_VISIT(state->StarlarkType);
+  Py_VISIT(state->StarlarkSnapshotType);
+  Py_VISIT(state->StarlarkError);
+  Py_VISIT(state->SyntaxError);
+  Py_VISIT(state->EvalError);
+  Py_VISIT(state->EvalTimeoutError);
+  Py_VISIT(state->EvalMemoryLimitError);
+  Py_VISIT(state->StarlarkInternalError);
+  Py_VISIT(state->ResolveError);
+  Py_VISIT(state->ResolveErrorItem);
+  Py_VISIT(state->ConversionToPythonFailed);
+  Py_VISIT(state->ConversionToStarlarkFailed);
+  Py_VISIT(state->ConversionToTypeFailed);
+  Py_VISIT(state->ConversionErrorItem);
+  Py_VISIT(state->SimpleNamespaceType);
+  Py_VISIT(state->DataclassFields);
+  Py_VISIT(state->DataclassMissing);
+  Py_VISIT(state->TypingModule);
+  Py_VISIT(state->UnionType);
+  Py_VISIT(state->InspectSignature);
+  Py_VISIT(state->InspectEmpty);
+  Py_VISIT(state->FileOptionsType);
+  Py_VISIT(state->ExecResultType);
+  return 0;
 }
+
+static int starlark_go_clear(PyObject *m)
+{
+  StarlarkModuleState *state = PyModule_GetState(m);
+  if (state == NULL) return 0;
+
+  Py_CLEAR(state->StarlarkType);
+  Py_CLEAR(state->StarlarkSnapshotType);
+  Py_CLEAR(state->StarlarkError);
+  Py_CLEAR(state->SyntaxError);
+  Py_CLEAR(state->EvalError);
+  Py_CLEAR(state->EvalTimeoutError);
+  Py_CLEAR(state->EvalMemoryLimitError);
+  Py_CLEAR(state->StarlarkInternalError);
+  Py_CLEAR(state->ResolveError);
+  Py_CLEAR(state->ResolveErrorItem);
+  Py_CLEAR(state->ConversionToPythonFailed);
+  Py_CLEAR(state->ConversionToStarlarkFailed);
+  Py_CLEAR(state->ConversionToTypeFailed);
+  Py_CLEAR(state->ConversionErrorItem);
+  Py_CLEAR(state->SimpleNamespaceType);
+  Py_CLEAR(state->DataclassFields);
+  Py_CLEAR(state->DataclassMissing);
+  Py_CLEAR(state->TypingModule);
+  Py_CLEAR(state->UnionType);
+  Py_CLEAR(state->InspectSignature);
+  Py_CLEAR(state->InspectEmpty);
+  Py_CLEAR(state->FileOptionsType);
+  Py_CLEAR(state->ExecResultType);
+  return 0;
+}
+
+static void starlark_go_free(void *m) { starlark_go_clear((PyObject *)m); }
+
+PyMODINIT_FUNC PyInit_starlark_go(void) { return PyModuleDef_Init(&starlark_go); }
@@ -1,6 +1,7 @@
 #ifndef PYTHON_STARLARK_GO_H
 #define PYTHON_STARLARK_GO_H
 
+#include <stddef.h>
 #include <stdint.h>
 #include <stdlib.h>
 #define PY_SSIZE_T_CLEAN
@@ -10,15 +11,85 @@
 /* Starlark object */
 typedef struct Starlark {
   PyObject_HEAD uintptr_t handle;
+  PyObject *weakreflist;
 } Starlark;
 
+/* Snapshot of the globals of a Starlark object */
+typedef struct StarlarkSnapshot {
+  PyObject_HEAD uintptr_t handle;
+  /* The Starlark object it was taken from, which owns the Python objects
+     that the globals refer to */
+  PyObject *owner;
+} StarlarkSnapshot;
+
+/* Per-module state, so that each interpreter has its own copy of the module */
+typedef struct StarlarkModuleState {
+  PyTypeObject *StarlarkType;
+  PyTypeObject *StarlarkSnapshotType;
+
+  /* Exceptions */
+  PyObject *StarlarkError;
+  PyObject *SyntaxError;
+  PyObject *EvalError;
+  PyObject *EvalTimeoutError;
+  PyObject *EvalMemoryLimitError;
+  PyObject *StarlarkInternalError;
+  PyObject *ResolveError;
+  PyObject *ResolveErrorItem;
+  PyObject *ConversionToPythonFailed;
+  PyObject *ConversionToStarlarkFailed;
+  PyObject *ConversionToTypeFailed;
+  PyObject *ConversionErrorItem;
+
+  /* Python types and functions used for conversion */
+  PyObject *SimpleNamespaceType;
+  PyObject *DataclassFields;
+  PyObject *DataclassMissing;
+  PyObject *TypingModule;
+  PyObject *UnionType;
+  PyObject *InspectSignature;
+  PyObject *InspectEmpty;
+
+  /* starlark_go.FileOptions */
+  PyObject *FileOptionsType;
+
+  /* starlark_go.ExecResult */
+  PyObject *ExecResultType;
+
+  /* The defaults set by configure_starlark, which are guarded by
+     defaultOptionsMutex in Go */
+  int AllowSet;
+  int AllowGlobalReassign;
+  int AllowRecursion;
+} StarlarkModuleState;
+
+StarlarkModuleState *starlarkModuleState(PyTypeObject *type);
+
 /* Helpers for Cgo, which can't handle varargs or macros */
 Starlark *starlarkAlloc(PyTypeObject *type);
 
 void starlarkFree(Starlark *self);
 
+StarlarkSnapshot *starlarkSnapshotAlloc(PyTypeObject *type);
+
+StarlarkSnapshot *asStarlarkSnapshot(StarlarkModuleState *state, PyObject *obj);
+
+int isInterruptible(void);
+
+int cgoVisit(visitproc visit, PyObject *obj, void *arg);
+
+PyObject *cgoPy_TYPE(Starlark *self);
+
+PyThreadState *currentThreadState(void);
+
 int parseInitArgs(
-    PyObject *args, PyObject *kwargs, PyObject **globals, PyObject **print
+    PyObject *args,
+    PyObject *kwargs,
+    PyObject **globals,
+    PyObject **print,
+    PyObject **struct_factory,
+    int *coerce_args,
+    PyObject **options
 );
 
 int parseEvalArgs(
@@ -28,22 +99,68 @@ int parseEvalArgs(
     char **filename,
     unsigned int *convert,
     PyObject **print,
-    double *timeout
+    double *timeout,
+    Py_ssize_t *max_memory,
+    PyObject **result_type,
+    PyObject **options,
+    PyObject **locals
 );
 
 int parseExecArgs(
-    PyObject *args, PyObject *kwargs, char **defs, char **filename, PyObject **print,
-    double *timeout
+    PyObject *args,
+    PyObject *kwargs,
+    char **defs,
+    char **filename,
+    PyObject **print,
+    double *timeout,
+    Py_ssize_t *max_memory,
+    PyObject **options,
+    int *return_result,
+    int *convert_values
+);
+
+int parseEvalManyArgs(
+    PyObject *args,
+    PyObject *kwargs,
+    char **expr,
+    PyObject **records,
+    char **filename,
+    unsigned int *convert,
+    PyObject **print,
+    double *timeout,
+    Py_ssize_t *max_memory,
+    PyObject **result_type,
+    PyObject **options,
+    int *return_exceptions
+);
+
+int parseExecModuleArgs(
+    PyObject *args,
+    PyObject *kwargs,
+    char **source,
+    char **filename,
+    PyObject **print,
+    double *timeout,
+    Py_ssize_t *max_memory,
+    PyObject **options
 );
 
 int parseGetGlobalArgs(
-    PyObject *args, PyObject *kwargs, char **name, PyObject **default_value
+    PyObject *args,
+    PyObject *kwargs,
+    char **name,
+    PyObject **default_value,
+    PyObject **result_type
 );
 
 int parsePopGlobalArgs(
     PyObject *args, PyObject *kwargs, char **name, PyObject **default_value
 );
 
+int parseWatchArgs(
+    PyObject *args, PyObject *kwargs, PyObject **callback, PyObject **names
+);
+
 PyObject *makeStarlarkErrorArgs(const char *error_msg, const char *error_type);
 
 PyObject *makeSyntaxErrorArgs(
@@ -65,16 +182,35 @@ PyObject *makeEvalErrorArgs(
     const char *backtrace
 );
 
+PyObject *makeInternalErrorArgs(
+    const char *error_msg, const char *error_type, const char *go_stack
+);
+
 PyObject *makeResolveErrorItem(
-    const char *msg, const unsigned int line, const unsigned int column
+    StarlarkModuleState *state,
+    const char *msg,
+    const unsigned int line,
+    const unsigned int column
 );
 
 PyObject *makeResolveErrorArgs(
     const char *error_msg, const char *error_type, PyObject *errors
 );
 
+PyObject *makeConversionErrorItem(
+    StarlarkModuleState *state, const char *msg, const char *path
+);
+
+PyObject *makeConversionToTypeFailedArgs(
+    const char *error_msg, const char *error_type, PyObject *errors
+);
+
 PyObject *cgoPy_BuildString(const char *src);
 
+PyObject *cgoPyObject_CallThree(
+    PyObject *callable, PyObject *a, PyObject *b, PyObject *c
+);
+
 PyObject *cgoPy_NewRef(PyObject *obj);
 
 int cgoPyFloat_Check(PyObject *obj);
@@ -95,8 +231,10 @@ int cgoPyDict_Check(PyObject *obj);
 
 int cgoPyList_Check(PyObject *obj);
 
-int cgoPyFunc_Check(PyObject *obj);
+int cgoPyNamedTuple_Check(PyObject *obj);
+
+int cgoPyDataclass_Check(PyObject *obj);
 
-int cgoPyMethod_Check(PyObject *obj);
+int cgoPyType_Check(PyObject *obj);
 
 #endif /* PYTHON_STARLARK_GO_H */
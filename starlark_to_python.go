@@ -2,8 +2,6 @@ package main
 
 /*
 #include "starlark.h"
-
-extern PyObject *ConversionToPythonFailed;
 */
 import "C"
 
@@ -13,6 +11,7 @@ import (
 	"unsafe"
 
 	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
 )
 
 func starlarkIntToPython(x starlark.Int) (*C.PyObject, error) {
@@ -34,16 +33,16 @@ func starlarkStringToPython(x starlark.String) (*C.PyObject, error) {
 	return C.cgoPy_BuildString(cstr), nil
 }
 
-func starlarkDictToPython(x starlark.IterableMapping) (*C.PyObject, error) {
+func (state *StarlarkState) starlarkDictToPython(x starlark.IterableMapping) (*C.PyObject, error) {
 	items := x.Items()
-	return starlarkDictItemsToPython(items)
+	return state.starlarkDictItemsToPython(items)
 }
 
-func starlarkDictItemsToPython(items []starlark.Tuple) (*C.PyObject, error) {
+func (state *StarlarkState) starlarkDictItemsToPython(items []starlark.Tuple) (*C.PyObject, error) {
 	dict := C.PyDict_New()
 
 	for _, item := range items {
-		key, err := innerStarlarkValueToPython(item[0])
+		key, err := state.innerStarlarkValueToPython(item[0])
 		if key != nil {
 			defer C.Py_DecRef(key)
 		}
@@ -53,7 +52,7 @@ func starlarkDictItemsToPython(items []starlark.Tuple) (*C.PyObject, error) {
 			return nil, fmt.Errorf("While converting key %v in Starlark dict: %v", item[0], err)
 		}
 
-		value, err := innerStarlarkValueToPython((item[1]))
+		value, err := state.innerStarlarkValueToPython((item[1]))
 		if value != nil {
 			defer C.Py_DecRef(value)
 		}
@@ -70,8 +69,8 @@ func starlarkDictItemsToPython(items []starlark.Tuple) (*C.PyObject, error) {
 	return dict, nil
 }
 
-func starlarkTupleToPython(x starlark.Tuple) (*C.PyObject, error) {
-	objs, err := starlarkTupleToPythonList(x)
+func (state *StarlarkState) starlarkTupleToPython(x starlark.Tuple) (*C.PyObject, error) {
+	objs, err := state.starlarkTupleToPythonList(x)
 	if err != nil {
 		return nil, err
 	}
@@ -89,14 +88,14 @@ func starlarkTupleToPython(x starlark.Tuple) (*C.PyObject, error) {
 	return tuple, nil
 }
 
-func starlarkTupleToPythonList(x starlark.Tuple) ([]*C.PyObject, error) {
+func (state *StarlarkState) starlarkTupleToPythonList(x starlark.Tuple) ([]*C.PyObject, error) {
 	result := make([]*C.PyObject, x.Len())
 	iter := x.Iterate()
 	defer iter.Done()
 
 	var elem starlark.Value
 	for i := 0; iter.Next(&elem); i++ {
-		value, err := innerStarlarkValueToPython(elem)
+		value, err := state.innerStarlarkValueToPython(elem)
 		if err != nil {
 			if value != nil {
 				C.Py_DecRef(value)
@@ -110,14 +109,14 @@ func starlarkTupleToPythonList(x starlark.Tuple) ([]*C.PyObject, error) {
 	return result, nil
 }
 
-func starlarkListToPython(x starlark.Iterable) (*C.PyObject, error) {
+func (state *StarlarkState) starlarkListToPython(x starlark.Iterable) (*C.PyObject, error) {
 	list := C.PyList_New(0)
 	iter := x.Iterate()
 	defer iter.Done()
 
 	var elem starlark.Value
 	for i := 0; iter.Next(&elem); i++ {
-		value, err := innerStarlarkValueToPython(elem)
+		value, err := state.innerStarlarkValueToPython(elem)
 		if err != nil {
 			C.Py_DecRef(list)
 			return nil, fmt.Errorf("While converting value %v at index %v in Starlark list: %v", elem, i, err)
@@ -134,14 +133,14 @@ func starlarkListToPython(x starlark.Iterable) (*C.PyObject, error) {
 	return list, nil
 }
 
-func starlarkSetToPython(x starlark.Set) (*C.PyObject, error) {
+func (state *StarlarkState) starlarkSetToPython(x *starlark.Set) (*C.PyObject, error) {
 	set := C.PySet_New(nil)
 	iter := x.Iterate()
 	defer iter.Done()
 
 	var elem starlark.Value
 	for i := 0; iter.Next(&elem); i++ {
-		value, err := innerStarlarkValueToPython(elem)
+		value, err := state.innerStarlarkValueToPython(elem)
 		if value != nil {
 			defer C.Py_DecRef(value)
 		}
@@ -158,13 +157,53 @@ func starlarkSetToPython(x starlark.Set) (*C.PyObject, error) {
 	return set, nil
 }
 
+func (state *StarlarkState) starlarkStructToPython(members starlark.StringDict, kind string) (*C.PyObject, error) {
+	kwargs := C.PyDict_New()
+	defer C.Py_DecRef(kwargs)
+
+	for _, name := range members.Keys() {
+		value, err := state.innerStarlarkValueToPython(members[name])
+		if value != nil {
+			defer C.Py_DecRef(value)
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("While converting value %v of field %v in Starlark %s: %v", members[name], name, kind, err)
+		}
+
+		cname := C.CString(name)
+		defer C.free(unsafe.Pointer(cname))
+
+		// This does not steal references
+		if C.PyDict_SetItemString(kwargs, cname, value) != 0 {
+			return nil, fmt.Errorf("Couldn't store converted value of field %v in Starlark %s", name, kind)
+		}
+	}
+
+	factory := state.structFactory()
+	if factory == nil {
+		factory = C.cgoPy_NewRef(state.module.SimpleNamespaceType)
+	}
+	defer C.Py_DecRef(factory)
+
+	args := C.PyTuple_New(0)
+	defer C.Py_DecRef(args)
+
+	retval := C.PyObject_Call(factory, args, kwargs)
+	if retval == nil {
+		return nil, fmt.Errorf("Python exception while converting Starlark %s", kind)
+	}
+
+	return retval, nil
+}
+
 func starlarkBytesToPython(x starlark.Bytes) (*C.PyObject, error) {
 	cstr := C.CString(string(x))
 	defer C.free(unsafe.Pointer(cstr))
 	return C.PyBytes_FromStringAndSize(cstr, C.Py_ssize_t(x.Len())), nil
 }
 
-func innerStarlarkValueToPython(x starlark.Value) (*C.PyObject, error) {
+func (state *StarlarkState) innerStarlarkValueToPython(x starlark.Value) (*C.PyObject, error) {
 	var value *C.PyObject = nil
 	var err error = nil
 
@@ -185,14 +224,20 @@ func innerStarlarkValueToPython(x starlark.Value) (*C.PyObject, error) {
 		value, err = starlarkStringToPython(x)
 	case starlark.Bytes:
 		value, err = starlarkBytesToPython(x)
+	case *starlarkstruct.Struct:
+		members := starlark.StringDict{}
+		x.ToStringDict(members)
+		value, err = state.starlarkStructToPython(members, x.Type())
+	case *starlarkstruct.Module:
+		value, err = state.starlarkStructToPython(x.Members, x.Type())
 	case *starlark.Set:
-		value, err = starlarkSetToPython(*x)
+		value, err = state.starlarkSetToPython(x)
 	case starlark.IterableMapping:
-		value, err = starlarkDictToPython(x)
+		value, err = state.starlarkDictToPython(x)
 	case starlark.Tuple:
-		value, err = starlarkTupleToPython(x)
+		value, err = state.starlarkTupleToPython(x)
 	case starlark.Iterable:
-		value, err = starlarkListToPython(x)
+		value, err = state.starlarkListToPython(x)
 	default:
 		err = fmt.Errorf("Don't know how to convert Starlark %s to Python", reflect.TypeOf(x).String())
 	}
@@ -206,12 +251,31 @@ func innerStarlarkValueToPython(x starlark.Value) (*C.PyObject, error) {
 	return value, err
 }
 
-func starlarkValueToPython(x starlark.Value) (*C.PyObject, error) {
-	value, err := innerStarlarkValueToPython(x)
+func (state *StarlarkState) starlarkValueToPython(x starlark.Value) (*C.PyObject, error) {
+	value, err := state.innerStarlarkValueToPython(x)
 	if err != nil {
-		handleConversionError(err, C.ConversionToPythonFailed)
+		handleConversionError(err, state.module.ConversionToPythonFailed)
 		return nil, err
 	}
 
 	return value, nil
 }
+
+// starlarkValueOrReprToPython converts a value to Python, or to its Starlark
+// representation if it can't be, so that functions and the like can still
+// be reported. nil is None.
+func (state *StarlarkState) starlarkValueOrReprToPython(value starlark.Value) *C.PyObject {
+	if value == nil {
+		return C.cgoPy_NewRef(C.Py_None)
+	}
+
+	retval, err := state.starlarkValueToPython(value)
+	if err == nil {
+		return retval
+	}
+	C.PyErr_Clear()
+
+	cstr := C.CString(value.String())
+	defer C.free(unsafe.Pointer(cstr))
+	return C.cgoPy_BuildString(cstr)
+}
@@ -0,0 +1,791 @@
+package main
+
+/*
+#include "starlark.h"
+*/
+import "C"
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+	"unsafe"
+
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+)
+
+var identifierRegexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+type typeMismatch struct {
+	path string
+	msg  string
+}
+
+type typeMismatchError []typeMismatch
+
+func (e typeMismatchError) Error() string {
+	msgs := make([]string, len(e))
+	for i, m := range e {
+		msgs[i] = fmt.Sprintf("%s: %s", m.path, m.msg)
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// typedConverter converts Starlark values into instances of Python types.
+// Values that don't match the type are recorded as mismatches rather than
+// stopping the conversion, so that every problem can be reported at once.
+type typedConverter struct {
+	state      *StarlarkState
+	anyType    *C.PyObject
+	unionType  *C.PyObject
+	mismatches []typeMismatch
+}
+
+func pyTypeObject(t *C.PyTypeObject) *C.PyObject {
+	return (*C.PyObject)(unsafe.Pointer(t))
+}
+
+func isPythonNoneType(t *C.PyObject) bool {
+	none := C.Py_None
+	return t == none || t == pyTypeObject(none.ob_type)
+}
+
+func pythonTypeName(t *C.PyObject) string {
+	if C.cgoPyType_Check(t) == 1 {
+		return C.GoString((*C.PyTypeObject)(unsafe.Pointer(t)).tp_name)
+	}
+
+	pyrepr := C.PyObject_Repr(t)
+	if pyrepr == nil {
+		C.PyErr_Clear()
+		return "<unknown>"
+	}
+	defer C.Py_DecRef(pyrepr)
+
+	repr, err := pythonToStarlarkString(pyrepr)
+	if err != nil {
+		C.PyErr_Clear()
+		return "<unknown>"
+	}
+
+	return repr.GoString()
+}
+
+func getPythonAttr(obj *C.PyObject, name string) *C.PyObject {
+	cname := C.CString(name)
+	defer C.free(unsafe.Pointer(cname))
+	return C.PyObject_GetAttrString(obj, cname)
+}
+
+func callPython(fn *C.PyObject, args ...*C.PyObject) *C.PyObject {
+	tuple := C.PyTuple_New(C.Py_ssize_t(len(args)))
+	if tuple == nil {
+		return nil
+	}
+	defer C.Py_DecRef(tuple)
+
+	for i, arg := range args {
+		// This "steals" the new ref to arg
+		C.PyTuple_SetItem(tuple, C.Py_ssize_t(i), C.cgoPy_NewRef(arg))
+	}
+
+	return C.PyObject_CallObject(fn, tuple)
+}
+
+func (c *typedConverter) callTyping(name string, args ...*C.PyObject) *C.PyObject {
+	fn := getPythonAttr(c.state.module.TypingModule, name)
+	if fn == nil {
+		return nil
+	}
+	defer C.Py_DecRef(fn)
+
+	return callPython(fn, args...)
+}
+
+func keyPath(path string, key starlark.Value) string {
+	if s, ok := key.(starlark.String); ok && identifierRegexp.MatchString(string(s)) {
+		return path + "." + string(s)
+	}
+
+	return fmt.Sprintf("%s[%s]", path, key.String())
+}
+
+func newTypedConverter(state *StarlarkState) (*typedConverter, error) {
+	anyType := getPythonAttr(state.module.TypingModule, "Any")
+	if anyType == nil {
+		return nil, fmt.Errorf("Couldn't find typing.Any")
+	}
+
+	unionType := getPythonAttr(state.module.TypingModule, "Union")
+	if unionType == nil {
+		C.Py_DecRef(anyType)
+		return nil, fmt.Errorf("Couldn't find typing.Union")
+	}
+
+	return &typedConverter{state: state, anyType: anyType, unionType: unionType}, nil
+}
+
+func (c *typedConverter) Close() {
+	C.Py_DecRef(c.anyType)
+	C.Py_DecRef(c.unionType)
+}
+
+func (c *typedConverter) mismatch(path string, format string, args ...interface{}) {
+	c.mismatches = append(c.mismatches, typeMismatch{path, fmt.Sprintf(format, args...)})
+}
+
+func (c *typedConverter) expected(path string, t *C.PyObject, x starlark.Value) {
+	c.mismatch(path, "expected %s, got %s", pythonTypeName(t), x.Type())
+}
+
+// convert returns a new reference to the converted value. If x doesn't match
+// t, the mismatch is recorded and nil is returned without an error; errors
+// are reserved for failures that should abort the whole conversion.
+func (c *typedConverter) convert(x starlark.Value, t *C.PyObject, path string) (*C.PyObject, error) {
+	if t == c.anyType || t == pyTypeObject(&C.PyBaseObject_Type) {
+		return c.state.innerStarlarkValueToPython(x)
+	}
+
+	if isPythonNoneType(t) {
+		if x != starlark.None {
+			c.mismatch(path, "expected None, got %s", x.Type())
+			return nil, nil
+		}
+		return C.cgoPy_NewRef(C.Py_None), nil
+	}
+
+	origin := c.callTyping("get_origin", t)
+	if origin == nil {
+		return nil, fmt.Errorf("Python exception while inspecting type %s", pythonTypeName(t))
+	}
+	defer C.Py_DecRef(origin)
+
+	if origin != C.Py_None {
+		args := c.callTyping("get_args", t)
+		if args == nil {
+			return nil, fmt.Errorf("Python exception while inspecting type %s", pythonTypeName(t))
+		}
+		defer C.Py_DecRef(args)
+
+		return c.convertGeneric(x, t, origin, args, path)
+	}
+
+	switch t {
+	case pyTypeObject(&C.PyBool_Type):
+		if _, ok := x.(starlark.Bool); !ok {
+			c.expected(path, t, x)
+			return nil, nil
+		}
+		return c.state.innerStarlarkValueToPython(x)
+	case pyTypeObject(&C.PyLong_Type):
+		if _, ok := x.(starlark.Int); !ok {
+			c.expected(path, t, x)
+			return nil, nil
+		}
+		return c.state.innerStarlarkValueToPython(x)
+	case pyTypeObject(&C.PyFloat_Type):
+		switch x := x.(type) {
+		case starlark.Float:
+			return c.state.innerStarlarkValueToPython(x)
+		case starlark.Int:
+			return c.state.innerStarlarkValueToPython(x.Float())
+		}
+		c.expected(path, t, x)
+		return nil, nil
+	case pyTypeObject(&C.PyUnicode_Type):
+		if _, ok := x.(starlark.String); !ok {
+			c.expected(path, t, x)
+			return nil, nil
+		}
+		return c.state.innerStarlarkValueToPython(x)
+	case pyTypeObject(&C.PyBytes_Type):
+		if _, ok := x.(starlark.Bytes); !ok {
+			c.expected(path, t, x)
+			return nil, nil
+		}
+		return c.state.innerStarlarkValueToPython(x)
+	case pyTypeObject(&C.PyList_Type):
+		return c.convertList(x, t, c.anyType, path)
+	case pyTypeObject(&C.PySet_Type), pyTypeObject(&C.PyFrozenSet_Type):
+		return c.convertSet(x, t, t == pyTypeObject(&C.PyFrozenSet_Type), c.anyType, path)
+	case pyTypeObject(&C.PyTuple_Type):
+		return c.convertTuple(x, t, nil, c.anyType, path)
+	case pyTypeObject(&C.PyDict_Type):
+		return c.convertDict(x, t, c.anyType, c.anyType, path)
+	}
+
+	if C.cgoPyType_Check(t) == 1 {
+		if hasPythonAttr(t, "__dataclass_fields__") {
+			return c.convertDataclass(x, t, path)
+		}
+
+		isTypedDict := c.callTyping("is_typeddict", t)
+		if isTypedDict == nil {
+			return nil, fmt.Errorf("Python exception while inspecting type %s", pythonTypeName(t))
+		}
+		defer C.Py_DecRef(isTypedDict)
+
+		if isTypedDict == C.Py_True {
+			return c.convertTypedDict(x, t, path)
+		}
+	}
+
+	return nil, fmt.Errorf("Don't know how to convert Starlark %s to Python type %s", x.Type(), pythonTypeName(t))
+}
+
+func hasPythonAttr(obj *C.PyObject, name string) bool {
+	cname := C.CString(name)
+	defer C.free(unsafe.Pointer(cname))
+	return C.PyObject_HasAttrString(obj, cname) == 1
+}
+
+func (c *typedConverter) convertGeneric(x starlark.Value, t *C.PyObject, origin *C.PyObject, args *C.PyObject, path string) (*C.PyObject, error) {
+	nargs := int(C.PyTuple_Size(args))
+	arg := func(i int) *C.PyObject {
+		if i >= nargs {
+			return c.anyType
+		}
+		// This is a borrowed reference
+		return C.PyTuple_GetItem(args, C.Py_ssize_t(i))
+	}
+
+	switch origin {
+	case c.unionType, c.state.module.UnionType:
+		return c.convertUnion(x, t, args, path)
+	case pyTypeObject(&C.PyList_Type):
+		return c.convertList(x, t, arg(0), path)
+	case pyTypeObject(&C.PySet_Type), pyTypeObject(&C.PyFrozenSet_Type):
+		return c.convertSet(x, t, origin == pyTypeObject(&C.PyFrozenSet_Type), arg(0), path)
+	case pyTypeObject(&C.PyDict_Type):
+		return c.convertDict(x, t, arg(0), arg(1), path)
+	case pyTypeObject(&C.PyTuple_Type):
+		if nargs == 2 && arg(1) == C.Py_Ellipsis {
+			return c.convertTuple(x, t, nil, arg(0), path)
+		}
+
+		elemTypes := make([]*C.PyObject, nargs)
+		for i := range elemTypes {
+			elemTypes[i] = arg(i)
+		}
+		return c.convertTuple(x, t, elemTypes, nil, path)
+	}
+
+	return nil, fmt.Errorf("Don't know how to convert Starlark %s to Python type %s", x.Type(), pythonTypeName(t))
+}
+
+func (c *typedConverter) convertUnion(x starlark.Value, t *C.PyObject, args *C.PyObject, path string) (*C.PyObject, error) {
+	var alternatives []*C.PyObject
+	optional := false
+
+	for i := C.Py_ssize_t(0); i < C.PyTuple_Size(args); i++ {
+		alt := C.PyTuple_GetItem(args, i)
+		if isPythonNoneType(alt) {
+			optional = true
+			continue
+		}
+		alternatives = append(alternatives, alt)
+	}
+
+	if x == starlark.None && optional {
+		return C.cgoPy_NewRef(C.Py_None), nil
+	}
+
+	// For Optional[T], report the mismatches inside T rather than just saying
+	// that the value wasn't a T.
+	if len(alternatives) == 1 {
+		return c.convert(x, alternatives[0], path)
+	}
+
+	for _, alt := range alternatives {
+		trial := &typedConverter{state: c.state, anyType: c.anyType, unionType: c.unionType}
+
+		value, err := trial.convert(x, alt, path)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(trial.mismatches) == 0 {
+			return value, nil
+		}
+
+		if value != nil {
+			C.Py_DecRef(value)
+		}
+	}
+
+	c.expected(path, t, x)
+	return nil, nil
+}
+
+func sequenceElems(x starlark.Value) ([]starlark.Value, bool) {
+	switch x := x.(type) {
+	case *starlark.List:
+		elems := make([]starlark.Value, x.Len())
+		for i := range elems {
+			elems[i] = x.Index(i)
+		}
+		return elems, true
+	case starlark.Tuple:
+		return x, true
+	}
+
+	return nil, false
+}
+
+func (c *typedConverter) convertElems(elems []starlark.Value, elemType func(int) *C.PyObject, path string) ([]*C.PyObject, error) {
+	var values []*C.PyObject
+
+	for i, elem := range elems {
+		value, err := c.convert(elem, elemType(i), fmt.Sprintf("%s[%d]", path, i))
+		if err != nil {
+			for _, v := range values {
+				C.Py_DecRef(v)
+			}
+			return nil, err
+		}
+
+		if value != nil {
+			values = append(values, value)
+		}
+	}
+
+	return values, nil
+}
+
+func (c *typedConverter) convertList(x starlark.Value, t *C.PyObject, elemType *C.PyObject, path string) (*C.PyObject, error) {
+	elems, ok := sequenceElems(x)
+	if !ok {
+		c.expected(path, t, x)
+		return nil, nil
+	}
+
+	before := len(c.mismatches)
+	values, err := c.convertElems(elems, func(int) *C.PyObject { return elemType }, path)
+	if err != nil {
+		return nil, err
+	}
+
+	list := C.PyList_New(0)
+	for _, value := range values {
+		// This does not steal references
+		C.PyList_Append(list, value)
+		C.Py_DecRef(value)
+	}
+
+	if len(c.mismatches) > before {
+		C.Py_DecRef(list)
+		return nil, nil
+	}
+
+	return list, nil
+}
+
+// convertSet converts to a frozenset if frozen is true, or to a set. t may be
+// a generic alias like set[int], so it's only used in messages.
+func (c *typedConverter) convertSet(x starlark.Value, t *C.PyObject, frozen bool, elemType *C.PyObject, path string) (*C.PyObject, error) {
+	var elems []starlark.Value
+
+	if set, ok := x.(*starlark.Set); ok {
+		iter := set.Iterate()
+		defer iter.Done()
+
+		var elem starlark.Value
+		for iter.Next(&elem) {
+			elems = append(elems, elem)
+		}
+	} else if elems, ok = sequenceElems(x); !ok {
+		c.expected(path, t, x)
+		return nil, nil
+	}
+
+	before := len(c.mismatches)
+	values, err := c.convertElems(elems, func(int) *C.PyObject { return elemType }, path)
+	if err != nil {
+		return nil, err
+	}
+
+	set := C.PySet_New(nil)
+	for i, value := range values {
+		// This does not steal references
+		if C.PySet_Add(set, value) != 0 {
+			C.PyErr_Clear()
+			c.mismatch(fmt.Sprintf("%s[%d]", path, i), "unhashable value %v", elems[i])
+		}
+		C.Py_DecRef(value)
+	}
+
+	if len(c.mismatches) > before {
+		C.Py_DecRef(set)
+		return nil, nil
+	}
+
+	if frozen {
+		frozen := C.PyFrozenSet_New(set)
+		C.Py_DecRef(set)
+		return frozen, nil
+	}
+
+	return set, nil
+}
+
+// convertTuple converts to a tuple with one type per element if elemTypes is
+// non-nil, or to a tuple of any length whose elements are all restType.
+func (c *typedConverter) convertTuple(x starlark.Value, t *C.PyObject, elemTypes []*C.PyObject, restType *C.PyObject, path string) (*C.PyObject, error) {
+	elems, ok := sequenceElems(x)
+	if !ok {
+		c.expected(path, t, x)
+		return nil, nil
+	}
+
+	elemType := func(i int) *C.PyObject {
+		if elemTypes != nil {
+			return elemTypes[i]
+		}
+		return restType
+	}
+
+	if elemTypes != nil && len(elemTypes) != len(elems) {
+		c.mismatch(path, "expected %d items, got %d", len(elemTypes), len(elems))
+		return nil, nil
+	}
+
+	before := len(c.mismatches)
+	values, err := c.convertElems(elems, elemType, path)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(c.mismatches) > before {
+		for _, value := range values {
+			C.Py_DecRef(value)
+		}
+		return nil, nil
+	}
+
+	tuple := C.PyTuple_New(C.Py_ssize_t(len(values)))
+	for i, value := range values {
+		// This "steals" the ref to value so we don't need to DecRef after
+		C.PyTuple_SetItem(tuple, C.Py_ssize_t(i), value)
+	}
+
+	return tuple, nil
+}
+
+func (c *typedConverter) convertDict(x starlark.Value, t *C.PyObject, keyType *C.PyObject, valueType *C.PyObject, path string) (*C.PyObject, error) {
+	mapping, ok := x.(starlark.IterableMapping)
+	if !ok {
+		c.expected(path, t, x)
+		return nil, nil
+	}
+
+	before := len(c.mismatches)
+	dict := C.PyDict_New()
+
+	for _, item := range mapping.Items() {
+		itemPath := keyPath(path, item[0])
+
+		key, err := c.convert(item[0], keyType, itemPath)
+		if err != nil {
+			C.Py_DecRef(dict)
+			return nil, err
+		}
+
+		value, err := c.convert(item[1], valueType, itemPath)
+		if err != nil {
+			if key != nil {
+				C.Py_DecRef(key)
+			}
+			C.Py_DecRef(dict)
+			return nil, err
+		}
+
+		if key != nil && value != nil {
+			// This does not steal references
+			C.PyDict_SetItem(dict, key, value)
+		}
+
+		if key != nil {
+			C.Py_DecRef(key)
+		}
+
+		if value != nil {
+			C.Py_DecRef(value)
+		}
+	}
+
+	if len(c.mismatches) > before {
+		C.Py_DecRef(dict)
+		return nil, nil
+	}
+
+	return dict, nil
+}
+
+// structMembers returns the fields of a Starlark struct or module, or of a
+// dict whose keys are all strings.
+func structMembers(x starlark.Value) (starlark.StringDict, bool) {
+	switch x := x.(type) {
+	case *starlarkstruct.Struct:
+		members := starlark.StringDict{}
+		x.ToStringDict(members)
+		return members, true
+	case *starlarkstruct.Module:
+		return x.Members, true
+	case starlark.IterableMapping:
+		members := starlark.StringDict{}
+		for _, item := range x.Items() {
+			key, ok := item[0].(starlark.String)
+			if !ok {
+				return nil, false
+			}
+			members[string(key)] = item[1]
+		}
+		return members, true
+	}
+
+	return nil, false
+}
+
+func (c *typedConverter) convertDataclass(x starlark.Value, t *C.PyObject, path string) (*C.PyObject, error) {
+	members, ok := structMembers(x)
+	if !ok {
+		c.expected(path, t, x)
+		return nil, nil
+	}
+
+	hints := c.callTyping("get_type_hints", t)
+	if hints == nil {
+		return nil, fmt.Errorf("Python exception while getting type hints for %s", pythonTypeName(t))
+	}
+	defer C.Py_DecRef(hints)
+
+	fields := callPython(c.state.module.DataclassFields, t)
+	if fields == nil {
+		return nil, fmt.Errorf("Python exception while getting fields of %s", pythonTypeName(t))
+	}
+	defer C.Py_DecRef(fields)
+
+	before := len(c.mismatches)
+	kwargs := C.PyDict_New()
+	defer C.Py_DecRef(kwargs)
+	known := map[string]bool{}
+
+	for i := C.Py_ssize_t(0); i < C.PyTuple_Size(fields); i++ {
+		field := C.PyTuple_GetItem(fields, i)
+
+		init := getPythonAttr(field, "init")
+		if init == nil {
+			return nil, fmt.Errorf("Python exception while getting fields of %s", pythonTypeName(t))
+		}
+		isInit := C.PyObject_IsTrue(init) == 1
+		C.Py_DecRef(init)
+
+		if !isInit {
+			continue
+		}
+
+		pyname := getPythonAttr(field, "name")
+		if pyname == nil {
+			return nil, fmt.Errorf("Python exception while getting fields of %s", pythonTypeName(t))
+		}
+		defer C.Py_DecRef(pyname)
+
+		name, err := pythonToStarlarkString(pyname)
+		if err != nil {
+			return nil, err
+		}
+		known[string(name)] = true
+		fieldPath := keyPath(path, name)
+
+		member, ok := members[string(name)]
+		if !ok {
+			if !c.dataclassFieldHasDefault(field) {
+				c.mismatch(fieldPath, "missing required field")
+			}
+			continue
+		}
+
+		// This is a borrowed reference
+		hint := C.PyDict_GetItem(hints, pyname)
+		if hint == nil {
+			hint = c.anyType
+		}
+
+		value, err := c.convert(member, hint, fieldPath)
+		if err != nil {
+			return nil, err
+		}
+
+		if value != nil {
+			// This does not steal references
+			C.PyDict_SetItem(kwargs, pyname, value)
+			C.Py_DecRef(value)
+		}
+	}
+
+	for _, name := range members.Keys() {
+		if !known[name] {
+			c.mismatch(keyPath(path, starlark.String(name)), "unexpected field")
+		}
+	}
+
+	if len(c.mismatches) > before {
+		return nil, nil
+	}
+
+	args := C.PyTuple_New(0)
+	defer C.Py_DecRef(args)
+
+	retval := C.PyObject_Call(t, args, kwargs)
+	if retval == nil {
+		return nil, fmt.Errorf("Python exception while creating %s", pythonTypeName(t))
+	}
+
+	return retval, nil
+}
+
+func (c *typedConverter) dataclassFieldHasDefault(field *C.PyObject) bool {
+	for _, attr := range []string{"default", "default_factory"} {
+		value := getPythonAttr(field, attr)
+		if value == nil {
+			C.PyErr_Clear()
+			continue
+		}
+		C.Py_DecRef(value)
+
+		if value != c.state.module.DataclassMissing {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (c *typedConverter) convertTypedDict(x starlark.Value, t *C.PyObject, path string) (*C.PyObject, error) {
+	members, ok := structMembers(x)
+	if _, isMapping := x.(starlark.IterableMapping); !ok || !isMapping {
+		c.expected(path, t, x)
+		return nil, nil
+	}
+
+	hints := c.callTyping("get_type_hints", t)
+	if hints == nil {
+		return nil, fmt.Errorf("Python exception while getting type hints for %s", pythonTypeName(t))
+	}
+	defer C.Py_DecRef(hints)
+
+	required := getPythonAttr(t, "__required_keys__")
+	if required == nil {
+		return nil, fmt.Errorf("Python exception while getting required keys of %s", pythonTypeName(t))
+	}
+	defer C.Py_DecRef(required)
+
+	before := len(c.mismatches)
+	dict := C.PyDict_New()
+
+	for _, name := range members.Keys() {
+		fieldPath := keyPath(path, starlark.String(name))
+
+		cname := C.CString(name)
+		defer C.free(unsafe.Pointer(cname))
+
+		// This is a borrowed reference
+		hint := C.PyDict_GetItemString(hints, cname)
+		if hint == nil {
+			c.mismatch(fieldPath, "unexpected key")
+			continue
+		}
+
+		value, err := c.convert(members[name], hint, fieldPath)
+		if err != nil {
+			C.Py_DecRef(dict)
+			return nil, err
+		}
+
+		if value != nil {
+			// This does not steal references
+			C.PyDict_SetItemString(dict, cname, value)
+			C.Py_DecRef(value)
+		}
+	}
+
+	var (
+		pos   C.Py_ssize_t
+		pykey *C.PyObject
+		hint  *C.PyObject
+	)
+	for C.PyDict_Next(hints, &pos, &pykey, &hint) != 0 {
+		key, err := pythonToStarlarkString(pykey)
+		if err != nil {
+			C.Py_DecRef(dict)
+			return nil, err
+		}
+
+		if _, ok := members[string(key)]; !ok && C.PySet_Contains(required, pykey) == 1 {
+			c.mismatch(keyPath(path, key), "missing required key")
+		}
+	}
+
+	if len(c.mismatches) > before {
+		C.Py_DecRef(dict)
+		return nil, nil
+	}
+
+	return dict, nil
+}
+
+func (state *StarlarkState) raiseTypeMismatch(err typeMismatchError) {
+	items := C.PyTuple_New(C.Py_ssize_t(len(err)))
+	defer C.Py_DecRef(items)
+
+	for i, m := range err {
+		msg := C.CString(m.msg)
+		defer C.free(unsafe.Pointer(msg))
+
+		path := C.CString(m.path)
+		defer C.free(unsafe.Pointer(path))
+
+		C.PyTuple_SetItem(items, C.Py_ssize_t(i), C.makeConversionErrorItem(state.module, msg, path))
+	}
+
+	error_msg := C.CString(err.Error())
+	defer C.free(unsafe.Pointer(error_msg))
+
+	error_type := C.CString(reflect.TypeOf(err).String())
+	defer C.free(unsafe.Pointer(error_type))
+
+	exc_args := C.makeConversionToTypeFailedArgs(error_msg, error_type, items)
+	C.PyErr_SetObject(state.module.ConversionToTypeFailed, exc_args)
+	C.Py_DecRef(exc_args)
+}
+
+func (state *StarlarkState) starlarkValueToPythonType(x starlark.Value, t *C.PyObject) (*C.PyObject, error) {
+	c, err := newTypedConverter(state)
+	if err != nil {
+		handleConversionError(err, state.module.ConversionToPythonFailed)
+		return nil, err
+	}
+	defer c.Close()
+
+	value, err := c.convert(x, t, "$")
+	if err != nil {
+		if value != nil {
+			C.Py_DecRef(value)
+		}
+		handleConversionError(err, state.module.ConversionToPythonFailed)
+		return nil, err
+	}
+
+	if len(c.mismatches) > 0 {
+		if value != nil {
+			C.Py_DecRef(value)
+		}
+		err := typeMismatchError(c.mismatches)
+		state.raiseTypeMismatch(err)
+		return nil, err
+	}
+
+	return value, nil
+}
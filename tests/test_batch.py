@@ -0,0 +1,126 @@
+import threading
+
+import pytest
+
+from starlark_go import (
+    ConversionToStarlarkFailed,
+    EvalError,
+    EvalTimeoutError,
+    FileOptions,
+    ResolveError,
+    Starlark,
+    SyntaxError,
+    run_batch,
+    runtime_stats,
+    set_gomaxprocs,
+)
+
+
+def test_results():
+    jobs = [
+        ("x = base + 1", "a.star", {"base": 1}),
+        ("x = base + 2\ndef f():\n    return x", "b.star", {"base": 10}),
+        ("y = 3", None, None),
+    ]
+    a, b, c = run_batch(jobs)
+
+    assert isinstance(a, Starlark)
+    assert dict(a) == {"base": 1, "x": 2}
+    assert b["x"] == 12
+    assert b.eval("f()") == 12
+    assert c.globals() == ["y"]
+
+
+def test_empty():
+    assert run_batch([]) == []
+
+
+def test_errors():
+    results = run_batch(
+        [
+            ("x = 1 // 0", "div.star", None),
+            ("x = (", "syntax.star", None),
+            ("x = nope", "resolve.star", None),
+            ("x = 1", None, {"bad": object()}),
+            ("x = 1", None, None),
+        ]
+    )
+
+    assert isinstance(results[0], EvalError)
+    assert results[0].filename == "div.star"
+    assert isinstance(results[1], SyntaxError)
+    assert isinstance(results[2], ResolveError)
+    assert isinstance(results[3], ConversionToStarlarkFailed)
+    assert results[4]["x"] == 1
+
+
+def test_bad_jobs():
+    with pytest.raises(TypeError, match="tuples"):
+        run_batch([("x = 1",)])
+
+    with pytest.raises(TypeError, match="source"):
+        run_batch([(1, None, None)])
+
+    with pytest.raises(ValueError):
+        run_batch([], workers=0)
+
+
+def test_many_jobs():
+    jobs = [(f"n = {i}\nsquare = n * n", f"{i}.star", None) for i in range(500)]
+    results = run_batch(jobs, workers=8)
+    assert [r["square"] for r in results] == [i * i for i in range(500)]
+
+
+def test_too_many_workers():
+    goroutines = []
+
+    def count(i):
+        goroutines.append(runtime_stats()["goroutines"])
+        return i
+
+    jobs = [(f"x = count({i})", None, {"count": count}) for i in range(200)]
+    previous = set_gomaxprocs(2)
+    try:
+        before = runtime_stats()["goroutines"]
+        results = run_batch(jobs, workers=10000)
+    finally:
+        set_gomaxprocs(previous)
+
+    assert [r["x"] for r in results] == list(range(200))
+    # No more workers than GOMAXPROCS, plus the one that forwards SIGINT
+    assert max(goroutines) <= before + 2 + 1
+
+
+def test_python_callbacks():
+    lock = threading.Lock()
+    calls = []
+
+    def record(i):
+        # The GIL is held, so callbacks never overlap
+        assert lock.acquire(blocking=False)
+        calls.append(i)
+        lock.release()
+        return i * 2
+
+    jobs = [(f"x = record({i})", None, {"record": record}) for i in range(100)]
+    results = run_batch(jobs, workers=4)
+    assert [r["x"] for r in results] == [i * 2 for i in range(100)]
+    assert sorted(calls) == list(range(100))
+
+
+def test_print_and_options():
+    printed = []
+    results = run_batch(
+        [('print("hi")\nx = set([1])', None, None)],
+        print=printed.append,
+        options=FileOptions(allow_set=True),
+    )
+    assert printed == ["hi"]
+    assert results[0].eval("len(x)") == 1
+
+
+def test_timeout():
+    spin = "def spin():\n    return len([i for i in range(100000000)])\nx = spin()"
+    results = run_batch([(spin, None, None), ("x = 1", None, None)], timeout=0.1)
+    assert isinstance(results[0], EvalTimeoutError)
+    assert results[1]["x"] == 1
@@ -17,6 +17,10 @@ def fibonacci(n):
 """
 
 
+@pytest.mark.xfail(
+    reason="allow_recursion applies when functions are defined since starlark-go "
+    "20231121; see the changelog"
+)
 def test_recursion():
     s = Starlark()
     s.exec(RFIB)
@@ -30,6 +34,24 @@ def test_recursion():
     assert s.eval("fibonacci(10)") == [0, 1, 1, 2, 3, 5, 8, 13, 21, 34]
 
 
+def test_recursion_fixed_when_defined():
+    # Since starlark-go 20231121, recursion is checked against the options a
+    # function was compiled with, so changing the default doesn't affect
+    # functions that were already defined. See CHANGELOG.md.
+    s = Starlark()
+
+    configure_starlark(allow_recursion=False)
+    s.exec(RFIB)
+    configure_starlark(allow_recursion=True)
+    with pytest.raises(EvalError):
+        s.eval("fibonacci(5)")
+
+    configure_starlark(allow_recursion=True)
+    s.exec(RFIB)
+    configure_starlark(allow_recursion=False)
+    assert s.eval("fibonacci(5)") == [0, 1, 1, 2, 3]
+
+
 def test_retention():
     s = Starlark()
 
@@ -4,12 +4,12 @@ import pytest
 
 from starlark_go import ConversionToStarlarkFailed, Starlark
 
-foo = print
-bar = [1, print, 2]
-baz = {"c": print}
+foo = object()
+bar = [1, object(), 2]
+baz = {"c": object()}
 
 
-DONT_KNOW = "Don't know how to convert Python builtin_function_or_method to Starlark"
+DONT_KNOW = "Don't know how to convert Python object to Starlark"
 LIST_INDEX_1 = "While converting value at index 1 in Python list: "
 DICT_KEY_C = 'While converting value of key "c" in Python dict: '
 
@@ -0,0 +1,74 @@
+import threading
+
+import pytest
+
+from starlark_go import ConversionToStarlarkFailed, ResolveError, Starlark
+
+
+def test_locals():
+    s = Starlark(globals={"threshold": 10})
+    assert s.eval("value > threshold", locals={"value": 11}) is True
+    assert s.eval("value > threshold", locals={"value": 9}) is False
+    assert s.globals() == ["threshold"]
+
+    with pytest.raises(ResolveError):
+        s.eval("value")
+
+
+def test_locals_hide_globals():
+    s = Starlark(globals={"x": 1})
+    assert s.eval("x", locals={"x": 2}) == 2
+    assert s.get("x") == 1
+
+
+def test_empty_and_none():
+    s = Starlark(globals={"x": 1})
+    assert s.eval("x", locals={}) == 1
+    assert s.eval("x", locals=None) == 1
+
+
+def test_functions():
+    s = Starlark(globals={"factor": 2})
+    s.exec("def scale(v):\n    return v * factor")
+    assert s.eval("scale(n) + inc(1)", locals={"n": 3, "inc": lambda x: x + 1}) == 8
+
+
+def test_bad_locals():
+    s = Starlark()
+
+    with pytest.raises(TypeError, match="mapping"):
+        s.eval("1", locals=1)
+
+    with pytest.raises(TypeError, match="must be str"):
+        s.eval("1", locals={1: 2})
+
+    with pytest.raises(ConversionToStarlarkFailed):
+        s.eval("1", locals={"x": object()})
+
+
+def test_threads():
+    s = Starlark(globals={"threshold": 50})
+    errors = []
+
+    def worker(start):
+        for value in range(start, start + 100):
+            if s.eval("value > threshold", locals={"value": value}) != (value > 50):
+                errors.append(value)
+
+    threads = [threading.Thread(target=worker, args=(i * 10,)) for i in range(8)]
+    for t in threads:
+        t.start()
+    for t in threads:
+        t.join()
+
+    assert errors == []
+    assert s.globals() == ["threshold"]
+
+
+def test_subprocess():
+    s = Starlark(isolation="subprocess", globals={"threshold": 10})
+    try:
+        assert s.eval("value > threshold", locals={"value": 11}) is True
+        assert s.globals() == ["threshold"]
+    finally:
+        s.close()
@@ -0,0 +1,115 @@
+import pytest
+
+from starlark_go import (
+    ConversionToStarlarkFailed,
+    EvalError,
+    EvalTimeoutError,
+    ResolveError,
+    Starlark,
+    SyntaxError,
+)
+
+
+def test_results():
+    s = Starlark(globals={"threshold": 10})
+    records = [{"value": v} for v in (5, 10, 15)]
+    assert s.eval_many("value > threshold", records) == [False, False, True]
+    assert s.globals() == ["threshold"]
+
+
+def test_empty():
+    s = Starlark()
+    assert s.eval_many("x", []) == []
+
+
+def test_records_hide_globals():
+    s = Starlark(globals={"x": 1, "y": 10})
+    assert s.eval_many("x + y", [{"x": 2}, {}, {"y": 20}]) == [12, 11, 21]
+
+
+def test_generator_and_functions():
+    s = Starlark()
+    s.exec("def double(v):\n    return v * 2")
+    records = ({"v": i, "inc": lambda x: x + 1} for i in range(3))
+    assert s.eval_many("inc(double(v))", records) == [1, 3, 5]
+
+
+def test_lambdas_and_comprehensions():
+    s = Starlark()
+    records = [{"xs": [1, 2, 3], "n": 2}, {"xs": [4], "n": 3}]
+    assert s.eval_many("[(lambda y: y * n)(x) for x in xs]", records) == [
+        [2, 4, 6],
+        [12],
+    ]
+
+
+def test_missing_names():
+    s = Starlark()
+    with pytest.raises(ResolveError, match="undefined: b"):
+        s.eval_many("a + b", [{"a": 1, "b": 2}, {"a": 1}])
+
+    with pytest.raises(ResolveError):
+        s.eval_many("nope", [{"a": 1}])
+
+
+def test_errors():
+    s = Starlark()
+    with pytest.raises(EvalError):
+        s.eval_many("1 // x", [{"x": 1}, {"x": 0}])
+
+    with pytest.raises(SyntaxError):
+        s.eval_many("1 +", [{}])
+
+    with pytest.raises(TypeError, match="mappings"):
+        s.eval_many("1", [1])
+
+
+def test_return_exceptions():
+    s = Starlark()
+    records = [{"x": 1}, {"x": 0}, {}, {"x": object()}, {"x": 4}]
+    results = s.eval_many("4 // x", records, return_exceptions=True)
+
+    assert results[0] == 4
+    assert isinstance(results[1], EvalError)
+    assert isinstance(results[2], ResolveError)
+    assert isinstance(results[3], ConversionToStarlarkFailed)
+    assert results[4] == 1
+
+
+def test_conversion():
+    s = Starlark()
+    assert s.eval_many("x", [{"x": 1}], convert=False) == ["1"]
+    assert s.eval_many("x", [{"x": [1, 2]}], result_type=tuple) == [(1, 2)]
+
+
+def test_timeout():
+    s = Starlark()
+    s.exec("def spin(n):\n    return len([i for i in range(n)])")
+    with pytest.raises(EvalTimeoutError):
+        s.eval_many(
+            "spin(n)",
+            [{"n": 10000000}] * 100,
+            timeout=0.1,
+            return_exceptions=True,
+        )
+
+
+def test_print():
+    printed = []
+    s = Starlark()
+    s.eval_many("print(x)", [{"x": 1}, {"x": 2}], print=printed.append)
+    assert printed == ["1", "2"]
+
+
+def test_subprocess():
+    s = Starlark(isolation="subprocess", globals={"threshold": 10})
+    try:
+        results = s.eval_many(
+            "value > threshold",
+            [{"value": 11}, {"value": 9}, {}],
+            return_exceptions=True,
+        )
+        assert results[:2] == [True, False]
+        assert isinstance(results[2], ResolveError)
+    finally:
+        s.close()
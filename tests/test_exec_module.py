@@ -0,0 +1,118 @@
+import gc
+import weakref
+from types import SimpleNamespace
+
+import pytest
+
+from starlark_go import EvalError, ResolveError, Starlark
+
+PRELUDE = """
+def double(x):
+    return x * 2
+
+base = {"port": 80}
+"""
+
+
+def test_results_are_separate():
+    s = Starlark()
+    s.exec(PRELUDE)
+
+    module = s.exec_module("port = double(base['port'])\nname = 'web'")
+    assert isinstance(module, Starlark)
+    assert sorted(module.globals()) == ["name", "port"]
+    assert dict(module) == {"name": "web", "port": 160}
+    assert sorted(s.globals()) == ["base", "double"]
+
+
+def test_siblings_do_not_clobber_each_other():
+    s = Starlark()
+    s.exec(PRELUDE)
+
+    a = s.exec_module("value = double(1)")
+    b = s.exec_module("value = double(2)")
+    assert a["value"] == 2
+    assert b["value"] == 4
+
+
+def test_shadowing_globals():
+    s = Starlark(globals={"x": 1})
+    module = s.exec_module("x = 2\ny = x")
+    assert module["x"] == 2
+    assert module["y"] == 2
+    assert s["x"] == 1
+
+
+def test_globals_are_frozen():
+    s = Starlark()
+    s.exec(PRELUDE)
+
+    with pytest.raises(EvalError, match="frozen"):
+        s.exec_module("base['port'] = 8080")
+
+
+def test_functions():
+    s = Starlark()
+    s.exec(PRELUDE)
+    s.set(triple=lambda x: x * 3)
+
+    module = s.exec_module("def f(x):\n    return double(x) + triple(x)")
+    assert module.eval("f(1)") == 5
+
+    # The module only has what it defined
+    with pytest.raises(ResolveError):
+        module.eval("double(1)")
+
+
+def test_module_outlives_parent():
+    s = Starlark()
+    s.set(triple=lambda x: x * 3)
+    module = s.exec_module("def f(x):\n    return triple(x)")
+    del s
+
+    assert module.eval("f(2)") == 6
+
+
+def test_settings():
+    printed = []
+    s = Starlark(print=printed.append)
+    module = s.exec_module('print("hi")\nx = 1', filename="mod.star")
+    assert printed == ["hi"]
+    assert module.print is s.print
+
+    with pytest.raises(EvalError) as e:
+        s.exec_module("x = 1 // 0", filename="broken.star")
+    assert e.value.filename == "broken.star"
+
+
+def test_module_calls_use_its_settings():
+    seen = []
+    s = Starlark(globals={"record": seen.append, "ns": SimpleNamespace(a=1)})
+    module = s.exec_module("def f():\n    record(ns)")
+    module.struct_factory = dict
+
+    module.eval("f()")
+    s.eval("record(ns)")
+    assert seen == [{"a": 1}, SimpleNamespace(a=1)]
+
+
+def test_module_owns_what_callables_return():
+    made = []
+
+    def make():
+        def callback():
+            return 42
+
+        made.append(weakref.ref(callback))
+        return callback
+
+    s = Starlark(globals={"make": make})
+    module = s.exec_module("def f():\n    return make()()")
+    del s
+    gc.collect()
+
+    assert module.eval("f()") == 42
+
+    del module
+    gc.collect()
+    assert made[0]() is None
@@ -0,0 +1,76 @@
+from starlark_go import ExecResult, Starlark
+
+
+def test_returns_none_by_default():
+    s = Starlark()
+    assert s.exec("x = 1") is None
+
+
+def test_defined_and_replaced():
+    s = Starlark(globals={"a": 1, "b": 2})
+    result = s.exec("b = 3\nc = a + b\n_d = 4", return_result=True)
+
+    assert isinstance(result, ExecResult)
+    assert result.defined == ("_d", "b", "c")
+    assert result.replaced == ("b",)
+    assert result.elapsed >= 0
+    assert result.values is None
+
+
+def test_nothing_defined():
+    s = Starlark()
+    result = s.exec("print", return_result=True)
+    assert result.defined == ()
+    assert result.replaced == ()
+
+
+def test_values():
+    s = Starlark()
+    result = s.exec(
+        "x = [1, 2]\ny = {'a': x}", return_result=True, convert_values=True
+    )
+    assert result.values == {"x": [1, 2], "y": {"a": [1, 2]}}
+
+
+def test_values_not_converted_without_result():
+    s = Starlark()
+    assert s.exec("x = 1", convert_values=True) is None
+
+
+def test_unconvertible_values():
+    s = Starlark()
+    result = s.exec("def f():\n    return 1", return_result=True)
+    assert result.defined == ("f",)
+
+    # Functions can't be converted, so they're given as their representation
+    result = s.exec(
+        "def g():\n    return 2\nx = 3", return_result=True, convert_values=True
+    )
+    assert result.defined == ("g", "x")
+    assert result.values == {"g": "<function g>", "x": 3}
+    assert s.eval("g()") == 2
+
+
+def test_elapsed():
+    s = Starlark()
+    fast = s.exec("x = 1", return_result=True)
+    slow = s.exec(
+        "def f():\n  return [i * i for i in range(200000)]\ny = len(f())",
+        return_result=True,
+    )
+    assert slow.elapsed > fast.elapsed
+
+
+def test_subprocess():
+    s = Starlark(isolation="subprocess")
+    try:
+        s.set(x=1)
+        result = s.exec("x = 2\ny = x + 1", return_result=True, convert_values=True)
+        assert result == ExecResult(
+            defined=("x", "y"),
+            replaced=("x",),
+            elapsed=result.elapsed,
+            values={"x": 2, "y": 3},
+        )
+    finally:
+        s.close()
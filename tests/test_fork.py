@@ -0,0 +1,139 @@
+import copy
+import gc
+import weakref
+from types import SimpleNamespace
+
+from starlark_go import FileOptions, Starlark
+
+PRELUDE = """
+def double(x):
+    return x * 2
+
+table = {str(i): i for i in range(1000)}
+"""
+
+
+def test_fork_shares_globals():
+    parent = Starlark()
+    parent.exec(PRELUDE)
+
+    child = parent.fork()
+    assert isinstance(child, Starlark)
+    assert sorted(child.globals()) == ["double", "table"]
+    assert child.eval("double(table['21'])") == 42
+
+
+def test_fork_is_independent():
+    parent = Starlark()
+    parent.exec(PRELUDE)
+    child = parent.fork()
+
+    child.exec("x = double(1)")
+    child.pop("table")
+    parent.set(y=2)
+
+    assert sorted(parent.globals()) == ["double", "table", "y"]
+    assert sorted(child.globals()) == ["double", "x"]
+
+
+def test_fork_functions_use_parent_globals():
+    parent = Starlark()
+    parent.exec("x = 1")
+    parent.exec("def f():\n    return x")
+    child = parent.fork()
+
+    parent.set(x=2)
+    child.set(x=3)
+    assert child.eval("x") == 3
+    assert child.eval("f()") == 2
+
+
+def test_fork_copies_settings():
+    printed = []
+    parent = Starlark(
+        print=printed.append,
+        struct_factory=dict,
+        options=FileOptions(allow_set=True),
+    )
+
+    child = parent.fork()
+    assert child.print is parent.print
+    assert child.struct_factory is dict
+    assert child.options.allow_set is True
+
+    child.exec("print(len(set([1, 1])))")
+    assert printed == ["1"]
+
+    child.print = None
+    assert parent.print is not None
+
+
+def test_fork_calls_use_its_settings():
+    seen = []
+    parent = Starlark(globals={"record": seen.append, "ns": SimpleNamespace(a=1)})
+
+    child = parent.fork()
+    child.struct_factory = dict
+
+    child.eval("record(ns)")
+    parent.eval("record(ns)")
+    assert seen == [{"a": 1}, SimpleNamespace(a=1)]
+
+
+def test_copy():
+    parent = Starlark()
+    parent.set(x=1)
+
+    child = copy.copy(parent)
+    assert child is not parent
+    assert child.get("x") == 1
+
+
+def test_fork_outlives_parent():
+    parent = Starlark()
+    parent.set(triple=lambda x: x * 3)
+    parent.exec("def six_times(x):\n    return triple(x) * 2")
+
+    child = parent.fork()
+    del parent
+    gc.collect()
+
+    for _ in range(5):
+        child.exec("junk = [str(i) for i in range(100000)]")
+    assert child.eval("six_times(2)") == 12
+
+
+def test_parent_outlives_fork():
+    parent = Starlark()
+    parent.set(triple=lambda x: x * 3)
+
+    for _ in range(10):
+        child = parent.fork()
+        assert child.eval("triple(2)") == 6
+        del child
+    gc.collect()
+
+    assert parent.eval("triple(3)") == 9
+
+
+def test_fork_owns_what_callables_return():
+    made = []
+
+    def make():
+        def callback():
+            return 42
+
+        made.append(weakref.ref(callback))
+        return callback
+
+    parent = Starlark(globals={"make": make})
+    child = parent.fork()
+    del parent
+    gc.collect()
+
+    assert child.eval("make()()") == 42
+
+    # Released along with the fork, rather than held by the parent's state
+    del child
+    gc.collect()
+    assert made[0]() is None
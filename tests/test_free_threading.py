@@ -0,0 +1,38 @@
+import sys
+import sysconfig
+from concurrent.futures import ThreadPoolExecutor
+
+import pytest
+
+from starlark_go import Starlark
+
+free_threaded = bool(sysconfig.get_config_var("Py_GIL_DISABLED"))
+
+
+@pytest.mark.skipif(not free_threaded, reason="requires a free-threaded build")
+def test_gil_stays_disabled():
+    import starlark_go.starlark_go  # noqa: F401
+
+    assert not sys._is_gil_enabled()
+
+
+def test_parallel_callbacks():
+    s = Starlark()
+    s.set(square=lambda x: x * x)
+    s.exec(
+        """
+def total(n):
+    t = 0
+    for i in range(n):
+        t += square(i)
+    return t
+"""
+    )
+
+    def work(n):
+        return s.eval(f"total({n})")
+
+    with ThreadPoolExecutor(8) as pool:
+        results = list(pool.map(work, range(200)))
+
+    assert results == [sum(i * i for i in range(n)) for n in range(200)]
@@ -0,0 +1,68 @@
+import gc
+import weakref
+
+from starlark_go import Starlark
+
+
+def test_weakref():
+    s = Starlark()
+    ref = weakref.ref(s)
+    assert ref() is s
+
+    del s
+    assert ref() is None
+
+
+def test_global_cycle():
+    class Plugin:
+        def __init__(self):
+            self.starlark = Starlark()
+            self.starlark.set(hello=self.hello)
+
+        def hello(self):
+            return "hello"
+
+    plugin = Plugin()
+    assert plugin.starlark.eval("hello()") == "hello"
+
+    ref = weakref.ref(plugin.starlark)
+    del plugin
+    gc.collect()
+    assert ref() is None
+
+
+def test_print_cycle():
+    s = Starlark()
+    output = []
+    s.print = lambda msg: output.append((s, msg))
+    s.exec("print('hi')")
+    assert output == [(s, "hi")]
+
+    ref = weakref.ref(s)
+    del s, output
+    gc.collect()
+    assert ref() is None
+
+
+def test_struct_factory_cycle():
+    s = Starlark()
+    s.struct_factory = lambda **kwargs: s
+
+    ref = weakref.ref(s)
+    del s
+    gc.collect()
+    assert ref() is None
+
+
+def test_subclass():
+    class MyStarlark(Starlark):
+        pass
+
+    s = MyStarlark()
+    s.set(me=lambda: s)
+    s.extra = s
+
+    ref = weakref.ref(s)
+    del s
+    gc.collect()
+    assert ref() is None
@@ -20,3 +20,9 @@ def test_import_evaltimeouterror():
     from starlark_go import EvalError, EvalTimeoutError
 
     assert issubclass(EvalTimeoutError, EvalError)
+
+
+def test_import_conversiontotypefailed():
+    from starlark_go import ConversionToPythonFailed, ConversionToTypeFailed
+
+    assert issubclass(ConversionToTypeFailed, ConversionToPythonFailed)
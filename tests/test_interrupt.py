@@ -0,0 +1,62 @@
+import os
+import signal
+import threading
+
+import pytest
+
+from starlark_go import EvalError, Starlark
+
+FOREVER = """
+def forever():
+    for _ in range(1 << 62):
+        pass
+
+forever()
+"""
+
+
+def interrupt_soon():
+    timer = threading.Timer(0.2, os.kill, (os.getpid(), signal.SIGINT))
+    timer.start()
+    return timer
+
+
+def test_keyboard_interrupt():
+    s = Starlark()
+
+    timer = interrupt_soon()
+    with pytest.raises(KeyboardInterrupt) as e:
+        s.exec(FOREVER)
+    timer.join()
+
+    assert isinstance(e.value.__cause__, EvalError)
+    assert "interrupted" in str(e.value.__cause__)
+
+
+def test_custom_handler():
+    s = Starlark()
+    received = []
+
+    previous = signal.signal(signal.SIGINT, lambda *args: received.append(args[0]))
+    try:
+        timer = interrupt_soon()
+        with pytest.raises(EvalError, match="interrupted"):
+            s.exec(FOREVER)
+        timer.join()
+    finally:
+        signal.signal(signal.SIGINT, previous)
+
+    assert received == [signal.SIGINT]
+
+
+def test_handler_restored():
+    s = Starlark()
+    s.exec("x = 1")
+
+    timer = interrupt_soon()
+    with pytest.raises(KeyboardInterrupt):
+        timer.join()
+        while True:
+            pass
+
+    assert s.eval("x") == 1
@@ -0,0 +1,193 @@
+import copy
+import threading
+from types import SimpleNamespace
+from typing import List
+
+import pytest
+
+from starlark_go import (
+    EvalError,
+    FileOptions,
+    ResolveError,
+    Starlark,
+    SubprocessStarlark,
+    WorkerError,
+    configure_starlark,
+)
+
+
+@pytest.fixture
+def s():
+    s = Starlark(isolation="subprocess")
+    yield s
+    s.close()
+
+
+def test_api(s):
+    assert isinstance(s, SubprocessStarlark)
+
+    s.set(x=1, y=[1, 2])
+    s.exec("z = x + len(y)")
+    assert s.eval("z") == 3
+    assert s.get("y") == [1, 2]
+    assert s.get("missing", 4) == 4
+    assert s.eval("[1, 2]", result_type=List[float]) == [1.0, 2.0]
+    assert sorted(s.globals()) == ["x", "y", "z"]
+    assert sorted(s) == ["x", "y", "z"]
+    assert s.pop("x") == 1
+    assert s.pop("x", None) is None
+
+    s.set(ns=SimpleNamespace(a=1))
+    assert s.get("ns") == SimpleNamespace(a=1)
+
+    with pytest.raises(KeyError):
+        s.get("x")
+
+
+def test_errors(s):
+    with pytest.raises(EvalError, match="division by zero"):
+        s.eval("1 // 0")
+
+    with pytest.raises(ResolveError):
+        s.eval("nope")
+
+    with pytest.raises(TypeError):
+        s.eval(1)
+
+
+def test_callbacks(s):
+    calls = []
+
+    def add(a: int, b: int = 1) -> int:
+        calls.append((a, b))
+        return a + b
+
+    s.set(add=add)
+    assert s.eval("add(1)") == 2
+    assert s.eval("add(2, b=3)") == 5
+    assert calls == [(1, 1), (2, 3)]
+
+    with pytest.raises(EvalError, match="missing argument for a"):
+        s.eval("add()")
+
+    def fail():
+        raise ValueError("nope")
+
+    s.set(fail=fail)
+    with pytest.raises(EvalError, match="nope"):
+        s.eval("fail()")
+
+    # Callbacks can call back into Starlark
+    s.set(nested=lambda: s.eval("add(10)"))
+    assert s.eval("nested()") == 11
+
+
+def test_print(s, capsys):
+    s.exec('print("hello")')
+    assert capsys.readouterr().out == "hello\n"
+
+    printed = []
+    s.print = printed.append
+    s.exec('print("a")')
+    s.exec('print("b")', print=lambda msg: printed.append(msg.upper()))
+    assert printed == ["a", "B"]
+
+
+def test_properties():
+    s = Starlark(
+        isolation="subprocess",
+        options=FileOptions(allow_while=True),
+        struct_factory=dict,
+        globals={"ns": SimpleNamespace(a=1)},
+    )
+    try:
+        assert s.options.allow_while is True
+        assert s.get("ns") == {"a": 1}
+        assert s.struct_factory is dict
+
+        s.struct_factory = None
+        assert s.get("ns") == SimpleNamespace(a=1)
+
+        s.options = FileOptions(allow_while=False)
+        assert s.options.allow_while is False
+    finally:
+        s.close()
+
+
+def test_threads(s):
+    s.exec("def square(n):\n    return n * n")
+
+    results = {}
+
+    def work(i):
+        results[i] = s.eval(f"square({i})")
+
+    threads = [threading.Thread(target=work, args=(i,)) for i in range(4)]
+    for t in threads:
+        t.start()
+    for t in threads:
+        t.join()
+
+    assert results == {i: i * i for i in range(4)}
+
+
+def test_memory_limit():
+    with Starlark(isolation="subprocess", memory_limit=1 << 30) as s:
+        with pytest.raises(WorkerError):
+            s.exec('x = "x" * (1 << 29)\ny = x + x + x')
+
+        with pytest.raises(WorkerError):
+            s.eval("1")
+
+
+def test_cpu_limit():
+    with Starlark(isolation="subprocess", cpu_limit=1) as s:
+        with pytest.raises(WorkerError, match="SIGXCPU|SIGKILL"):
+            s.exec("def f():\n    for _ in range(1 << 62):\n        pass\nf()")
+
+
+def test_closed(s):
+    s.close()
+    with pytest.raises(WorkerError, match="closed"):
+        s.eval("1")
+
+
+def test_bad_arguments():
+    with pytest.raises(ValueError):
+        Starlark(isolation="thread")
+
+    with pytest.raises(ValueError):
+        Starlark(memory_limit=1 << 30)
+
+    assert type(Starlark(isolation=None)) is Starlark
+
+
+def test_created_directly():
+    with SubprocessStarlark(globals={"x": 1}, cpu_limit=10) as s:
+        assert not isinstance(s, Starlark)
+        assert s.eval("x + 1") == 2
+
+        copied = copy.copy(s)
+        try:
+            assert isinstance(copied, SubprocessStarlark)
+            assert copied.get("x") == 1
+        finally:
+            copied.close()
+
+
+def test_configured_defaults():
+    configure_starlark(allow_set=True)
+    try:
+        with SubprocessStarlark() as s:
+            assert s.eval("set([1])") == {1}
+            assert s.options.allow_set is True
+
+            s.options = FileOptions(allow_while=True)
+            assert s.options.allow_set is True
+            assert s.options.allow_while is True
+    finally:
+        configure_starlark(allow_set=False)
+
+    with SubprocessStarlark() as s:
+        with pytest.raises(ResolveError):
+            s.eval("set([1])")
@@ -0,0 +1,124 @@
+from collections.abc import Mapping, MutableMapping
+
+import pytest
+
+from starlark_go import ConversionToStarlarkFailed, Starlark
+
+
+def test_is_mutable_mapping():
+    s = Starlark()
+    assert isinstance(s, MutableMapping)
+    assert isinstance(s, Mapping)
+    assert issubclass(Starlark, MutableMapping)
+
+
+def test_getitem():
+    s = Starlark(globals={"x": 1})
+    assert s["x"] == 1
+
+    with pytest.raises(KeyError):
+        s["y"]
+
+    with pytest.raises(KeyError):
+        s[1]
+
+
+def test_setitem():
+    s = Starlark()
+    s["x"] = [1, 2]
+    assert s.eval("x + [3]") == [1, 2, 3]
+
+    with pytest.raises(TypeError, match="must be str"):
+        s[1] = 2
+
+    with pytest.raises(ConversionToStarlarkFailed):
+        s["y"] = object()
+    assert "y" not in s
+
+
+def test_setitem_freezes():
+    s = Starlark()
+    s["x"] = [1]
+    with pytest.raises(Exception, match="frozen"):
+        s.exec("x.append(2)")
+
+
+def test_delitem():
+    s = Starlark(globals={"x": 1})
+    del s["x"]
+    assert s.globals() == []
+
+    with pytest.raises(KeyError):
+        del s["x"]
+
+
+def test_delitem_function():
+    s = Starlark()
+    s.exec("def f():\n    return 1")
+    del s["f"]
+    assert "f" not in s
+
+
+def test_contains_and_len():
+    s = Starlark()
+    assert len(s) == 0
+    assert "x" not in s
+
+    s.set(x=1, y=2)
+    assert len(s) == 2
+    assert "x" in s
+    assert 1 not in s
+
+
+def test_mixin_methods():
+    s = Starlark()
+    s.update({"a": 1}, b=2)
+    assert sorted(s.keys()) == ["a", "b"]
+    assert sorted(s.values()) == [1, 2]
+    assert sorted(s.items()) == [("a", 1), ("b", 2)]
+    assert dict(s) == {"a": 1, "b": 2}
+
+    assert s.setdefault("a", 5) == 1
+    assert s.setdefault("c", 3) == 3
+
+    name, value = s.popitem()
+    assert name not in s
+    assert len(s) == 2
+
+
+def test_clear():
+    s = Starlark(globals={"x": 1})
+    s.exec("def f():\n    return x")
+    s.clear()
+    assert len(s) == 0
+    assert s.globals() == []
+
+
+def test_identity_equality():
+    s1 = Starlark()
+    s2 = Starlark()
+    assert s1 != s2
+    assert s1 == s1
+    assert len({s1, s2}) == 2
+
+
+def test_subprocess():
+    s = Starlark(isolation="subprocess")
+    try:
+        assert isinstance(s, MutableMapping)
+        s["x"] = 1
+        s.update(y=2)
+        assert s["x"] + s["y"] == 3
+        assert "x" in s
+        assert len(s) == 2
+        assert dict(s.items()) == {"x": 1, "y": 2}
+
+        del s["x"]
+        with pytest.raises(KeyError):
+            s["x"]
+
+        s.clear()
+        assert len(s) == 0
+        assert len({s}) == 1
+    finally:
+        s.close()
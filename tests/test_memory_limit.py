@@ -0,0 +1,46 @@
+import pytest
+
+from starlark_go import EvalError, EvalMemoryLimitError, Starlark
+
+GROW = """
+def grow():
+    chunks = []
+    for i in range(1 << 20):
+        chunks.append("x" * (1 << 20) + str(i))
+grow()
+"""
+
+LIMIT = 256 << 20
+
+
+def test_exec_memory_limit():
+    s = Starlark()
+    with pytest.raises(EvalMemoryLimitError, match="memory limit exceeded"):
+        s.exec(GROW, max_memory=LIMIT)
+
+
+def test_eval_memory_limit():
+    s = Starlark()
+    s.exec(GROW.replace("grow()\n", ""))
+    with pytest.raises(EvalMemoryLimitError, match="memory limit exceeded"):
+        s.eval("grow()", max_memory=LIMIT)
+
+
+def test_memory_limit_is_eval_error():
+    s = Starlark()
+    with pytest.raises(EvalError):
+        s.exec(GROW, max_memory=LIMIT)
+
+
+def test_memory_limit_not_exceeded():
+    s = Starlark()
+    s.exec("x = [i * i for i in range(1000)]", max_memory=LIMIT)
+    assert s.eval("len(x)", max_memory=LIMIT) == 1000
+
+
+def test_usable_after_memory_limit():
+    s = Starlark()
+    s.exec("x = 1")
+    with pytest.raises(EvalMemoryLimitError):
+        s.exec(GROW, max_memory=LIMIT)
+    assert s.eval("x + 1") == 2
@@ -0,0 +1,101 @@
+import pytest
+
+from starlark_go import (
+    EvalError,
+    FileOptions,
+    ResolveError,
+    Starlark,
+    configure_starlark,
+)
+
+LOOP = """
+n = 0
+while n < 3:
+    n += 1
+"""
+
+RECURSIVE = """
+def fact(n):
+    return 1 if n <= 1 else n * fact(n - 1)
+"""
+
+
+@pytest.fixture(autouse=True)
+def defaults():
+    configure_starlark(allow_set=False, allow_global_reassign=False, allow_recursion=False)
+    yield
+    configure_starlark(allow_set=False, allow_global_reassign=False, allow_recursion=False)
+
+
+def test_instance_options():
+    strict = Starlark()
+    permissive = Starlark(
+        options=FileOptions(
+            allow_set=True,
+            allow_global_reassign=True,
+            allow_recursion=True,
+            allow_while=True,
+            allow_top_level_control=True,
+        )
+    )
+
+    permissive.exec(LOOP)
+    assert permissive.get("n") == 3
+    assert permissive.eval("set([1, 1])") == {1}
+    permissive.exec(RECURSIVE)
+    assert permissive.eval("fact(4)") == 24
+
+    with pytest.raises(ResolveError):
+        strict.exec(LOOP)
+    with pytest.raises(ResolveError):
+        strict.eval("set([1, 1])")
+    strict.exec(RECURSIVE)
+    with pytest.raises(EvalError):
+        strict.eval("fact(4)")
+
+
+def test_call_options():
+    s = Starlark(options=FileOptions(allow_set=True))
+
+    assert s.eval("set([1])") == {1}
+    with pytest.raises(ResolveError):
+        s.eval("set([1])", options=FileOptions(allow_set=False))
+
+    with pytest.raises(ResolveError):
+        s.exec("x = 1\nx = 2")
+    s.exec("x = 1\nx = 2", options=FileOptions(allow_global_reassign=True))
+    assert s.get("x") == 2
+
+
+def test_inheritance():
+    s = Starlark(options=FileOptions(allow_while=True))
+
+    # Top-level while loops need both
+    with pytest.raises(ResolveError):
+        s.exec(LOOP)
+    s.exec(
+        LOOP,
+        options=FileOptions(allow_top_level_control=True, allow_global_reassign=True),
+    )
+
+    configure_starlark(allow_set=True)
+    assert s.eval("set([1])") == {1}
+    with pytest.raises(ResolveError):
+        s.eval("set([1])", options=FileOptions(allow_set=False))
+
+
+def test_options_property():
+    s = Starlark()
+    assert s.options == FileOptions()
+
+    s.options = FileOptions(allow_set=True, load_binds_globally=False)
+    assert s.options == FileOptions(allow_set=True, load_binds_globally=False)
+
+    s.options = None
+    assert s.options == FileOptions()
+
+    with pytest.raises(TypeError):
+        s.options = {"allow_set": True}
+
+    with pytest.raises(TypeError):
+        s.eval("1", options={"allow_set": True})
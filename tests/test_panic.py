@@ -0,0 +1,26 @@
+import pytest
+
+from starlark_go import EvalError, Starlark, StarlarkError, StarlarkInternalError
+from starlark_go.starlark_go import _panic
+
+
+def test_panic():
+    with pytest.raises(StarlarkInternalError, match="^boom$") as e:
+        _panic("boom")
+
+    assert isinstance(e.value, StarlarkError)
+    assert e.value.error_type == "string"
+    assert "goroutine" in e.value.go_stack
+    assert "StarlarkGo_panic" in e.value.go_stack
+
+
+def test_panic_in_builtin():
+    s = Starlark(globals={"panic": _panic})
+    s.exec("x = 1")
+
+    with pytest.raises(EvalError, match="_panic:0:0: boom$"):
+        s.eval('panic("boom")')
+
+    # The object can still be used afterwards
+    assert s.eval("x + 1") == 2
+    assert s.eval('panic("boom") if False else 3') == 3
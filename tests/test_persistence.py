@@ -0,0 +1,174 @@
+import io
+import pickle
+from dataclasses import dataclass
+from types import SimpleNamespace
+
+import pytest
+
+from starlark_go import FileOptions, ResolveError, Starlark
+
+
+@dataclass
+class Point:
+    x: int
+    y: int
+
+
+def roundtrip(s, **kwargs):
+    fp = io.BytesIO()
+    s.dump(fp)
+    fp.seek(0)
+    return type(s).load_state(fp, **kwargs)
+
+
+def test_values():
+    s = Starlark()
+    s.set(a=1, b=[1, 2.5, "three"], c={"d": (True, None)}, p=Point(1, 2))
+    s.exec("e = a + len(b)")
+
+    loaded = roundtrip(s)
+    assert sorted(loaded.globals()) == ["a", "b", "c", "e", "p"]
+    assert loaded.get("b") == [1, 2.5, "three"]
+    assert loaded.get("c") == {"d": (True, None)}
+    assert loaded.get("p") == SimpleNamespace(x=1, y=2)
+    assert loaded.eval("e") == 4
+
+
+def test_functions():
+    s = Starlark()
+    s.exec("def helper(x):\n    return x + 1")
+    s.exec("def f(x):\n    return helper(x) * 2\nresult = f(1)")
+
+    loaded = roundtrip(s)
+    assert sorted(loaded.globals()) == ["f", "helper", "result"]
+    assert loaded.eval("f(2)") == 6
+    assert loaded.get("result") == 4
+
+
+def test_removed_dependencies_are_saved():
+    s = Starlark()
+    s.exec("base = 1")
+    s.exec("y = base + 1")
+    s.pop("base")
+    s.exec("x = 1")
+
+    loaded = roundtrip(s)
+    assert sorted(loaded.globals()) == ["x", "y"]
+    assert loaded.get("y") == 2
+
+
+def test_redefined_values_are_saved():
+    s = Starlark()
+    s.exec("x = 1\ndef f():\n    return 1")
+    s.set(x=5)
+    s.exec("def f():\n    return 2")
+
+    loaded = roundtrip(s)
+    assert loaded.get("x") == 5
+    assert loaded.eval("f()") == 2
+
+
+def test_values_changed_after_use():
+    s = Starlark()
+    s.exec("x = 1")
+    s.exec("def f():\n    return x\ny = x * 10")
+    s.set(x=2)
+
+    # The code is run again with x = 2, but y keeps the value it was saved with
+    loaded = roundtrip(s)
+    assert loaded.eval("f()") == 2
+    assert loaded.get("y") == 10
+    assert s.eval("f()") == 2
+
+
+def test_removed_values_stay_removed():
+    s = Starlark()
+    s.exec("def f():\n    return 1\ntmp = 2")
+    s.pop("tmp")
+    s.exec("def g():\n    return f()")
+    s.exec("def f():\n    return 3")
+
+    loaded = roundtrip(s)
+    assert sorted(loaded.globals()) == ["f", "g"]
+    with pytest.raises(ResolveError):
+        loaded.eval("tmp")
+
+
+def test_print_not_called_when_loading():
+    printed = []
+    s = Starlark(print=printed.append)
+    s.exec('print("hi")\ndef f():\n    return 1')
+    assert printed == ["hi"]
+
+    loaded = roundtrip(s, print=printed.append)
+    assert printed == ["hi"]
+    loaded.exec('print("again")')
+    assert printed == ["hi", "again"]
+
+
+def test_options():
+    s = Starlark(options=FileOptions(allow_set=True))
+    s.exec(
+        "def f():\n    x = 0\n    while x < 3:\n        x += 1\n    return x",
+        options=FileOptions(allow_while=True),
+    )
+
+    loaded = roundtrip(s)
+    assert loaded.options.allow_set is True
+    assert loaded.eval("f()") == 3
+
+
+def test_restored_snapshot():
+    s = Starlark()
+    s.exec("def f():\n    return 1")
+    snap = s.snapshot()
+    s.exec("def g():\n    return 2")
+    s.restore(snap)
+
+    loaded = roundtrip(s)
+    assert loaded.globals() == ["f"]
+    assert loaded.eval("f()") == 1
+
+
+def test_pickle():
+    s = Starlark()
+    s.set(x=[1, 2])
+    s.exec("def f():\n    return x + [3]")
+
+    loaded = pickle.loads(pickle.dumps(s))
+    assert isinstance(loaded, Starlark)
+    assert loaded is not s
+    assert loaded.eval("f()") == [1, 2, 3]
+
+
+def test_unsupported_version():
+    fp = io.BytesIO()
+    pickle.dump({"version": 0}, fp)
+    fp.seek(0)
+
+    with pytest.raises(ValueError, match="version"):
+        Starlark.load_state(fp)
+
+
+def test_fork():
+    s = Starlark()
+    s.exec("def f():\n    return 1")
+    child = s.fork()
+    child.exec("def g():\n    return f() + 1")
+
+    loaded = roundtrip(child)
+    assert loaded.eval("g()") == 2
+    assert roundtrip(s).globals() == ["f"]
+
+
+def test_subprocess():
+    s = Starlark(isolation="subprocess")
+    try:
+        s.exec("def f(x):\n    return x * 2\ny = f(21)")
+        loaded = roundtrip(s)
+        try:
+            assert loaded.eval("f(y)") == 84
+        finally:
+            loaded.close()
+    finally:
+        s.close()
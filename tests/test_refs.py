@@ -0,0 +1,77 @@
+import gc
+import sys
+import weakref
+
+from starlark_go import Starlark
+
+
+def churn(s):
+    # Allocate enough in Starlark that the Go garbage collector runs
+    s.exec("junk = [str(i) for i in range(100000)]")
+
+
+def test_replaced_functions_are_released():
+    s = Starlark()
+    refs = []
+
+    for i in range(20):
+
+        def callback(x, i=i):
+            return x + i
+
+        refs.append(weakref.ref(callback))
+        s.set(callback=callback)
+        del callback
+        churn(s)
+
+    assert s.eval("callback(1)") == 20
+
+    gc.collect()
+    alive = [r for r in refs if r() is not None]
+    assert refs[-1]() is not None
+    assert len(alive) < len(refs) / 2
+
+
+def test_nested_functions_are_released():
+    s = Starlark()
+    refs = []
+
+    for i in range(20):
+        fn = lambda: None  # noqa: E731
+        refs.append(weakref.ref(fn))
+        s.set(handlers={"fn": [fn]})
+        del fn
+        churn(s)
+
+    gc.collect()
+    alive = [r for r in refs if r() is not None]
+    assert len(alive) < len(refs) / 2
+
+
+def test_shared_function():
+    def shared():
+        return 1
+
+    s = Starlark()
+    before = sys.getrefcount(shared)
+
+    for _ in range(20):
+        s.set(a=shared, b=shared)
+        churn(s)
+
+    assert s.eval("a() + b()") == 2
+    del s
+    assert sys.getrefcount(shared) == before
+
+
+def test_dealloc_releases_functions():
+    def fn():
+        pass
+
+    s = Starlark(globals={"fn": fn})
+    ref = weakref.ref(fn)
+    del fn
+    del s
+
+    gc.collect()
+    assert ref() is None
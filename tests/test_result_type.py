@@ -0,0 +1,146 @@
+from dataclasses import dataclass, field
+from typing import Any, Dict, FrozenSet, List, Optional, Tuple, TypedDict, Union
+
+import pytest
+
+from starlark_go import (
+    ConversionToPythonFailed,
+    ConversionToTypeFailed,
+    Starlark,
+)
+
+
+@dataclass
+class Port:
+    number: int
+    protocol: str = "tcp"
+
+
+@dataclass
+class Server:
+    name: str
+    ports: List[Port] = field(default_factory=list)
+    weight: float = 1.0
+    labels: Dict[str, str] = field(default_factory=dict)
+    backup: Optional["Server"] = None
+
+
+class Limits(TypedDict, total=False):
+    cpu: float
+    memory: int
+
+
+class Job(TypedDict):
+    command: List[str]
+    limits: Limits
+
+
+CONFIG = """
+server = {
+    "name": "web",
+    "ports": [{"number": 80}, {"number": 443, "protocol": "tcp"}],
+    "weight": 2,
+    "backup": {"name": "spare"},
+}
+"""
+
+
+def test_dataclass():
+    s = Starlark()
+    s.exec(CONFIG)
+
+    assert s.get("server", result_type=Server) == Server(
+        name="web",
+        ports=[Port(80), Port(443)],
+        weight=2.0,
+        backup=Server(name="spare"),
+    )
+
+    assert s.eval('{"name": "api"}', result_type=Server) == Server(name="api")
+
+    s.set(port=Port(8080, "udp"))
+    assert s.get("port", result_type=Port) == Port(8080, "udp")
+
+
+def test_typeddict():
+    s = Starlark()
+
+    job = s.eval(
+        '{"command": ["echo", "hi"], "limits": {"memory": 1024}}', result_type=Job
+    )
+    assert job == {"command": ["echo", "hi"], "limits": {"memory": 1024}}
+
+    with pytest.raises(ConversionToTypeFailed) as e:
+        s.eval('{"command": ["echo"]}', result_type=Job)
+    assert [(i.path, i.msg) for i in e.value.errors] == [
+        ("$.limits", "missing required key")
+    ]
+
+
+def test_generics():
+    s = Starlark()
+
+    assert s.eval("[1, 2, 3]", result_type=List[int]) == [1, 2, 3]
+    assert s.eval("[1, 2, 3]", result_type=list[float]) == [1.0, 2.0, 3.0]
+    assert s.eval("(1, 'a')", result_type=Tuple[int, str]) == (1, "a")
+    assert s.eval("[1, 2]", result_type=tuple[int, ...]) == (1, 2)
+    assert s.eval("[1, 1, 2]", result_type=set[int]) == {1, 2}
+    assert type(s.eval("[1]", result_type=set[int])) is set
+    assert s.eval("[1, 1, 2]", result_type=frozenset[int]) == frozenset({1, 2})
+    assert type(s.eval("[1]", result_type=FrozenSet[int])) is frozenset
+    assert s.eval("{'a': [1]}", result_type=dict[str, list[int]]) == {"a": [1]}
+    assert s.eval("None", result_type=Optional[int]) is None
+    assert s.eval("1", result_type=int | None) == 1
+    assert s.eval("'a'", result_type=Union[int, str]) == "a"
+    assert s.eval("[1, 'a']", result_type=List[Any]) == [1, "a"]
+    assert s.eval("True", result_type=bool) is True
+    assert s.eval("b'x'", result_type=bytes) == b"x"
+
+    with pytest.raises(ConversionToTypeFailed, match=r"^\$: expected int, got bool$"):
+        s.eval("True", result_type=int)
+
+    with pytest.raises(ConversionToTypeFailed, match=r"^\$: expected 2 items, got 3$"):
+        s.eval("(1, 2, 3)", result_type=Tuple[int, int])
+
+
+def test_mismatches():
+    s = Starlark()
+    s.exec(
+        """
+server = {
+    "name": 7,
+    "ports": [{"number": 80}, {"number": "443"}, {}],
+    "labels": {"env": "prod", "my label": 1},
+    "color": "blue",
+}
+"""
+    )
+
+    with pytest.raises(ConversionToTypeFailed) as e:
+        s.get("server", result_type=Server)
+
+    assert isinstance(e.value, ConversionToPythonFailed)
+    assert [(i.path, i.msg) for i in e.value.errors] == [
+        ("$.name", "expected str, got int"),
+        ("$.ports[1].number", "expected int, got string"),
+        ("$.ports[2].number", "missing required field"),
+        ('$.labels["my label"]', "expected str, got int"),
+        ("$.color", "unexpected field"),
+    ]
+    assert str(e.value).startswith("$.name: expected str, got int; ")
+
+
+def test_unsupported_type():
+    s = Starlark()
+
+    with pytest.raises(ConversionToPythonFailed) as e:
+        s.eval("1", result_type=complex)
+    assert not isinstance(e.value, ConversionToTypeFailed)
+
+
+def test_no_result_type():
+    s = Starlark(globals={"x": [1, 2]})
+
+    assert s.get("x", result_type=None) == [1, 2]
+    assert s.get("y", 3, result_type=int) == 3
+    assert s.eval("x", convert=False, result_type=Server) == "[1, 2]"
@@ -0,0 +1,102 @@
+import pytest
+
+from starlark_go import (
+    Starlark,
+    runtime_stats,
+    set_gc_percent,
+    set_gomaxprocs,
+    set_memory_limit,
+)
+
+INT_STATS = [
+    "goroutines",
+    "gomaxprocs",
+    "num_gc",
+    "num_forced_gc",
+    "heap_alloc",
+    "heap_sys",
+    "heap_objects",
+    "next_gc",
+    "total_alloc",
+    "sys",
+]
+
+
+def test_runtime_stats():
+    s = Starlark()
+    s.exec("junk = [str(i) for i in range(100000)]")
+
+    stats = runtime_stats()
+    for name in INT_STATS:
+        assert isinstance(stats[name], int), name
+        assert stats[name] >= 0, name
+    assert isinstance(stats["gc_pause_total"], float)
+    assert stats["goroutines"] > 0
+    assert stats["heap_alloc"] > 0
+    assert stats["sys"] >= stats["heap_sys"]
+
+
+def test_runtime_stats_total_alloc_grows():
+    s = Starlark()
+    before = runtime_stats()["total_alloc"]
+    s.exec("junk = [str(i) for i in range(100000)]")
+    assert runtime_stats()["total_alloc"] > before
+
+
+def test_set_gomaxprocs():
+    previous = set_gomaxprocs(1)
+    try:
+        assert runtime_stats()["gomaxprocs"] == 1
+        assert Starlark().eval("1 + 2") == 3
+    finally:
+        assert set_gomaxprocs(previous) == 1
+
+    assert runtime_stats()["gomaxprocs"] == previous
+
+
+def test_set_gomaxprocs_invalid():
+    with pytest.raises(ValueError):
+        set_gomaxprocs(0)
+
+
+def test_set_gc_percent():
+    previous = set_gc_percent(50)
+    try:
+        assert runtime_stats()["gc_percent"] == 50
+        assert set_gc_percent(None) == 50
+        assert runtime_stats()["gc_percent"] is None
+        assert set_gc_percent(200) is None
+    finally:
+        set_gc_percent(previous)
+
+    assert runtime_stats()["gc_percent"] == previous
+
+
+def test_set_gc_percent_invalid():
+    with pytest.raises(ValueError):
+        set_gc_percent(-1)
+    with pytest.raises(TypeError):
+        set_gc_percent("100")
+
+
+def test_set_memory_limit():
+    previous = set_memory_limit(1 << 30)
+    try:
+        assert runtime_stats()["memory_limit"] == 1 << 30
+        assert set_memory_limit(None) == 1 << 30
+        assert runtime_stats()["memory_limit"] is None
+        assert set_memory_limit(1 << 100) is None
+        assert runtime_stats()["memory_limit"] is None
+    finally:
+        set_memory_limit(previous)
+
+    assert runtime_stats()["memory_limit"] == previous
+
+
+def test_set_memory_limit_invalid():
+    with pytest.raises(ValueError):
+        set_memory_limit(-1)
+    with pytest.raises(ValueError):
+        set_memory_limit(-(1 << 100))
+    with pytest.raises(TypeError):
+        set_memory_limit(1.5)
@@ -1,5 +1,5 @@
 import functools
-import sys
+import os
 
 import pytest
 
@@ -188,15 +188,15 @@ def test_func():
     assert s.eval("func(10)") == 20
     assert s.eval("func(x = 10)") == 20
 
-    with pytest.raises(EvalError, match=r"<builtin> in func_impl:0:0: got zero"):
-        s.eval("func(0)")
+    name = "test_func.<locals>.func_impl"
 
-    name = "func_impl" if sys.version_info < (3, 10) else "test_func.<locals>.func_impl"
+    with pytest.raises(EvalError, match=r"<builtin> in " + name + r":0:0: got zero"):
+        s.eval("func(0)")
 
-    with pytest.raises(EvalError, match=r"<builtin> in func_impl:0:0: " + name + r"\(\) missing 1 required positional argument: 'x'"):
+    with pytest.raises(EvalError, match=r"<expr> in <expr>:1:5: " + name + r": missing argument for x"):
         s.eval("func()")
 
-    with pytest.raises(EvalError, match=r"<builtin> in func_impl:0:0: " + name + r"\(\) got an unexpected keyword argument 'unknown'"):
+    with pytest.raises(EvalError, match=r"<expr> in <expr>:1:5: " + name + r": unexpected keyword argument unknown"):
         s.eval("func(unknown=0)")
 
 
@@ -242,15 +242,15 @@ def test_method():
     s.exec("func(10)")
     assert test.result == 20
 
-    with pytest.raises(EvalError, match=r"<builtin> in func_impl:0:0: got zero"):
-        s.exec("func(0)")
+    name = "test_method.<locals>.Test.func_impl"
 
-    name = "func_impl" if sys.version_info < (3, 10) else "test_method.<locals>.Test.func_impl"
+    with pytest.raises(EvalError, match=r"<builtin> in " + name + r":0:0: got zero"):
+        s.exec("func(0)")
 
-    with pytest.raises(EvalError, match=r"<builtin> in func_impl:0:0: " + name + r"\(\) missing 1 required positional argument: 'x'"):
+    with pytest.raises(EvalError, match=r"<expr> in <expr>:1:5: " + name + r": missing argument for x"):
         s.eval("func()")
 
-    with pytest.raises(EvalError, match=r"<builtin> in func_impl:0:0: " + name + r"\(\) got an unexpected keyword argument 'unknown'"):
+    with pytest.raises(EvalError, match=r"<expr> in <expr>:1:5: " + name + r": unexpected keyword argument unknown"):
         s.eval("func(unknown=0)")
 
 
@@ -304,3 +304,47 @@ def test_closures():
     for i in range(5):
         assert s.eval(f"foo_dict[{i}](1, 2, 3)") == (1, 2, 3)
     assert s.eval("test(1, 2, 3)") == (1, 2, 3)
+
+
+def test_callables():
+    class Multiplier:
+        def __init__(self, factor):
+            self.factor = factor
+
+        def __call__(self, x):
+            return x * self.factor
+
+    class Point:
+        def __init__(self, x, y):
+            self.x = x
+            self.y = y
+
+        def __repr__(self):
+            return "Point()"
+
+    def add(x, y):
+        return x + y
+
+    double = Multiplier(2)
+    add_one = functools.partial(add, 1)
+
+    s = Starlark()
+    s.set(
+        pylen=len,
+        join=os.path.join,
+        add_one=add_one,
+        double=double,
+        Point=Point,
+    )
+
+    assert s.eval("pylen([1, 2, 3])") == 3
+    assert s.eval("join('a', 'b')") == os.path.join("a", "b")
+    assert s.eval("add_one(2)") == 3
+    assert s.eval("double(21)") == 42
+    assert s.eval("add_one", convert=False) == "<built-in function " + repr(add_one) + ">"
+    assert s.eval("double", convert=False) == "<built-in function " + repr(double) + ">"
+    assert s.eval("pylen", convert=False) == "<built-in function len>"
+    assert s.eval("Point", convert=False) == "<built-in function test_callables.<locals>.Point>"
+
+    with pytest.raises(EvalError, match="Don't know how to convert Python Point to Starlark"):
+        s.eval("Point(1, 2)")
@@ -0,0 +1,88 @@
+from dataclasses import dataclass
+from typing import List, Optional
+
+import pytest
+
+from starlark_go import EvalError, Starlark
+
+
+def greet(name, greeting="hello", *, punctuation="!"):
+    return f"{greeting} {name}{punctuation}"
+
+
+def total(*values, **weights):
+    return sum(values) + sum(weights.values())
+
+
+def positional_only(a, /, b):
+    return [a, b]
+
+
+@dataclass
+class Point:
+    x: int
+    y: int
+
+
+def scale(point: Point, factor: float = 1.0, tags: Optional[List[str]] = None):
+    return [point.x * factor, point.y * factor, tags]
+
+
+def test_signature_checks():
+    s = Starlark(globals={"greet": greet, "total": total, "po": positional_only})
+
+    assert s.eval("greet('bob')") == "hello bob!"
+    assert s.eval("greet('bob', 'hi', punctuation='?')") == "hi bob?"
+    assert s.eval("total(1, 2, a=3)") == 6
+    assert s.eval("po(1, b=2)") == [1, 2]
+
+    with pytest.raises(EvalError, match=r"^<expr> in <expr>:1:6: greet: missing argument for name$"):
+        s.eval("greet()")
+
+    with pytest.raises(EvalError, match=r"greet: got 3 arguments, want at most 2$"):
+        s.eval("greet('a', 'b', '!')")
+
+    with pytest.raises(EvalError, match=r"greet: unexpected keyword argument volume$"):
+        s.eval("greet('a', volume=11)")
+
+    with pytest.raises(EvalError, match=r"greet: got multiple values for keyword argument name$"):
+        s.eval("greet('a', name='b')")
+
+    with pytest.raises(EvalError, match=r"positional_only: unexpected keyword argument a$"):
+        s.eval("po(a=1, b=2)")
+
+
+def test_signature_error_location():
+    s = Starlark(globals={"greet": greet})
+    s.exec("def wrapper():\n    return greet()\n", filename="wrapper.star")
+
+    with pytest.raises(EvalError) as e:
+        s.eval("wrapper()")
+
+    assert e.value.filename == "wrapper.star"
+    assert e.value.function_name == "wrapper"
+    assert e.value.line == 2
+    assert str(e.value) == "wrapper.star in wrapper:2:17: greet: missing argument for name"
+
+
+def test_no_coercion_by_default():
+    s = Starlark(globals={"scale": scale})
+
+    with pytest.raises(EvalError, match="'dict' object has no attribute 'x'"):
+        s.eval("scale({'x': 1, 'y': 2})")
+
+
+def test_coercion():
+    s = Starlark(globals={"scale": scale}, coerce_args=True)
+
+    assert s.eval("scale({'x': 1, 'y': 2}, 2)") == [2.0, 4.0, None]
+    assert s.eval("scale(point={'x': 1, 'y': 2}, tags=['a'])") == [1.0, 2.0, ["a"]]
+
+    with pytest.raises(EvalError, match=r"<expr>:1:6: scale: for parameter factor: expected float, got string$"):
+        s.eval("scale({'x': 1, 'y': 2}, 'big')")
+
+    with pytest.raises(
+        EvalError,
+        match=r"scale: for parameter point: \$.y: expected int, got string; \$.z: unexpected field$",
+    ):
+        s.eval("scale({'x': 1, 'y': '2', 'z': 3})")
@@ -0,0 +1,119 @@
+import gc
+import weakref
+
+import pytest
+
+from starlark_go import (
+    ResolveError,
+    Starlark,
+    StarlarkSnapshot,
+    SubprocessSnapshot,
+)
+
+
+def test_restore():
+    s = Starlark()
+    s.exec("x = 1\ndef f():\n    return x * 2")
+    snap = s.snapshot()
+    assert isinstance(snap, StarlarkSnapshot)
+
+    s.exec("y = f()")
+    s.exec("def f():\n    return 0")
+    s.set(x=10)
+    assert s.eval("f()") == 0
+
+    s.restore(snap)
+    assert sorted(s.globals()) == ["f", "x"]
+    assert s.eval("f()") == 2
+    with pytest.raises(ResolveError):
+        s.eval("y")
+
+
+def test_restore_twice():
+    s = Starlark()
+    s.set(a=[1, 2])
+    snap = s.snapshot()
+
+    for i in range(3):
+        s.exec(f"b = a + [{i}]")
+        assert s.get("b") == [1, 2, i]
+        s.restore(snap)
+        assert s.globals() == ["a"]
+
+
+def test_snapshot_is_not_affected_by_changes():
+    s = Starlark()
+    s.exec("x = 1")
+    first = s.snapshot()
+    s.set(x=2)
+    second = s.snapshot()
+
+    s.restore(first)
+    assert s.get("x") == 1
+    s.restore(second)
+    assert s.get("x") == 2
+
+
+def test_restore_keeps_python_functions():
+    s = Starlark()
+    s.set(double=lambda x: x * 2)
+    snap = s.snapshot()
+
+    s.set(double=lambda x: x * 3)
+    for _ in range(5):
+        s.exec("junk = [str(i) for i in range(100000)]")
+    gc.collect()
+
+    s.restore(snap)
+    assert s.eval("double(4)") == 8
+
+
+def test_restore_wrong_object():
+    s1 = Starlark()
+    s2 = Starlark()
+
+    with pytest.raises(ValueError, match="different"):
+        s2.restore(s1.snapshot())
+
+    with pytest.raises(TypeError, match="StarlarkSnapshot"):
+        s1.restore({})
+
+
+def test_cannot_instantiate():
+    with pytest.raises(TypeError):
+        StarlarkSnapshot()
+
+
+def test_snapshot_keeps_owner_alive():
+    s = Starlark()
+    s.set(x=1)
+    snap = s.snapshot()
+    ref = weakref.ref(s)
+
+    del s
+    gc.collect()
+    assert ref() is not None
+
+    del snap
+    gc.collect()
+    assert ref() is None
+
+
+def test_subprocess_restore():
+    s = Starlark(isolation="subprocess")
+    try:
+        s.exec("x = 1")
+        snap = s.snapshot()
+        assert isinstance(snap, SubprocessSnapshot)
+
+        s.exec("y = x + 1")
+        s.restore(snap)
+        assert s.globals() == ["x"]
+
+        del snap
+        assert s.eval("x") == 1
+
+        with pytest.raises(ValueError, match="different"):
+            s.restore(Starlark(isolation="subprocess").snapshot())
+    finally:
+        s.close()
@@ -0,0 +1,89 @@
+from collections import namedtuple
+from dataclasses import dataclass, field
+from types import SimpleNamespace
+from typing import ClassVar, List
+
+import pytest
+
+from starlark_go import ConversionToPythonFailed, ResolveError, Starlark
+
+Point = namedtuple("Point", ["x", "y"])
+
+
+@dataclass
+class Config:
+    name: str
+    replicas: int = 1
+    tags: List[str] = field(default_factory=list)
+    kind: ClassVar[str] = "config"
+
+
+def test_dataclass():
+    s = Starlark()
+
+    s.set(cfg=Config("web", tags=["a", "b"]))
+    assert s.eval("type(cfg)") == "struct"
+    assert s.eval("cfg.name") == "web"
+    assert s.eval("cfg.replicas * 2") == 2
+    assert s.eval("cfg.tags") == ["a", "b"]
+    assert s.eval("dir(cfg)") == ["name", "replicas", "tags"]
+
+    with pytest.raises(ResolveError):
+        s.eval("kind")
+
+    assert s.get("cfg") == SimpleNamespace(name="web", replicas=1, tags=["a", "b"])
+
+
+def test_namedtuple():
+    s = Starlark()
+
+    s.set(p=Point(1, 2))
+    assert s.eval("type(p)") == "struct"
+    assert s.eval("p.x + p.y") == 3
+    assert s.get("p") == SimpleNamespace(x=1, y=2)
+
+    # Plain tuples are still tuples
+    s.set(t=(1, 2))
+    assert s.eval("type(t)") == "tuple"
+
+
+def test_namespace():
+    s = Starlark()
+
+    s.set(ns=SimpleNamespace(a=1, b={"c": Point(3, 4)}))
+    assert s.eval("ns.a") == 1
+    assert s.eval("ns.b['c'].y") == 4
+    assert s.get("ns") == SimpleNamespace(a=1, b={"c": SimpleNamespace(x=3, y=4)})
+
+
+def test_struct_factory():
+    s = Starlark(struct_factory=dict)
+    assert s.struct_factory is dict
+
+    s.set(p=Point(1, 2))
+    assert s.get("p") == {"x": 1, "y": 2}
+
+    s.struct_factory = lambda **kwargs: Point(**kwargs)
+    assert s.get("p") == Point(1, 2)
+
+    s.struct_factory = None
+    assert s.struct_factory is None
+    assert s.get("p") == SimpleNamespace(x=1, y=2)
+
+    with pytest.raises(TypeError):
+        s.struct_factory = 1
+
+    with pytest.raises(TypeError):
+        Starlark(struct_factory=1)
+
+
+def test_struct_factory_fails():
+    def explode(**kwargs):
+        raise ValueError("no thanks")
+
+    s = Starlark(struct_factory=explode)
+    s.set(p=Point(1, 2))
+
+    with pytest.raises(ConversionToPythonFailed) as e:
+        s.get("p")
+    assert isinstance(e.value.__cause__, ValueError)
@@ -0,0 +1,112 @@
+import threading
+
+import pytest
+
+try:
+    import _interpreters as interpreters
+except ImportError:
+    try:
+        import _xxsubinterpreters as interpreters
+    except ImportError:
+        interpreters = None
+
+needs_interpreters = pytest.mark.skipif(
+    interpreters is None, reason="requires subinterpreter support"
+)
+
+SCRIPT = """
+from starlark_go import EvalError, Starlark
+
+s = Starlark()
+s.exec("def double(x):\\n    return x * 2")
+for i in range(100):
+    assert s.eval(f"double({i})") == i * 2
+
+try:
+    s.eval("1 // 0")
+except EvalError:
+    pass
+else:
+    raise AssertionError("EvalError not raised")
+"""
+
+CONFIGURE_SCRIPT = """
+from starlark_go import Starlark, configure_starlark
+
+configure_starlark(allow_set=True)
+assert Starlark().eval("set([1])") == {1}
+"""
+
+BATCH_SCRIPT = """
+try:
+    import _interpreters as interpreters
+except ImportError:
+    import _xxsubinterpreters as interpreters
+
+from starlark_go import run_batch
+
+current = interpreters.get_current()
+
+def check(i):
+    # Callbacks must run in the interpreter that called run_batch
+    assert interpreters.get_current() == current
+    return i
+
+results = run_batch(
+    [(f"x = check({i})", None, {"check": check}) for i in range(20)], workers=4
+)
+assert [r["x"] for r in results] == list(range(20))
+"""
+
+
+def run_in_subinterpreter(script):
+    interp = interpreters.create()
+    try:
+        # Older versions raise on failure, newer ones return the error
+        err = interpreters.run_string(interp, script)
+        assert err is None, err
+    finally:
+        interpreters.destroy(interp)
+
+
+@needs_interpreters
+def test_subinterpreter():
+    from starlark_go import Starlark
+
+    s = Starlark(globals={"x": 1})
+    run_in_subinterpreter(SCRIPT)
+    assert s.eval("x + 1") == 2
+
+
+@needs_interpreters
+def test_parallel_subinterpreters():
+    errors = []
+
+    def work():
+        try:
+            run_in_subinterpreter(SCRIPT)
+        except Exception as e:
+            errors.append(e)
+
+    threads = [threading.Thread(target=work) for _ in range(4)]
+    for t in threads:
+        t.start()
+    for t in threads:
+        t.join()
+
+    assert errors == []
+
+
+@needs_interpreters
+def test_configure_per_interpreter():
+    from starlark_go import ResolveError, Starlark, configure_starlark
+
+    configure_starlark(allow_set=False)
+    run_in_subinterpreter(CONFIGURE_SCRIPT)
+    with pytest.raises(ResolveError):
+        Starlark().eval("set([1])")
+
+
+@needs_interpreters
+def test_batch_in_subinterpreter():
+    run_in_subinterpreter(BATCH_SCRIPT)
@@ -0,0 +1,96 @@
+from concurrent.futures import ThreadPoolExecutor
+
+import pytest
+
+from starlark_go import EvalError, Starlark
+
+THREADS = 8
+ROUNDS = 200
+
+
+def run(fn):
+    with ThreadPoolExecutor(THREADS) as pool:
+        for future in [pool.submit(fn, i) for i in range(THREADS)]:
+            future.result()
+
+
+def test_eval_and_set():
+    s = Starlark()
+    s.exec("def double(x):\n    return x * 2")
+
+    def work(i):
+        for j in range(ROUNDS):
+            s.set(**{f"x{i}": j})
+            assert s.eval(f"double(x{i})") == j * 2
+            assert s.get(f"x{i}") == j
+
+    run(work)
+    assert sorted(s.globals()) == ["double"] + [f"x{i}" for i in range(THREADS)]
+
+
+def test_exec_and_pop():
+    s = Starlark()
+
+    def work(i):
+        for j in range(ROUNDS):
+            s.exec(f"v{i} = [{j}] * 3")
+            assert s.eval(f"v{i}") == [j] * 3
+            assert s.pop(f"v{i}") == [j] * 3
+            assert s.get(f"v{i}", None) is None
+
+    run(work)
+    assert s.globals() == []
+
+
+def test_callbacks():
+    s = Starlark()
+    s.set(limit=10)
+
+    def lookup(name):
+        # Calls back into the same instance while it's evaluating elsewhere
+        return s.get(name)
+
+    s.set(lookup=lookup)
+
+    def work(i):
+        output = []
+        for j in range(ROUNDS // 10):
+            s.set(**{f"y{i}": j})
+            assert s.eval(f"lookup('y{i}') + lookup('limit')") == j + 10
+            s.exec(f"print(lookup('y{i}'))", print=output.append)
+        assert output == [str(j) for j in range(ROUNDS // 10)]
+
+    run(work)
+
+
+def test_set_while_evaluating():
+    s = Starlark(globals={f"g{i}": i for i in range(1000)})
+
+    def work(i):
+        for j in range(ROUNDS):
+            if i % 2:
+                s[f"g{i}"] = j
+                del s[f"g{i}"]
+            else:
+                assert s.eval(f"g{i} + 1") == i + 1
+
+    run(work)
+    assert len(s) == 1000 - THREADS // 2
+
+
+def test_functions_see_changed_globals():
+    s = Starlark()
+    s.exec("x = 1")
+    s.exec("def f():\n    return x")
+    s.set(x=2)
+    assert s.eval("f()") == 2
+    s["x"] = 3
+    assert s.eval("f()") == 3
+
+
+def test_set_from_callback():
+    s = Starlark()
+    s.set(change=lambda: s.set(x=1))
+
+    with pytest.raises(EvalError, match="evaluating code in the same thread"):
+        s.eval("change()")
@@ -0,0 +1,161 @@
+import sys
+
+import pytest
+
+from starlark_go import Starlark
+
+
+class Recorder:
+    def __init__(self):
+        self.calls = []
+
+    def __call__(self, name, old, new):
+        self.calls.append((name, old, new))
+
+
+def test_set_and_pop():
+    s = Starlark(globals={"x": 1})
+    rec = Recorder()
+    s.watch(rec)
+
+    s.set(x=2, y=3)
+    assert rec.calls == [("x", 1, 2), ("y", None, 3)]
+
+    rec.calls.clear()
+    s.pop("x")
+    assert rec.calls == [("x", 2, None)]
+
+
+def test_unchanged_values_are_not_reported():
+    s = Starlark()
+    s.exec("x = [1, 2]\nt = (1, 2)")
+    rec = Recorder()
+    s.watch(rec)
+
+    s.exec("y = len(x) + len(t)")
+    assert rec.calls == [("y", None, 4)]
+
+
+def test_mapping_and_clear():
+    s = Starlark(globals={"a": 1})
+    rec = Recorder()
+    s.watch(rec)
+
+    s["b"] = 2
+    del s["a"]
+    s.clear()
+    assert rec.calls == [("b", None, 2), ("a", 1, None), ("b", 2, None)]
+
+
+def test_exec_functions():
+    s = Starlark()
+    rec = Recorder()
+    s.watch(rec)
+
+    s.exec("def f():\n    return 1")
+    assert rec.calls == [("f", None, "<function f>")]
+
+
+def test_restore():
+    s = Starlark(globals={"x": 1})
+    snap = s.snapshot()
+    s.set(x=2, y=3)
+
+    rec = Recorder()
+    s.watch(rec)
+    s.restore(snap)
+    assert rec.calls == [("x", 2, 1), ("y", 3, None)]
+
+
+def test_names():
+    s = Starlark()
+    rec = Recorder()
+    s.watch(rec, names=["x"])
+
+    s.set(x=1, y=2)
+    assert rec.calls == [("x", None, 1)]
+
+    with pytest.raises(TypeError):
+        s.watch(rec, names="x")
+    with pytest.raises(TypeError):
+        s.watch(rec, names=[1])
+
+
+def test_not_callable():
+    with pytest.raises(TypeError, match="not callable"):
+        Starlark().watch(1)
+
+
+def test_exceptions_are_unraisable():
+    def broken(name, old, new):
+        raise RuntimeError("oops")
+
+    s = Starlark()
+    rec = Recorder()
+    s.watch(broken)
+    s.watch(rec)
+
+    unraisable = []
+    old_hook = sys.unraisablehook
+    sys.unraisablehook = unraisable.append
+    try:
+        s.set(x=1)
+    finally:
+        sys.unraisablehook = old_hook
+
+    assert s.get("x") == 1
+    assert rec.calls == [("x", None, 1)]
+    assert len(unraisable) == 1
+    assert isinstance(unraisable[0].exc_value, RuntimeError)
+
+
+def test_unwatch():
+    s = Starlark()
+    rec = Recorder()
+    s.watch(rec)
+    s.unwatch(rec)
+    s.set(x=1)
+    assert rec.calls == []
+
+    with pytest.raises(ValueError):
+        s.unwatch(rec)
+
+
+def test_unwatch_in_callback():
+    s = Starlark()
+    calls = []
+
+    def once(name, old, new):
+        calls.append(name)
+        s.unwatch(once)
+
+    s.watch(once)
+    s.set(x=1, y=2)
+    s.set(z=3)
+    assert calls == ["x"]
+
+
+def test_fork_does_not_copy_watchers():
+    s = Starlark()
+    rec = Recorder()
+    s.watch(rec)
+
+    child = s.fork()
+    child.set(x=1)
+    assert rec.calls == []
+
+
+def test_subprocess():
+    s = Starlark(isolation="subprocess")
+    try:
+        rec = Recorder()
+        s.watch(rec, names=("x",))
+        s.exec("x = 1\ny = 2")
+        s.set(x=3)
+        assert rec.calls == [("x", None, 1), ("x", 1, 3)]
+
+        s.unwatch(rec)
+        s.set(x=4)
+        assert len(rec.calls) == 2
+    finally:
+        s.close()